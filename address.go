@@ -0,0 +1,246 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Address is an email address with an optional display name, like
+// José <jose@example.com>.
+type Address struct {
+	Name  string
+	Email string
+}
+
+// ParseAddress parses an address as written in a header, like
+// "José <jose@example.com>" or "jose@example.com".
+func ParseAddress(address string) (Address, error) {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return Address{}, err
+	}
+
+	return Address{Name: a.Name, Email: a.Address}, nil
+}
+
+// String returns the address as written in a header: the name is quoted
+// if it has special characters and encoded as an RFC 2047 word if it has
+// non-ASCII ones.
+func (a Address) String() string {
+	return a.format(false)
+}
+
+// format returns the address as written in a header, with a non-ASCII
+// name encoded unless utf8 is set.
+func (a Address) format(utf8 bool) string {
+	if a.Name == "" {
+		return a.Email
+	}
+
+	name := a.Name
+	switch {
+	case !utf8 && !isASCII(name):
+		name = encodeWord(name)
+	case !isPhrase(name):
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	return name + " <" + a.Email + ">"
+}
+
+// isPhrase reports whether name can be written unquoted as a sequence of
+// RFC 5322 atoms. Non-ASCII characters are allowed by RFC 6532.
+func isPhrase(name string) bool {
+	if strings.TrimSpace(name) != name || strings.Contains(name, "  ") {
+		return false
+	}
+
+	for _, r := range name {
+		if r >= 0x80 || r == ' ' ||
+			'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' ||
+			strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r) {
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// idna returns the address with its domain in punycode.
+func (a Address) idna() Address {
+	a.Email = idnaAddress(a.Email)
+	return a
+}
+
+// parseAddresses parses a list of addresses as written in a header. The
+// ones that can't be parsed are kept as the email of the address.
+func parseAddresses(list []string) []Address {
+	addresses := make([]Address, len(list))
+	for i, address := range list {
+		a, err := ParseAddress(address)
+		if err != nil {
+			a = Address{Email: address}
+		}
+		addresses[i] = a
+	}
+
+	return addresses
+}
+
+// addressValues returns the names and emails of the addresses, for
+// checkHeaders.
+func addressValues(addresses ...Address) []string {
+	var values []string
+	for _, a := range addresses {
+		values = append(values, a.Name, a.Email)
+	}
+
+	return values
+}
+
+// AddressError reports an entry of an address list that isn't valid.
+type AddressError struct {
+	// Index is the position of the entry in the list, starting at 0.
+	Index int
+	Entry string
+	Err   error
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid address %q: %v", e.Entry, e.Err)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// AddressListError is returned by ParseAddressList with every invalid
+// entry of the list.
+type AddressListError []*AddressError
+
+func (e AddressListError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// ParseAddressList parses a list of addresses separated by commas or
+// semicolons, as pasted from a mail client, like
+// "Tom <tom@example.com>, jerry@example.com". Empty entries are skipped
+// and repeated emails are only returned once.
+//
+// If some entries are invalid, the error is an AddressListError with all
+// of them, and the valid addresses are returned too.
+func ParseAddressList(list string) ([]Address, error) {
+	var addresses []Address
+	var errs AddressListError
+
+	seen := make(map[string]bool)
+
+	for i, entry := range splitAddressList(list) {
+		if entry == "" {
+			continue
+		}
+
+		a, err := ParseAddress(entry)
+		if err != nil {
+			errs = append(errs, &AddressError{Index: i, Entry: entry, Err: err})
+			continue
+		}
+
+		if key := strings.ToLower(a.Email); !seen[key] {
+			seen[key] = true
+			addresses = append(addresses, a)
+		}
+	}
+
+	if len(errs) > 0 {
+		return addresses, errs
+	}
+
+	return addresses, nil
+}
+
+// splitAddressList splits a list of addresses at the commas and semicolons
+// that aren't quoted, in a comment or between angle brackets.
+func splitAddressList(list string) []string {
+	var entries []string
+	var quoted, escaped, angle bool
+	var comment int
+
+	start := 0
+	for i, r := range list {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quoted || comment > 0):
+			escaped = true
+		case r == '"' && comment == 0:
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			comment++
+		case r == ')' && comment > 0:
+			comment--
+		case comment > 0:
+		case r == '<':
+			angle = true
+		case r == '>':
+			angle = false
+		case (r == ',' || r == ';') && !angle:
+			entries = append(entries, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(entries, strings.TrimSpace(list[start:]))
+}
+
+// AddToList parses a list of addresses with ParseAddressList and adds the
+// ones that aren't in To yet. If any is invalid, none is added.
+func (m *Message) AddToList(list string) error {
+	return addAddressList(&m.To, list)
+}
+
+// AddCcList is like AddToList for Cc.
+func (m *Message) AddCcList(list string) error {
+	return addAddressList(&m.Cc, list)
+}
+
+// AddBccList is like AddToList for Bcc.
+func (m *Message) AddBccList(list string) error {
+	return addAddressList(&m.Bcc, list)
+}
+
+func addAddressList(field *[]Address, list string) error {
+	addresses, err := ParseAddressList(list)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addresses {
+		if !containsEmail(*field, a.Email) {
+			*field = append(*field, a)
+		}
+	}
+
+	return nil
+}
+
+func containsEmail(addresses []Address, email string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
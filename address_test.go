@@ -0,0 +1,125 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	for _, test := range []struct {
+		address  Address
+		expected string
+	}{
+		{Address{Email: "tom@example.com"}, "tom@example.com"},
+		{Address{Name: "Tom Smith", Email: "tom@example.com"}, "Tom Smith <tom@example.com>"},
+		{Address{Name: "Smith, Tom", Email: "tom@example.com"}, `"Smith, Tom" <tom@example.com>`},
+		{Address{Name: `Tom "Cat"`, Email: "tom@example.com"}, `"Tom \"Cat\"" <tom@example.com>`},
+		{Address{Name: "José", Email: "jose@example.com"}, "=?utf-8?q?Jos=C3=A9?= <jose@example.com>"},
+	} {
+		if s := test.address.String(); s != test.expected {
+			t.Fatalf("expected %s, got %s", test.expected, s)
+		}
+
+		if parsed, err := ParseAddress(test.expected); err != nil || parsed != test.address {
+			t.Fatalf("expected %s to parse as %+v, got %+v, %v", test.expected, test.address, parsed, err)
+		}
+	}
+
+	if _, err := ParseAddress("not an address"); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+}
+
+func TestAddressSetters(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+
+	if err := m.SetFrom("Smith, Tom <tom@example.com>"); err == nil {
+		t.Fatal("expected an error for an unquoted comma")
+	}
+
+	if err := m.SetFrom(`"Smith, Tom" <tom@example.com>`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetSender("noreply@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddTo("José <jose@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddReplyTo("help@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+	for _, expected := range []string{
+		"From: \"Smith, Tom\" <tom@example.com>\r\n",
+		"Sender: noreply@example.com\r\n",
+		"To: =?utf-8?q?Jos=C3=A9?= <jose@example.com>\r\n",
+		"Reply-To: help@example.com\r\n",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %q:\n%s", expected, data)
+		}
+	}
+
+	if to := m.Tolist(); len(to) != 1 || to[0] != "jose@example.com" {
+		t.Fatalf("expected the recipient email, got %q", to)
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	addresses, err := ParseAddressList(`"Smith, Tom" <tom@example.com>, jerry@example.com; Jerry <JERRY@example.com>,, boss@example.com (the boss, really)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Address{
+		{Name: "Smith, Tom", Email: "tom@example.com"},
+		{Email: "jerry@example.com"},
+		{Name: "the boss, really", Email: "boss@example.com"},
+	}
+	if len(addresses) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, addresses)
+	}
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected, addresses)
+		}
+	}
+
+	addresses, err = ParseAddressList("tom@example.com, not an address, jerry@")
+
+	var errs AddressListError
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Index != 1 || errs[0].Entry != "not an address" || errs[1].Index != 2 {
+		t.Fatalf("expected errors for the invalid entries, got %v", err)
+	}
+
+	if len(addresses) != 1 || addresses[0].Email != "tom@example.com" {
+		t.Fatalf("expected the valid addresses too, got %+v", addresses)
+	}
+}
+
+func TestAddAddressList(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.To = []Address{{Email: "tom@example.com"}}
+
+	if err := m.AddToList("Tom <TOM@example.com>, jerry@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddCcList("cc@example.com; bad"); err == nil {
+		t.Fatal("expected an error for an invalid entry")
+	}
+
+	if err := m.AddBccList("bcc@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.To) != 2 || len(m.Cc) != 0 || len(m.Bcc) != 1 {
+		t.Fatalf("unexpected recipients: %+v %+v %+v", m.To, m.Cc, m.Bcc)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// APIError is returned by the senders of HTTP APIs, like SendGridSender,
+// when the provider rejects a request.
+type APIError struct {
+	// Provider is the name of the API, like "sendgrid".
+	Provider string
+
+	StatusCode int
+
+	// Message is the error the provider returned, usually JSON.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("email: %s: %d %s: %s", e.Provider, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// maxAPIResponse limits the size of the API responses that are read.
+const maxAPIResponse = 1 << 20
+
+// postJSON posts in as JSON to the URL and decodes the response into out,
+// if it isn't nil. Responses without a 2xx status are an APIError.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, header http.Header, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doAPI(client, req, provider, out)
+}
+
+// doAPI sends the request and decodes the response into out, if it isn't
+// nil. Responses without a 2xx status are an APIError.
+func doAPI(client *http.Client, req *http.Request, provider string, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIResponse))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{Provider: provider, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+// ErrNoRecipients is returned when a message without To, Cc or Bcc
+// addresses is sent.
+var ErrNoRecipients = errors.New("email: missing recipients")
+
+var errNoFrom = errors.New("email: missing From address")
+
+// apiMessage checks what the APIs that build the message require: a From
+// address, valid headers and, if recipients is set, To, Cc or Bcc
+// addresses, which aren't needed when the API takes the recipients apart.
+// It returns the message with the MessageID that it is sent with, as
+// withMessageID does.
+func (m *Message) apiMessage(recipients bool) (*Message, error) {
+	if m.From.Email == "" {
+		return nil, errNoFrom
+	}
+
+	if recipients && len(m.Tolist()) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	return m.withMessageID(), nil
+}
+
+// apiParts returns the body and the alternatives as they are sent, with
+// the CSS inlined and tracking added. The message must have the MessageID
+// that it is sent with, as apiMessage returns it, for the tracking tokens
+// to carry it.
+func (m *Message) apiParts() []*Alternative {
+	parts := m.bodyParts()
+	for i, part := range parts {
+		rendered := *part
+		rendered.Body = m.render(part.ContentType, part.Body)
+		parts[i] = &rendered
+	}
+
+	return parts
+}
+
+// apiAttachments returns the attachments as they are sent, bundled into
+// the Zip archive if the message has one.
+func (m *Message) apiAttachments() []*Attachment {
+	if m.Zip == nil {
+		return m.Attachments
+	}
+
+	mixed, related := m.splitAttachments()
+	return append(m.Zip.bundle(mixed, m.date()), related...)
+}
+
+// apiHeaders returns the headers that APIs that build the message take as
+// custom headers: the Message-ID, those generated from the threading, read
+// receipt, unsubscribe and priority fields, and the Headers that aren't
+// reserved.
+func (m *Message) apiHeaders() Headers {
+	var h Headers
+
+	if m.MessageID != "" {
+		h.Add("Message-ID", m.MessageID)
+	}
+
+	if m.InReplyTo != "" {
+		h.Add("In-Reply-To", m.InReplyTo)
+	}
+
+	if len(m.References) > 0 {
+		h.Add("References", strings.Join(m.References, " "))
+	}
+
+	if len(m.ReadReceiptTo) > 0 {
+		h.Add("Disposition-Notification-To", strings.Join(m.ReadReceiptTo, ", "))
+	}
+
+	if len(m.ListUnsubscribe) > 0 {
+		uris := make([]string, len(m.ListUnsubscribe))
+		for i, uri := range m.ListUnsubscribe {
+			uris[i] = "<" + uri + ">"
+		}
+		h.Add("List-Unsubscribe", strings.Join(uris, ", "))
+
+		if m.ListUnsubscribeOneClick {
+			h.Add("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
+	}
+
+	switch m.Priority {
+	case PriorityHigh:
+		h.Add("X-Priority", "1 (Highest)")
+		h.Add("Importance", "High")
+	case PriorityLow:
+		h.Add("X-Priority", "5 (Lowest)")
+		h.Add("Importance", "Low")
+	}
+
+	for _, field := range m.Headers {
+		key := textproto.CanonicalMIMEHeaderKey(field.Key)
+		if !reservedHeaders[key] && validFieldName(key) {
+			h = append(h, HeaderField{Key: key, Value: field.Value})
+		}
+	}
+
+	return h
+}
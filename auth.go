@@ -0,0 +1,176 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+// errUnencryptedAuth is returned by the Auths that refuse to send their
+// credentials over a connection without TLS.
+var errUnencryptedAuth = errors.New("smtp: unencrypted connection")
+
+// isLocalhost reports whether the server is on this host, where the
+// credentials can be sent without TLS, as smtp.PlainAuth allows.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+type xoauth2Auth struct {
+	username string
+	token    func() (string, error)
+}
+
+// XOAuth2Auth returns an Auth that implements the XOAUTH2 mechanism of
+// Gmail and Office 365, which authenticate with an OAuth 2.0 access token
+// instead of a password. The token function is called for each session,
+// so that it can return a fresh token; with golang.org/x/oauth2:
+//
+//	auth := email.XOAuth2Auth("alice@example.com", func() (string, error) {
+//		t, err := tokenSource.Token()
+//		if err != nil {
+//			return "", err
+//		}
+//		return t.AccessToken, nil
+//	})
+//
+// Like smtp.PlainAuth, it only sends the token over TLS, or to localhost.
+func XOAuth2Auth(username string, token func() (string, error)) smtp.Auth {
+	return &xoauth2Auth{username, token}
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errUnencryptedAuth
+	}
+
+	token, err := a.token()
+	if err != nil {
+		return "", nil, err
+	}
+
+	resp := []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01")
+
+	return "XOAUTH2", resp, nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server rejected the token with a challenge that has the
+		// details as JSON. It expects an empty response, after which it
+		// fails with the error code.
+		return []byte{}, nil
+	}
+
+	return nil, nil
+}
+
+// CRAMMD5Auth returns an Auth that implements the CRAM-MD5 mechanism of
+// RFC 2195, like smtp.CRAMMD5Auth. The password isn't sent, only a hash of
+// it and a challenge of the server, so relays accept it without TLS.
+func CRAMMD5Auth(username, secret string) smtp.Auth {
+	return smtp.CRAMMD5Auth(username, secret)
+}
+
+type loginAuth struct {
+	username, password string
+}
+
+// LoginAuth returns an Auth that implements the LOGIN mechanism, the only
+// one that many Exchange and Office 365 relays offer: the server asks for
+// the username and then for the password.
+//
+// Like smtp.PlainAuth, it only sends the credentials over TLS, or to
+// localhost.
+func LoginAuth(username, password string) smtp.Auth {
+	return &loginAuth{username, password}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errUnencryptedAuth
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("smtp: unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+type autoAuth struct {
+	username, password string
+	token              func() (string, error)
+
+	mu   sync.Mutex
+	auth smtp.Auth
+}
+
+// AutoAuth returns an Auth that uses the strongest mechanism that the
+// server offers among those the credentials allow: XOAUTH2 if there is a
+// token function, and CRAM-MD5, PLAIN or LOGIN, in this order, if there is
+// a password. Either can be empty or nil.
+//
+// An SMTPSender chooses the mechanism for each session. Used directly with
+// net/smtp, an AutoAuth must not be shared by concurrent sessions.
+func AutoAuth(username, password string, token func() (string, error)) smtp.Auth {
+	return &autoAuth{username: username, password: password, token: token}
+}
+
+// choose returns the Auth of the strongest mechanism offered by the
+// server with the given host name.
+func (a *autoAuth) choose(mechanisms []string, host string) (smtp.Auth, error) {
+	offered := make(map[string]bool)
+	for _, mechanism := range mechanisms {
+		offered[strings.ToUpper(mechanism)] = true
+	}
+
+	switch {
+	case a.token != nil && offered["XOAUTH2"]:
+		return XOAuth2Auth(a.username, a.token), nil
+	case a.password != "" && offered["CRAM-MD5"]:
+		return CRAMMD5Auth(a.username, a.password), nil
+	case a.password != "" && offered["PLAIN"]:
+		return smtp.PlainAuth("", a.username, a.password, host), nil
+	case a.password != "" && offered["LOGIN"]:
+		return LoginAuth(a.username, a.password), nil
+	}
+
+	return nil, fmt.Errorf("smtp: no supported AUTH mechanism among %v", mechanisms)
+}
+
+func (a *autoAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	auth, err := a.choose(server.Auth, server.Name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	a.mu.Lock()
+	a.auth = auth
+	a.mu.Unlock()
+
+	return auth.Start(server)
+}
+
+func (a *autoAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	a.mu.Lock()
+	auth := a.auth
+	a.mu.Unlock()
+
+	return auth.Next(fromServer, more)
+}
@@ -0,0 +1,133 @@
+package email
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/smtp"
+	"testing"
+)
+
+func TestXOAuth2Auth(t *testing.T) {
+	server := newTestServer(t)
+
+	auth := XOAuth2Auth("alice@example.com", func() (string, error) {
+		return "ya29.token", nil
+	})
+
+	s := &SMTPSender{Addr: server.Addr(), Auth: auth}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := base64.StdEncoding.EncodeToString([]byte("user=alice@example.com\x01auth=Bearer ya29.token\x01\x01"))
+	if n := count(server.Commands(), "AUTH XOAUTH2 "+resp); n != 1 {
+		t.Fatalf("expected the XOAUTH2 response, got %q", server.Commands())
+	}
+
+	// The error challenge is answered with an empty response.
+	if resp, err := auth.Next([]byte(`{"status":"401"}`), true); err != nil || resp == nil || len(resp) != 0 {
+		t.Fatalf("expected an empty response, got %q, %v", resp, err)
+	}
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); !errors.Is(err, errUnencryptedAuth) {
+		t.Fatalf("expected the token not to be sent without TLS, got %v", err)
+	}
+
+	failing := XOAuth2Auth("alice@example.com", func() (string, error) {
+		return "", errors.New("token expired")
+	})
+	if _, _, err := failing.Start(&smtp.ServerInfo{Name: "smtp.example.com", TLS: true}); err == nil {
+		t.Fatal("expected the error of the token")
+	}
+}
+
+func TestCRAMMD5Auth(t *testing.T) {
+	server := newTestServer(t)
+	server.challenges["CRAM-MD5"] = []string{base64.StdEncoding.EncodeToString([]byte("<1896.697170952@postoffice.reston.mci.net>"))}
+
+	s := &SMTPSender{Addr: server.Addr(), Auth: CRAMMD5Auth("tim", "tanstaaftanstaaf")}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The example of RFC 2195.
+	resp := base64.StdEncoding.EncodeToString([]byte("tim b913a602c7eda7a495b4e6e7334d3890"))
+	if n := count(server.Commands(), resp); n != 1 {
+		t.Fatalf("expected the CRAM-MD5 response, got %q", server.Commands())
+	}
+}
+
+func TestLoginAuth(t *testing.T) {
+	server := newTestServer(t)
+	server.challenges["LOGIN"] = []string{"VXNlcm5hbWU6", "UGFzc3dvcmQ6"}
+
+	s := &SMTPSender{Addr: server.Addr(), Auth: LoginAuth("alice", "secret")}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := server.Commands()
+	for _, expected := range []string{"AUTH LOGIN", base64.StdEncoding.EncodeToString([]byte("alice")), base64.StdEncoding.EncodeToString([]byte("secret"))} {
+		if count(commands, expected) != 1 {
+			t.Fatalf("expected %q, got %q", expected, commands)
+		}
+	}
+
+	auth := LoginAuth("alice", "secret")
+	if _, err := auth.Next([]byte("Realm:"), true); err == nil {
+		t.Fatal("expected an error for an unknown challenge")
+	}
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); !errors.Is(err, errUnencryptedAuth) {
+		t.Fatalf("expected the password not to be sent without TLS, got %v", err)
+	}
+}
+
+func TestAutoAuth(t *testing.T) {
+	token := func() (string, error) { return "token", nil }
+
+	tests := []struct {
+		mechanisms []string
+		password   string
+		token      func() (string, error)
+		expected   string
+	}{
+		{[]string{"LOGIN", "PLAIN", "CRAM-MD5", "XOAUTH2"}, "secret", token, "XOAUTH2"},
+		{[]string{"LOGIN", "PLAIN", "CRAM-MD5", "XOAUTH2"}, "secret", nil, "CRAM-MD5"},
+		{[]string{"LOGIN", "PLAIN"}, "secret", token, "PLAIN"},
+		{[]string{"login"}, "secret", nil, "LOGIN"},
+		{[]string{"XOAUTH2"}, "secret", nil, ""},
+	}
+
+	for _, test := range tests {
+		a := AutoAuth("alice", test.password, test.token).(*autoAuth)
+		auth, err := a.choose(test.mechanisms, "localhost")
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("expected no mechanism among %v", test.mechanisms)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mechanism, _, err := auth.Start(&smtp.ServerInfo{Name: "localhost", Auth: test.mechanisms})
+		if err != nil || mechanism != test.expected {
+			t.Errorf("expected %s among %v, got %s, %v", test.expected, test.mechanisms, mechanism, err)
+		}
+	}
+
+	// The test server offers PLAIN.
+	server := newTestServer(t)
+
+	s := &SMTPSender{Addr: server.Addr(), Auth: AutoAuth("alice", "secret", nil)}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if count(server.Commands(), "AUTH PLAIN ") != 1 {
+		t.Fatalf("expected PLAIN, got %q", server.Commands())
+	}
+}
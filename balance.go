@@ -0,0 +1,174 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Relay is one of the senders of a BalancedSender.
+type Relay struct {
+	Sender Sender
+
+	// Weight is the share of the messages that the relay sends, relative
+	// to the others, 1 if zero.
+	Weight int
+}
+
+// BalancedSender distributes messages across several relays, like those
+// of different IP pools, in proportion to their weights, and in turn if
+// they are equal. A relay that can't be reached MaxFails times in a row,
+// as FailoverSender tells, is ejected for EjectTime, and the message goes
+// to another one. If every relay is ejected, they are all tried anyway.
+//
+// It is safe for concurrent use, and the options must not be modified
+// after the first send.
+type BalancedSender struct {
+	Relays []Relay
+
+	// MaxFails is the number of failures in a row that eject a relay, 3
+	// if zero.
+	MaxFails int
+
+	// EjectTime is how long a relay is ejected, 30 seconds if zero.
+	EjectTime time.Duration
+
+	mu     sync.Mutex
+	relays []relayState
+}
+
+const (
+	defaultMaxFails  = 3
+	defaultEjectTime = 30 * time.Second
+)
+
+type relayState struct {
+	// current is the weight of the relay in the smooth weighted
+	// round-robin of nginx: the relay with the highest is picked, and the
+	// total of the weights is taken from it.
+	current int
+
+	fails   int
+	ejected time.Time
+}
+
+// Send sends the message. See SendContext.
+func (b *BalancedSender) Send(m *Message) error {
+	return b.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message with the next relay, or with another one
+// if it can't be reached. If it isn't sent, the error is a FailoverError.
+func (b *BalancedSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := b.SendWithIndex(ctx, m)
+	return err
+}
+
+// SendWithIndex sends the message like SendContext and returns the index
+// in Relays of the one that sent it.
+func (b *BalancedSender) SendWithIndex(ctx context.Context, m *Message) (int, error) {
+	if len(b.Relays) == 0 {
+		return -1, errors.New("email: no relays")
+	}
+
+	tried := make([]bool, len(b.Relays))
+	e := &FailoverError{}
+	for {
+		i := b.pick(tried, time.Now())
+		if i < 0 {
+			return -1, e
+		}
+		tried[i] = true
+
+		err := b.Relays[i].Sender.SendContext(ctx, m)
+		b.report(i, err, time.Now())
+		if err == nil {
+			return i, nil
+		}
+
+		e.Errs = append(e.Errs, err)
+		if !unreachable(err) {
+			return -1, e
+		}
+	}
+}
+
+// pick returns the index of the next relay that wasn't tried, or -1 if
+// there is none. Ejected relays are only picked if they all are.
+func (b *BalancedSender) pick(tried []bool, now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.relays == nil {
+		b.relays = make([]relayState, len(b.Relays))
+	}
+
+	healthy := false
+	for i := range b.Relays {
+		healthy = healthy || !now.Before(b.relays[i].ejected)
+	}
+
+	candidates := make([]bool, len(b.Relays))
+	for i := range b.Relays {
+		candidates[i] = !tried[i] && (!healthy || !now.Before(b.relays[i].ejected))
+	}
+
+	best, total := -1, 0
+	for i, relay := range b.Relays {
+		if !candidates[i] {
+			continue
+		}
+
+		weight := relay.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		b.relays[i].current += weight
+		total += weight
+
+		if best < 0 || b.relays[i].current > b.relays[best].current {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	b.relays[best].current -= total
+
+	return best
+}
+
+// report records the result of a send with the relay i, ejecting it if it
+// failed MaxFails times in a row.
+func (b *BalancedSender) report(i int, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := &b.relays[i]
+	if err == nil || !unreachable(err) {
+		state.fails = 0
+		return
+	}
+
+	maxFails := b.MaxFails
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+
+	state.fails++
+	if state.fails < maxFails {
+		return
+	}
+
+	ejectTime := b.EjectTime
+	if ejectTime <= 0 {
+		ejectTime = defaultEjectTime
+	}
+
+	state.fails = 0
+	state.ejected = now.Add(ejectTime)
+}
@@ -0,0 +1,84 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// countingSender counts the messages it sends, and fails with err.
+type countingSender struct {
+	sent int
+	err  error
+}
+
+func (s *countingSender) SendContext(ctx context.Context, m *Message) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.sent++
+	return nil
+}
+
+func TestBalancedSender(t *testing.T) {
+	a, b, c := &countingSender{}, &countingSender{}, &countingSender{}
+
+	s := &BalancedSender{Relays: []Relay{{Sender: a, Weight: 3}, {Sender: b}, {Sender: c, Weight: 2}}}
+
+	var order []int
+	for i := 0; i < 12; i++ {
+		index, err := s.SendWithIndex(context.Background(), testMessage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, index)
+	}
+
+	if a.sent != 6 || b.sent != 2 || c.sent != 4 {
+		t.Fatalf("unexpected distribution: %d %d %d", a.sent, b.sent, c.sent)
+	}
+
+	// The smooth round-robin interleaves the relays.
+	if !reflect.DeepEqual(order[:6], []int{0, 2, 0, 1, 2, 0}) {
+		t.Fatalf("unexpected order: %v", order)
+	}
+}
+
+func TestBalancedEjection(t *testing.T) {
+	failing, healthy := &countingSender{err: io.EOF}, &countingSender{}
+
+	s := &BalancedSender{Relays: []Relay{{Sender: failing}, {Sender: healthy}}, MaxFails: 2, EjectTime: 50 * time.Millisecond}
+
+	// The failing relay is tried twice, then ejected.
+	for i := 0; i < 6; i++ {
+		if err := s.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if healthy.sent != 6 || s.relays[0].fails != 0 || s.relays[0].ejected.IsZero() {
+		t.Fatalf("expected the failing relay to be ejected: %+v", s.relays)
+	}
+
+	// Once every relay is ejected, they are still tried.
+	healthy.err = io.EOF
+	for i := 0; i < 2; i++ {
+		s.Send(testMessage())
+	}
+
+	var failoverErr *FailoverError
+	if err := s.Send(testMessage()); !errors.As(err, &failoverErr) || len(failoverErr.Errs) != 2 {
+		t.Fatalf("expected both relays to fail, got %v", err)
+	}
+
+	// A permanent error isn't tried with another relay.
+	time.Sleep(50 * time.Millisecond)
+	failing.err, healthy.err = errors.New("invalid message"), nil
+	if err := s.Send(testMessage()); !errors.As(err, &failoverErr) || len(failoverErr.Errs) != 1 {
+		t.Fatalf("expected a single failure, got %v", err)
+	}
+}
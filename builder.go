@@ -0,0 +1,169 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"io"
+	"strings"
+)
+
+// Builder composes a message with chained calls, collecting the errors of
+// each step so that they are handled once, when the message is built:
+//
+//	m, err := email.New().
+//		From("Alice <alice@example.com>").
+//		To("bob@example.com").
+//		Subject("Report").
+//		HTML("<p>Here is the report.</p>").
+//		Attach("report.pdf").
+//		Build()
+type Builder struct {
+	m    *Message
+	text string
+	html string
+	errs BuildError
+}
+
+// BuildError is returned by Build with every error found while composing
+// the message.
+type BuildError []error
+
+func (e BuildError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As.
+func (e BuildError) Unwrap() []error {
+	return e
+}
+
+// New returns a Builder for a new message.
+func New() *Builder {
+	return &Builder{m: &Message{}}
+}
+
+func (b *Builder) check(err error) *Builder {
+	if err != nil {
+		b.errs = append(b.errs, err)
+	}
+
+	return b
+}
+
+// From sets the From address, like "Alice <alice@example.com>".
+func (b *Builder) From(address string) *Builder {
+	return b.check(b.m.SetFrom(address))
+}
+
+// Sender sets the Sender address.
+func (b *Builder) Sender(address string) *Builder {
+	return b.check(b.m.SetSender(address))
+}
+
+// To adds To addresses.
+func (b *Builder) To(addresses ...string) *Builder {
+	return b.check(b.m.AddTo(addresses...))
+}
+
+// Cc adds Cc addresses.
+func (b *Builder) Cc(addresses ...string) *Builder {
+	return b.check(b.m.AddCc(addresses...))
+}
+
+// Bcc adds Bcc addresses.
+func (b *Builder) Bcc(addresses ...string) *Builder {
+	return b.check(b.m.AddBcc(addresses...))
+}
+
+// ReplyTo adds Reply-To addresses.
+func (b *Builder) ReplyTo(addresses ...string) *Builder {
+	return b.check(b.m.AddReplyTo(addresses...))
+}
+
+// Subject sets the subject.
+func (b *Builder) Subject(subject string) *Builder {
+	b.m.Subject = subject
+	return b
+}
+
+// Text sets the plain text body, which is an alternative to the HTML one
+// if there is one.
+func (b *Builder) Text(body string) *Builder {
+	b.text = body
+	return b
+}
+
+// HTML sets the HTML body.
+func (b *Builder) HTML(body string) *Builder {
+	b.html = body
+	return b
+}
+
+// Header adds a header field.
+func (b *Builder) Header(key, value string) *Builder {
+	b.m.Headers.Add(key, value)
+	return b
+}
+
+// Attach attaches a file. See Message.Attach.
+func (b *Builder) Attach(file string, options ...AttachOption) *Builder {
+	return b.check(b.m.Attach(file, options...))
+}
+
+// AttachReader attaches the content read from r. See Message.AttachReader.
+func (b *Builder) AttachReader(filename string, r io.Reader, options ...AttachOption) *Builder {
+	return b.check(b.m.AttachReader(filename, r, options...))
+}
+
+// AttachBytes attaches data. See Message.AttachBytes.
+func (b *Builder) AttachBytes(filename string, data []byte, options ...AttachOption) *Builder {
+	b.m.AttachBytes(filename, data, options...)
+	return b
+}
+
+// Inline attaches a file to be shown inline. See Message.Inline.
+func (b *Builder) Inline(file string, options ...AttachOption) *Builder {
+	return b.check(b.m.Inline(file, options...))
+}
+
+// Build returns the message, or a BuildError with the errors of the
+// previous calls and those of validating it: it must have a From address,
+// a recipient and valid headers. The message is a copy, which later calls
+// on the builder don't change.
+func (b *Builder) Build() (*Message, error) {
+	m := b.m.Clone()
+	m.BodyContentType = "text/plain"
+	m.Body = b.text
+	if b.html != "" {
+		m.BodyContentType = "text/html"
+		m.Body = b.html
+		if b.text != "" {
+			m.AddAlternative("text/plain", b.text)
+		}
+	}
+
+	errs := append(BuildError(nil), b.errs...)
+
+	if m.From.Email == "" {
+		errs = append(errs, errNoFrom)
+	}
+
+	if len(m.Tolist()) == 0 {
+		errs = append(errs, ErrNoRecipients)
+	}
+
+	if err := m.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return m, nil
+}
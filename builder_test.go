@@ -0,0 +1,96 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	m, err := New().
+		From("Alice <alice@example.com>").
+		To("bob@example.com").
+		Cc("carol@example.com").
+		Subject("Report").
+		Text("Here is the report.").
+		HTML("<p>Here is the report.</p>").
+		AttachBytes("report.txt", []byte("numbers")).
+		Header("X-Campaign", "q3").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.From != (Address{Name: "Alice", Email: "alice@example.com"}) || len(m.To) != 1 || len(m.Cc) != 1 {
+		t.Fatalf("unexpected addresses: %+v", m)
+	}
+
+	data := string(m.Bytes())
+	for _, expected := range []string{
+		"Subject: Report\r\n",
+		"X-Campaign: q3\r\n",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+		`filename="report.txt"`,
+	} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %q:\n%s", expected, data)
+		}
+	}
+}
+
+func TestBuilderCopies(t *testing.T) {
+	b := New().
+		From("alice@example.com").
+		To("bob@example.com").
+		Text("Here is the report.").
+		Header("X-Campaign", "q3").
+		AttachBytes("report.txt", []byte("numbers"))
+
+	first, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := b.To("carol@example.com").Header("X-Campaign", "q4").AttachBytes("notes.txt", []byte("notes")).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(second.To) != 2 || len(second.Headers) != 2 || len(second.Attachments) != 2 {
+		t.Fatalf("unexpected second message: %+v", second)
+	}
+
+	second.To[0].Email = "dave@example.com"
+	second.Headers[0].Value = "q5"
+	second.Attachments[0].Filename = "other.txt"
+
+	if len(first.To) != 1 || first.To[0].Email != "bob@example.com" || len(first.Headers) != 1 ||
+		first.Headers[0].Value != "q3" || len(first.Attachments) != 1 || first.Attachments[0].Filename != "report.txt" {
+		t.Fatalf("expected the first message to be unchanged: %+v", first)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	_, err := New().
+		To("not an address").
+		Header("X-Bad", "a\r\nb").
+		Attach("missing-file.pdf").
+		Build()
+
+	var errs BuildError
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected a BuildError, got %v", err)
+	}
+
+	// The invalid address, the missing file, the missing From and
+	// recipients, and the header.
+	if len(errs) != 5 {
+		t.Fatalf("expected 5 errors, got %d: %v", len(errs), err)
+	}
+
+	var injection *HeaderInjectionError
+	if !errors.As(err, &injection) {
+		t.Fatalf("expected a HeaderInjectionError in %v", err)
+	}
+}
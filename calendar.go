@@ -0,0 +1,157 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a meeting invitation sent as an iCalendar (RFC 5545) part so
+// that clients show it with accept and decline buttons.
+type Event struct {
+	// UID identifies the event across updates. If empty, AddCalendar
+	// generates one and stores it here.
+	UID string
+
+	// Method is the iTIP method, REQUEST by default. Use CANCEL with the
+	// same UID and a higher Sequence to cancel the event.
+	Method   string
+	Sequence int
+
+	Summary     string
+	Description string
+	Location    string
+
+	Organizer string
+	Attendees []string
+
+	Start time.Time
+	End   time.Time
+}
+
+const icsTimeFormat = "20060102T150405Z"
+
+func (e *Event) method() string {
+	if e.Method == "" {
+		return "REQUEST"
+	}
+
+	return strings.ToUpper(e.Method)
+}
+
+// ICS returns the event as an iCalendar object. stamp is written as the
+// DTSTAMP of the event.
+func (e *Event) ICS(stamp time.Time) ([]byte, error) {
+	if e.Start.IsZero() || e.End.IsZero() {
+		return nil, errors.New("the event needs a start and an end")
+	}
+
+	organizer, err := mail.ParseAddress(e.Organizer)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	writeContentLine(buf, "BEGIN:VCALENDAR")
+	writeContentLine(buf, "PRODID:-//scorredoira//email//EN")
+	writeContentLine(buf, "VERSION:2.0")
+	writeContentLine(buf, "CALSCALE:GREGORIAN")
+	writeContentLine(buf, "METHOD:"+e.method())
+	writeContentLine(buf, "BEGIN:VEVENT")
+	writeContentLine(buf, "UID:"+escapeText(e.UID))
+	writeContentLine(buf, "SEQUENCE:"+strconv.Itoa(e.Sequence))
+	writeContentLine(buf, "DTSTAMP:"+stamp.UTC().Format(icsTimeFormat))
+	writeContentLine(buf, "DTSTART:"+e.Start.UTC().Format(icsTimeFormat))
+	writeContentLine(buf, "DTEND:"+e.End.UTC().Format(icsTimeFormat))
+	writeContentLine(buf, "SUMMARY:"+escapeText(e.Summary))
+
+	if e.Description != "" {
+		writeContentLine(buf, "DESCRIPTION:"+escapeText(e.Description))
+	}
+
+	if e.Location != "" {
+		writeContentLine(buf, "LOCATION:"+escapeText(e.Location))
+	}
+
+	writeContentLine(buf, "ORGANIZER"+icsCommonName(organizer)+":mailto:"+organizer.Address)
+
+	for _, attendee := range e.Attendees {
+		a, err := mail.ParseAddress(attendee)
+		if err != nil {
+			return nil, err
+		}
+
+		writeContentLine(buf, "ATTENDEE"+icsCommonName(a)+";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:"+a.Address)
+	}
+
+	if e.method() == "CANCEL" {
+		writeContentLine(buf, "STATUS:CANCELLED")
+	} else {
+		writeContentLine(buf, "STATUS:CONFIRMED")
+	}
+
+	writeContentLine(buf, "END:VEVENT")
+	writeContentLine(buf, "END:VCALENDAR")
+
+	return buf.Bytes(), nil
+}
+
+// AddCalendar adds the event as a text/calendar alternative of the body,
+// which Gmail and Outlook render as an invitation, and as an invite.ics
+// attachment for clients that don't.
+func (m *Message) AddCalendar(e *Event) error {
+	if e.UID == "" {
+		token, err := randomToken(16)
+		if err != nil {
+			return err
+		}
+
+		e.UID = token + "@" + m.domain()
+	}
+
+	ics, err := e.ICS(m.date())
+	if err != nil {
+		return err
+	}
+
+	m.AddAlternative("text/calendar; method="+e.method(), string(ics))
+	m.AttachBytes("invite.ics", ics, WithContentType("application/ics"))
+
+	return nil
+}
+
+func icsCommonName(a *mail.Address) string {
+	if a.Name == "" {
+		return ""
+	}
+
+	return `;CN="` + strings.Replace(a.Name, `"`, "'", -1) + `"`
+}
+
+// escapeText escapes a TEXT value of an iCalendar or vCard property.
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeContentLine writes an iCalendar or vCard content line folded at 75
+// octets, without splitting UTF-8 sequences.
+func writeContentLine(buf *bytes.Buffer, line string) {
+	// Continuation lines start with a space that counts towards the limit.
+	for max := 75; len(line) > max; max = 74 {
+		i := max
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+
+		buf.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+	}
+
+	buf.WriteString(line + "\r\n")
+}
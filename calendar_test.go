@@ -0,0 +1,64 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddCalendar(t *testing.T) {
+	m := NewHTMLMessage("Planning", "<p>See you there</p>")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}}
+	m.AddAlternative("text/plain", "See you there")
+	m.Date = time.Date(2012, 4, 5, 10, 0, 0, 0, time.UTC)
+
+	event := &Event{
+		Summary:   "Planning, Q3",
+		Location:  "Room 1",
+		Organizer: m.From.String(),
+		Attendees: []string{"Bob <bob@example.com>"},
+		Start:     time.Date(2012, 4, 6, 9, 0, 0, 0, time.UTC),
+		End:       time.Date(2012, 4, 6, 10, 0, 0, 0, time.UTC),
+	}
+
+	if err := m.AddCalendar(event); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(event.UID, "@example.com") {
+		t.Fatalf("unexpected UID %q", event.UID)
+	}
+
+	ics, err := event.ICS(m.Date)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"METHOD:REQUEST\r\n",
+		"DTSTAMP:20120405T100000Z\r\n",
+		"DTSTART:20120406T090000Z\r\n",
+		"DTEND:20120406T100000Z\r\n",
+		"SUMMARY:Planning\\, Q3\r\n",
+		"ORGANIZER;CN=\"Alice\":mailto:alice@example.com\r\n",
+		"ATTENDEE;CN=\"Bob\";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mail\r\n to:bob@example.com\r\n",
+	} {
+		if !strings.Contains(string(ics), line) {
+			t.Fatalf("expected %q in:\n%s", line, ics)
+		}
+	}
+
+	data := string(m.Bytes())
+
+	text := strings.Index(data, "Content-Type: text/plain")
+	html := strings.Index(data, "Content-Type: text/html")
+	calendar := strings.Index(data, "Content-Type: text/calendar; method=REQUEST")
+	if text == -1 || html == -1 || calendar == -1 || text > html || html > calendar {
+		t.Fatalf("expected text, HTML and calendar alternatives in order:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Content-Type: application/ics\r\n") || !strings.Contains(data, `filename="invite.ics"`) {
+		t.Fatalf("expected an invite.ics attachment:\n%s", data)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CharsetEncoder encodes UTF-8 text in a charset, failing if it has
+// characters the charset can't represent.
+type CharsetEncoder func(text string) ([]byte, error)
+
+// charsets holds the encoders by lowercase charset name. UTF-8 needs none.
+var charsets = map[string]CharsetEncoder{
+	"us-ascii":   rangeEncoder("us-ascii", 0x7f),
+	"iso-8859-1": rangeEncoder("iso-8859-1", 0xff),
+}
+
+// RegisterCharset adds an encoder for a charset that bodies can be sent in,
+// in addition to the built-in UTF-8, US-ASCII and ISO-8859-1. Encoders for
+// others, like GB2312 or Shift_JIS, are in golang.org/x/text/encoding:
+//
+//	email.RegisterCharset("gb2312", func(text string) ([]byte, error) {
+//		return simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+//	})
+//
+// It must be called before sending, usually from an init function.
+func RegisterCharset(name string, encoder CharsetEncoder) {
+	charsets[strings.ToLower(name)] = encoder
+}
+
+// rangeEncoder returns an encoder for the charsets whose code points are
+// the same as Unicode up to max, like ISO-8859-1.
+func rangeEncoder(name string, max rune) CharsetEncoder {
+	return func(text string) ([]byte, error) {
+		encoded := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > max {
+				return nil, fmt.Errorf("character %q can't be encoded in %s", r, name)
+			}
+			encoded = append(encoded, byte(r))
+		}
+
+		return encoded, nil
+	}
+}
+
+// encodeCharset encodes text in charset.
+func encodeCharset(charset, text string) ([]byte, error) {
+	if isUTF8(charset) {
+		return []byte(text), nil
+	}
+
+	encoder, ok := charsets[strings.ToLower(charset)]
+	if !ok {
+		return nil, fmt.Errorf("unknown charset %q", charset)
+	}
+
+	return encoder(text)
+}
+
+func isUTF8(charset string) bool {
+	return charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8")
+}
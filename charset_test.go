@@ -0,0 +1,61 @@
+package email
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCharset(t *testing.T) {
+	m := NewMessage("Hi", "Hola José")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Charset = "ISO-8859-1"
+	m.Alternatives = append(m.Alternatives, &Alternative{ContentType: "text/html", Body: "<p>Hola José</p>", Charset: "utf-8"})
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHola Jos=E9") {
+		t.Fatalf("expected the body in ISO-8859-1:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>Hola Jos=C3=A9</p>") {
+		t.Fatalf("expected the alternative in UTF-8:\n%s", data)
+	}
+
+	m.BodyTransferEncoding = EightBit
+	data = string(m.Bytes())
+	if !strings.Contains(data, "Content-Type: text/plain; charset=iso-8859-1\r\nContent-Transfer-Encoding: 8bit\r\n\r\nHola Jos\xe9") {
+		t.Fatalf("expected the body in ISO-8859-1 as 8bit:\n%s", data)
+	}
+}
+
+func TestCharsetErrors(t *testing.T) {
+	for _, charset := range []string{"us-ascii", "koi8-r"} {
+		m := NewMessage("Hi", "Hola José")
+		m.From = Address{Email: "from@example.com"}
+		m.To = []Address{{Email: "to@example.com"}}
+		m.Charset = charset
+
+		if _, err := m.WriteTo(ioutil.Discard); err == nil {
+			t.Errorf("%s: expected an error", charset)
+		}
+	}
+}
+
+func TestRegisterCharset(t *testing.T) {
+	RegisterCharset("X-Upper", func(text string) ([]byte, error) {
+		return bytes.ToUpper([]byte(text)), nil
+	})
+	defer delete(charsets, "x-upper")
+
+	m := NewMessage("Hi", "hello")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Charset = "X-Upper"
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "charset=x-upper\r\nContent-Transfer-Encoding: 7bit\r\n\r\nHELLO") {
+		t.Fatalf("expected the registered encoder to be used:\n%s", data)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// Client sends messages over a session that is kept open between them,
+// instead of connecting, saying hello and authenticating for each one as
+// SMTPSender does. The session is reset with RSET before each message,
+// and opened again if the server dropped it while it was idle.
+//
+// A Client is safe for concurrent use, but sends one message at a time.
+// It must be closed when it is no longer needed.
+type Client struct {
+	sender *SMTPSender
+
+	mu     sync.Mutex
+	conn   *timeoutConn
+	client *smtp.Client
+	used   bool
+	opened time.Time
+}
+
+// NewClient returns a Client that connects with the options of s. It
+// connects on the first send, and s must not be modified after that. The
+// Timeout of s limits each send, not the whole session.
+func NewClient(s *SMTPSender) *Client {
+	return &Client{sender: s}
+}
+
+// Send sends the message. See SendContext.
+func (c *Client) Send(m *Message) error {
+	return c.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message over the open session, or a new one if
+// there is none. If ctx is done before the message is sent, the session is
+// aborted and the next send opens a new one.
+func (c *Client) SendContext(ctx context.Context, m *Message) error {
+	s := c.sender
+
+	from, err := s.prepare(m)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := s.deadline(ctx)
+
+	stop := func() {}
+	if c.client != nil {
+		c.conn.setDeadline(deadline)
+		stop = c.conn.watch(ctx)
+
+		if c.used {
+			if err := c.client.Reset(); err != nil {
+				stop()
+				c.drop()
+
+				// The server probably closed the idle session, so a new
+				// one is opened unless the send was aborted.
+				if ctx.Err() != nil {
+					return s.sessionError(ctx, err, deadline)
+				}
+			}
+		}
+	}
+
+	if c.client == nil {
+		if stop, err = c.openSession(ctx, deadline); err != nil {
+			return err
+		}
+	}
+
+	c.used = true
+	err = s.send(c.client, from, m.Tolist(), m)
+	stop()
+
+	if c.conn.isAborted() || (err != nil && !sessionUsable(err)) {
+		c.drop()
+	}
+
+	return s.sessionError(ctx, err, deadline)
+}
+
+// openSession opens a session, and returns the function that stops
+// watching ctx.
+func (c *Client) openSession(ctx context.Context, deadline time.Time) (stop func(), err error) {
+	s := c.sender
+
+	conn, err := s.connect(ctx, deadline)
+	if err != nil {
+		return nil, s.sessionError(ctx, err, deadline)
+	}
+	stop = conn.watch(ctx)
+
+	client, err := s.handshake(ctx, conn)
+	if err != nil {
+		stop()
+		return nil, s.sessionError(ctx, err, deadline)
+	}
+
+	c.conn, c.client, c.used, c.opened = conn, client, false, time.Now()
+
+	return stop, nil
+}
+
+// Ping checks that the server answers NOOP over the session, opening one
+// if there is none, to tell whether it can be reached before sending. If
+// the session doesn't answer, it is closed.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		stop, err := c.openSession(ctx, c.sender.deadline(ctx))
+		if err != nil {
+			return err
+		}
+		stop()
+	}
+
+	return c.noop(ctx)
+}
+
+// Healthy reports whether the session is open and answers NOOP, without
+// opening one. If it doesn't answer, it is closed.
+func (c *Client) Healthy() bool {
+	return c.healthy(context.Background())
+}
+
+// healthy is like Healthy, but gives up if ctx is done first.
+func (c *Client) healthy(ctx context.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client != nil && c.noop(ctx) == nil
+}
+
+// noop sends NOOP over the open session, and drops it if it fails.
+func (c *Client) noop(ctx context.Context) error {
+	deadline := c.sender.deadline(ctx)
+	c.conn.setDeadline(deadline)
+	stop := c.conn.watch(ctx)
+
+	err := c.client.Noop()
+	stop()
+
+	if err != nil {
+		c.drop()
+	}
+
+	return c.sender.sessionError(ctx, smtpError("NOOP", err), deadline)
+}
+
+// SendAll sends the messages. See SendAllContext.
+func (c *Client) SendAll(messages []*Message) []error {
+	return c.SendAllContext(context.Background(), messages)
+}
+
+// SendAllContext sends the messages one after the other over the session,
+// going on when one fails. It returns the error of each message, nil for
+// those that were sent, in the same order.
+func (c *Client) SendAllContext(ctx context.Context, messages []*Message) []error {
+	errs := make([]error, len(messages))
+	for i, m := range messages {
+		errs[i] = c.SendContext(ctx, m)
+	}
+
+	return errs
+}
+
+// sessionUsable reports whether the session can still be used after the
+// error of a send: the server rejected the message or some recipients but
+// the connection is fine.
+func sessionUsable(err error) bool {
+	var protocolErr *textproto.Error
+	var recipientsErr *RecipientsError
+	return errors.As(err, &protocolErr) || errors.As(err, &recipientsErr) || errors.Is(err, ErrSMTPUTF8Unsupported)
+}
+
+// drop closes the connection without ending the session.
+func (c *Client) drop() {
+	if c.client != nil {
+		c.client.Close()
+	}
+
+	c.conn, c.client = nil, nil
+}
+
+// Close ends the session, if it is open. A later send opens a new one.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+
+	c.conn.setDeadline(c.sender.deadline(context.Background()))
+	err := c.client.Quit()
+	c.drop()
+
+	return err
+}
+
+// openedAt returns when the session was opened.
+func (c *Client) openedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.opened
+}
+
+// open reports whether the session is open.
+func (c *Client) open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.client != nil
+}
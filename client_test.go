@@ -0,0 +1,133 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// count returns the number of commands that begin with prefix.
+func count(commands []string, prefix string) int {
+	n := 0
+	for _, command := range commands {
+		if strings.HasPrefix(command, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t)
+	server.replies["RCPT TO:<bad@"] = "550 no such user"
+
+	c := NewClient(&SMTPSender{Addr: server.Addr()})
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := c.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bad := testMessage()
+	bad.To = []Address{{Email: "bad@example.com"}}
+	if err := c.Send(bad); err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Fatalf("expected the recipient to be rejected, got %v", err)
+	}
+
+	if err := c.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := server.Commands()
+	if n := count(commands, "EHLO"); n != 1 {
+		t.Fatalf("expected one session, got %d: %q", n, commands)
+	}
+
+	if n := count(commands, "RSET"); n != 4 {
+		t.Fatalf("expected a reset between messages, got %d: %q", n, commands)
+	}
+
+	if n := len(server.Messages()); n != 4 {
+		t.Fatalf("expected 4 messages, got %d", n)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if commands := server.Commands(); commands[len(commands)-1] != "QUIT" {
+		t.Fatalf("expected the session to end, got %q", commands)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	server := newTestServer(t)
+
+	c := NewClient(&SMTPSender{Addr: server.Addr()})
+	defer c.Close()
+
+	if err := c.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Drop()
+
+	if err := c.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(server.Commands(), "EHLO"); n != 2 {
+		t.Fatalf("expected a new session, got %d", n)
+	}
+
+	if n := len(server.Messages()); n != 2 {
+		t.Fatalf("expected 2 messages, got %d", n)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	c := NewClient(&SMTPSender{Addr: newSilentServer(t)})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.SendContext(ctx, testMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	server := newTestServer(t)
+
+	c := NewClient(&SMTPSender{Addr: server.Addr()})
+	defer c.Close()
+
+	if c.Healthy() {
+		t.Fatal("expected no session")
+	}
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Healthy() || count(server.Commands(), "NOOP") != 2 {
+		t.Fatalf("expected the session to answer NOOP: %q", server.Commands())
+	}
+
+	server.Drop()
+
+	if c.Healthy() || c.open() {
+		t.Fatal("expected the dropped session to be closed")
+	}
+
+	server.listener.Close()
+	if err := c.Ping(context.Background()); err == nil {
+		t.Fatal("expected the ping to fail")
+	}
+}
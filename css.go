@@ -0,0 +1,384 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	styleElement  = regexp.MustCompile(`(?is)<style[^>]*>(.*?)</style\s*>`)
+	cssComment    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	htmlComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	rawElement    = regexp.MustCompile(`(?is)<(script|style)[\s>].*?</(script|style)\s*>`)
+	startTag      = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[^\s=/>]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*(/?)>`)
+	tagAttribute  = regexp.MustCompile(`([^\s=/>]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+	simpleSel     = regexp.MustCompile(`^(\*|[a-zA-Z][a-zA-Z0-9-]*)?((?:[#.][a-zA-Z0-9_-]+)*)$`)
+	selectorPart  = regexp.MustCompile(`[#.][a-zA-Z0-9_-]+`)
+	importantFlag = regexp.MustCompile(`(?i)\s*!\s*important$`)
+)
+
+// cssRule is a style rule with a simple selector, which InlineCSS can match
+// against a single element.
+type cssRule struct {
+	tag     string
+	id      string
+	classes []string
+
+	specificity  [3]int
+	order        int
+	declarations []cssDeclaration
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// InlineCSS moves the rules of the <style> elements of an HTML document into
+// the style attributes of the elements they apply to, since many clients,
+// Gmail among them, ignore style sheets in some contexts.
+//
+// Only rules with simple selectors are inlined: a tag, an ID and classes,
+// like p, #header or td.price.total. Other rules, like those with
+// combinators, pseudo-classes or in @media, are left in the <style>
+// element for the clients that do support them.
+func InlineCSS(html string) string {
+	var rules []*cssRule
+	var order int
+
+	html = styleElement.ReplaceAllStringFunc(html, func(element string) string {
+		css := styleElement.FindStringSubmatch(element)[1]
+
+		inlined, kept := parseCSS(css, &order)
+		rules = append(rules, inlined...)
+
+		if strings.TrimSpace(kept) == "" {
+			return ""
+		}
+
+		return strings.Replace(element, css, kept, 1)
+	})
+
+	if len(rules) == 0 {
+		return html
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		for k := range a.specificity {
+			if a.specificity[k] != b.specificity[k] {
+				return a.specificity[k] < b.specificity[k]
+			}
+		}
+
+		return a.order < b.order
+	})
+
+	// Tags in comments, scripts and the kept style sheets aren't elements.
+	var out strings.Builder
+	last := 0
+	for _, skip := range skippedRanges(html) {
+		out.WriteString(inlineTags(html[last:skip[0]], rules))
+		out.WriteString(html[skip[0]:skip[1]])
+		last = skip[1]
+	}
+	out.WriteString(inlineTags(html[last:], rules))
+
+	return out.String()
+}
+
+// skippedRanges returns the sorted ranges of html that have no elements.
+func skippedRanges(html string) [][]int {
+	ranges := append(htmlComment.FindAllStringIndex(html, -1), rawElement.FindAllStringIndex(html, -1)...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	var merged [][]int
+	for _, r := range ranges {
+		if len(merged) > 0 && r[0] < merged[len(merged)-1][1] {
+			if r[1] > merged[len(merged)-1][1] {
+				merged[len(merged)-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// inlineTags adds the declarations of the matching rules to the style
+// attribute of every start tag in html.
+func inlineTags(html string, rules []*cssRule) string {
+	return startTag.ReplaceAllStringFunc(html, func(tag string) string {
+		match := startTag.FindStringSubmatch(tag)
+		name := strings.ToLower(match[1])
+
+		var id, style string
+		var classes []string
+		hasStyle := false
+
+		for _, attr := range tagAttribute.FindAllStringSubmatch(match[2], -1) {
+			value := strings.Trim(attr[2], `"'`)
+			switch strings.ToLower(attr[1]) {
+			case "id":
+				id = value
+			case "class":
+				classes = strings.Fields(value)
+			case "style":
+				style = value
+				hasStyle = true
+			}
+		}
+
+		var normal, important []cssDeclaration
+		for _, rule := range rules {
+			if !rule.matches(name, id, classes) {
+				continue
+			}
+
+			for _, d := range rule.declarations {
+				if d.important {
+					important = append(important, d)
+				} else {
+					normal = append(normal, d)
+				}
+			}
+		}
+
+		if len(normal) == 0 && len(important) == 0 {
+			return tag
+		}
+
+		// Declarations already in the style attribute override those of
+		// the style sheet, unless only the latter are !important.
+		declarations := normal
+		var inlineImportant []cssDeclaration
+		for _, d := range parseDeclarations(style) {
+			if d.important {
+				inlineImportant = append(inlineImportant, d)
+			} else {
+				declarations = append(declarations, d)
+			}
+		}
+		declarations = append(declarations, important...)
+		declarations = append(declarations, inlineImportant...)
+		style = strings.Replace(formatDeclarations(declarations), `"`, "&quot;", -1)
+
+		attrs := match[2]
+		if hasStyle {
+			attrs = tagAttribute.ReplaceAllStringFunc(attrs, func(attr string) string {
+				if strings.ToLower(tagAttribute.FindStringSubmatch(attr)[1]) == "style" {
+					return `style="` + style + `"`
+				}
+				return attr
+			})
+		} else {
+			attrs += ` style="` + style + `"`
+		}
+
+		closing := ">"
+		if match[3] != "" {
+			closing = " />"
+		}
+
+		return "<" + match[1] + attrs + closing
+	})
+}
+
+func (r *cssRule) matches(tag, id string, classes []string) bool {
+	if r.tag != "" && r.tag != tag {
+		return false
+	}
+
+	if r.id != "" && r.id != id {
+		return false
+	}
+
+	for _, class := range r.classes {
+		if !containsString(classes, class) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCSS splits a style sheet into the rules that can be inlined and the
+// CSS that has to be kept, numbering the rules from order.
+func parseCSS(css string, order *int) ([]*cssRule, string) {
+	css = cssComment.ReplaceAllString(css, "")
+
+	var rules []*cssRule
+	var kept strings.Builder
+
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+
+		open := strings.Index(css, "{")
+		if semicolon := strings.Index(css, ";"); strings.HasPrefix(css, "@") && semicolon != -1 && (open == -1 || semicolon < open) {
+			// A statement at-rule, like @import or @charset.
+			kept.WriteString(css[:semicolon+1] + "\n")
+			css = css[semicolon+1:]
+			continue
+		}
+
+		if open == -1 {
+			break
+		}
+
+		selectors := strings.TrimSpace(css[:open])
+
+		var body string
+		if end := matchingBrace(css, open); end != -1 {
+			body, css = css[open+1:end], css[end+1:]
+		} else {
+			body, css = css[open+1:], ""
+		}
+
+		if strings.HasPrefix(selectors, "@") {
+			kept.WriteString(selectors + " {" + body + "}\n")
+			continue
+		}
+
+		declarations := parseDeclarations(body)
+
+		var complex []string
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+
+			rule := parseSelector(selector)
+			if rule == nil {
+				complex = append(complex, selector)
+				continue
+			}
+
+			rule.order = *order
+			rule.declarations = declarations
+			rules = append(rules, rule)
+			*order++
+		}
+
+		if len(complex) > 0 {
+			kept.WriteString(strings.Join(complex, ", ") + " {" + body + "}\n")
+		}
+	}
+
+	return rules, kept.String()
+}
+
+// matchingBrace returns the index of the brace that closes the one at open,
+// or -1 if it isn't closed.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// parseSelector parses a simple selector, returning nil for the ones that
+// can't be inlined.
+func parseSelector(selector string) *cssRule {
+	match := simpleSel.FindStringSubmatch(selector)
+	if match == nil || selector == "" {
+		return nil
+	}
+
+	rule := &cssRule{}
+	if match[1] != "*" {
+		rule.tag = strings.ToLower(match[1])
+	}
+
+	if rule.tag != "" {
+		rule.specificity[2]++
+	}
+
+	for _, part := range selectorPart.FindAllString(match[2], -1) {
+		if part[0] == '#' {
+			rule.id = part[1:]
+			rule.specificity[0]++
+		} else {
+			rule.classes = append(rule.classes, part[1:])
+			rule.specificity[1]++
+		}
+	}
+
+	return rule
+}
+
+func parseDeclarations(style string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range strings.Split(style, ";") {
+		i := strings.Index(declaration, ":")
+		if i == -1 {
+			continue
+		}
+
+		d := cssDeclaration{
+			property: strings.ToLower(strings.TrimSpace(declaration[:i])),
+			value:    strings.TrimSpace(declaration[i+1:]),
+		}
+
+		if importantFlag.MatchString(d.value) {
+			d.value = importantFlag.ReplaceAllString(d.value, "")
+			d.important = true
+		}
+
+		if d.property != "" && d.value != "" {
+			declarations = append(declarations, d)
+		}
+	}
+
+	return declarations
+}
+
+// formatDeclarations writes the declarations as a style attribute, where
+// later declarations of a property replace the earlier ones.
+func formatDeclarations(declarations []cssDeclaration) string {
+	values := make(map[string]string)
+	var properties []string
+
+	for _, d := range declarations {
+		if _, ok := values[d.property]; !ok {
+			properties = append(properties, d.property)
+		}
+
+		values[d.property] = d.value
+		if d.important {
+			values[d.property] += " !important"
+		}
+	}
+
+	formatted := make([]string, len(properties))
+	for i, property := range properties {
+		formatted[i] = property + ": " + values[property]
+	}
+
+	return strings.Join(formatted, "; ")
+}
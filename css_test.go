@@ -0,0 +1,76 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	html := `<html><head><style>
+/* base */
+@import url("fonts.css");
+p { color: black; margin: 0 }
+.note, #title { color: gray }
+p.note { font-weight: bold !important }
+a:hover { color: red }
+@media (max-width: 600px) { p { margin: 4px } }
+</style></head><body>
+<h1 id="title">Title</h1>
+<p class="note" style="color: blue; font-weight: normal">Note</p>
+<!-- <p>comment</p> -->
+<img class="note" src="logo.png"/>
+<p>Text</p>
+</body></html>`
+
+	inlined := InlineCSS(html)
+
+	for _, expected := range []string{
+		`<h1 id="title" style="color: gray">`,
+		`<p class="note" style="color: blue; margin: 0; font-weight: bold !important">`,
+		`<!-- <p>comment</p> -->`,
+		`<img class="note" src="logo.png" style="color: gray" />`,
+		`<p style="color: black; margin: 0">Text</p>`,
+		`@import url("fonts.css");`,
+		`a:hover { color: red }`,
+		`@media (max-width: 600px) { p { margin: 4px } }`,
+	} {
+		if !strings.Contains(inlined, expected) {
+			t.Fatalf("expected %q:\n%s", expected, inlined)
+		}
+	}
+
+	if strings.Contains(inlined, "p { color: black") {
+		t.Fatalf("expected inlined rules to be removed from the style sheet:\n%s", inlined)
+	}
+
+	if html := "<p>no styles</p>"; InlineCSS(html) != html {
+		t.Fatal("expected HTML without style sheets to be unchanged")
+	}
+
+	if inlined := InlineCSS("<style>p { color: red }</style><p>x</p>"); inlined != `<p style="color: red">x</p>` {
+		t.Fatalf("expected the empty style element to be removed, got %q", inlined)
+	}
+
+	InlineCSS("<style>p { color: red</style><p>x</p>")
+}
+
+func TestMessageInlineCSS(t *testing.T) {
+	m := NewHTMLMessage("Hi", "<style>p { color: red }</style><p>this is the body</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AddAlternative("text/plain", "<style>p { color: red }</style>")
+
+	if data := string(m.Bytes()); !strings.Contains(data, "<style>") {
+		t.Fatalf("expected the style sheet to be kept by default:\n%s", data)
+	}
+
+	m.InlineCSS = true
+	data := string(m.Bytes())
+	if !strings.Contains(data, `<p style="color: red">`) {
+		t.Fatalf("expected the CSS to be inlined:\n%s", data)
+	}
+
+	if strings.Count(data, "<style>") != 1 {
+		t.Fatalf("expected only the text alternative to be unchanged:\n%s", data)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// TLSA is a TLSA record of RFC 6698, which tells the certificate that a
+// server presents.
+type TLSA struct {
+	// Usage is 2 (DANE-TA) for a trust anchor that issues the certificate
+	// of the server, or 3 (DANE-EE) for the certificate itself. The PKIX
+	// usages 0 and 1 aren't used with SMTP.
+	Usage uint8
+
+	// Selector is 0 to match the whole certificate, or 1 to match its
+	// public key (SubjectPublicKeyInfo).
+	Selector uint8
+
+	// MatchingType is 0 if Data is what is matched, or 1 or 2 if it is
+	// its SHA-256 or SHA-512 hash.
+	MatchingType uint8
+
+	Data []byte
+}
+
+// TLSAError is returned when the certificate that the server presents
+// doesn't match any of its TLSA records.
+type TLSAError struct {
+	ServerName string
+}
+
+func (e *TLSAError) Error() string {
+	return fmt.Sprintf("smtp: the TLS certificate of %s doesn't match its TLSA records", e.ServerName)
+}
+
+// lookupTLSA returns the TLSA records of the server, or nil if it has none
+// or LookupTLSA isn't set.
+func (s *SMTPSender) lookupTLSA(ctx context.Context) ([]TLSA, error) {
+	if s.LookupTLSA == nil {
+		return nil, nil
+	}
+
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// IP addresses don't have TLSA records.
+	if net.ParseIP(host) != nil {
+		return nil, nil
+	}
+
+	records, err := s.LookupTLSA(ctx, "_"+port+"._tcp."+host)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: TLSA lookup failed: %w", err)
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return records, nil
+}
+
+// daneConfig returns config with the certificate of the server verified
+// against the TLSA records instead of the root CAs. If none of the records
+// is usable, config is returned unchanged: the connection still requires
+// TLS, and the certificate is verified as it would be without the records.
+func daneConfig(config *tls.Config, records []TLSA) *tls.Config {
+	var usable []TLSA
+	for _, r := range records {
+		if (r.Usage == 2 || r.Usage == 3) && r.Selector <= 1 && r.MatchingType <= 2 {
+			usable = append(usable, r)
+		}
+	}
+
+	if len(usable) == 0 {
+		return config
+	}
+
+	config = config.Clone()
+	config.InsecureSkipVerify = true
+
+	verify, serverName := config.VerifyConnection, config.ServerName
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if !verifyDANE(cs.PeerCertificates, usable, serverName) {
+			return &TLSAError{ServerName: serverName}
+		}
+
+		if verify != nil {
+			return verify(cs)
+		}
+
+		return nil
+	}
+
+	return config
+}
+
+// verifyDANE reports whether the certificate chain matches one of the
+// records: its first certificate for DANE-EE, regardless of its names and
+// validity, or any of them for DANE-TA, which must then issue the first
+// one for the server name.
+func verifyDANE(certs []*x509.Certificate, records []TLSA, serverName string) bool {
+	if len(certs) == 0 {
+		return false
+	}
+
+	for _, r := range records {
+		if r.Usage == 3 {
+			if r.matches(certs[0]) {
+				return true
+			}
+			continue
+		}
+
+		for i, cert := range certs {
+			if !r.matches(cert) {
+				continue
+			}
+
+			roots := x509.NewCertPool()
+			roots.AddCert(cert)
+
+			intermediates := x509.NewCertPool()
+			for _, c := range certs[1:i] {
+				intermediates.AddCert(c)
+			}
+
+			opts := x509.VerifyOptions{DNSName: serverName, Roots: roots, Intermediates: intermediates}
+			if _, err := certs[0].Verify(opts); err == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the record matches the certificate.
+func (r TLSA) matches(cert *x509.Certificate) bool {
+	data := cert.Raw
+	if r.Selector == 1 {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+
+	switch r.MatchingType {
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	}
+
+	return bytes.Equal(data, r.Data)
+}
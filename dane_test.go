@@ -0,0 +1,153 @@
+package email
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testChain returns a certificate for mx.example.com issued by a CA, and
+// the CA.
+func testChain(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	newCert := func(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cert, key
+	}
+
+	ca, caKey := newCert(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+
+	leaf, key := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mx.example.com"},
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	return tls.Certificate{Certificate: [][]byte{leaf.Raw, ca.Raw}, PrivateKey: key, Leaf: leaf}, ca
+}
+
+func TestDANE(t *testing.T) {
+	cert, ca := testChain(t)
+	server := newTestServer(t)
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	spki := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+
+	var name string
+	var records []TLSA
+	s := &SMTPSender{
+		Addr: "mx.example.com:25",
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, server.Addr())
+		},
+		LookupTLSA: func(ctx context.Context, n string) ([]TLSA, error) {
+			name = n
+			return records, nil
+		},
+	}
+
+	for _, test := range []struct {
+		name    string
+		records []TLSA
+		ok      bool
+	}{
+		{"DANE-EE", []TLSA{{Usage: 3, Selector: 1, MatchingType: 1, Data: spki[:]}}, true},
+		{"DANE-EE full certificate", []TLSA{{Usage: 3, Selector: 0, MatchingType: 0, Data: cert.Leaf.Raw}}, true},
+		{"DANE-TA", []TLSA{{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte("other")}, {Usage: 2, Selector: 0, MatchingType: 0, Data: ca.Raw}}, true},
+		{"DANE-EE mismatch", []TLSA{{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte("other")}}, false},
+		{"DANE-TA mismatch", []TLSA{{Usage: 2, Selector: 0, MatchingType: 0, Data: ca.Raw[1:]}}, false},
+	} {
+		records = test.records
+		before := len(server.Messages())
+
+		err := s.Send(testMessage())
+
+		var tlsaErr *TLSAError
+		if test.ok && err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		} else if !test.ok && (!errors.As(err, &tlsaErr) || tlsaErr.ServerName != "mx.example.com") {
+			t.Fatalf("%s: expected a TLSAError, got %v", test.name, err)
+		}
+
+		if sent := len(server.Messages()) > before; sent != test.ok || !server.TLS() {
+			t.Fatalf("%s: unexpected result: sent %v, TLS %v", test.name, sent, server.TLS())
+		}
+	}
+
+	if name != "_25._tcp.mx.example.com" {
+		t.Fatalf("unexpected TLSA name: %s", name)
+	}
+
+	// Records that can't be used don't turn off the verification of the
+	// certificate, which isn't trusted here.
+	records = []TLSA{{Usage: 1, Selector: 1, MatchingType: 1, Data: spki[:]}, {Usage: 3, Selector: 2, MatchingType: 1, Data: spki[:]}}
+	before := len(server.Messages())
+	var unknownAuthority x509.UnknownAuthorityError
+	if err := s.Send(testMessage()); !errors.As(err, &unknownAuthority) {
+		t.Fatalf("expected an unknown authority error, got %v", err)
+	}
+	if len(server.Messages()) != before {
+		t.Fatal("the message was sent to an untrusted server")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	s.TLSConfig = &tls.Config{RootCAs: pool}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	s.TLSConfig = nil
+
+	// TLSA records require TLS, even if the policy disables it.
+	plain := newTestServer(t)
+	s.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, plain.Addr())
+	}
+	s.StartTLS = StartTLSDisabled
+	if err := s.Send(testMessage()); !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Fatalf("expected ErrStartTLSUnsupported, got %v", err)
+	}
+
+	records = nil
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+}
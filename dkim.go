@@ -0,0 +1,273 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIM canonicalization algorithms.
+const (
+	DKIMSimple  = "simple"
+	DKIMRelaxed = "relaxed"
+)
+
+// defaultDKIMHeaders are signed, if present, when DKIMSigner.Headers is
+// empty.
+var defaultDKIMHeaders = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMSigner signs messages with DKIM (RFC 6376) so that receivers can
+// verify that they come from Domain with the public key published at
+// Selector._domainkey.Domain. Set it as the DKIM of a message to sign it
+// when it is serialized, or use SignMessage on an already serialized one.
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+
+	// Key is an RSA or Ed25519 private key.
+	Key crypto.Signer
+
+	// Headers are the names of the header fields to sign. From is always
+	// signed.
+	Headers []string
+
+	// HeaderCanonicalization and BodyCanonicalization are DKIMSimple or
+	// DKIMRelaxed, the default.
+	HeaderCanonicalization string
+	BodyCanonicalization   string
+
+	// Clock returns the signing time, time.Now if nil.
+	Clock func() time.Time
+}
+
+// SignMessage returns the serialized message with a DKIM-Signature header
+// prepended.
+func (d *DKIMSigner) SignMessage(message []byte) ([]byte, error) {
+	if d.Key == nil {
+		return nil, errors.New("dkim: missing key")
+	}
+
+	var algorithm string
+	switch d.Key.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PublicKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, errors.New("dkim: unsupported key type")
+	}
+
+	headerCanonicalization, err := dkimCanonicalization(d.HeaderCanonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyCanonicalization, err := dkimCanonicalization(d.BodyCanonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	header, body := message, []byte(nil)
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i != -1 {
+		header, body = message[:i+2], message[i+4:]
+	}
+
+	fields := splitHeaderFields(header)
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanonicalization))
+
+	// Sign the requested fields from the bottom up, so that a repeated
+	// name signs the last unused instance of it.
+	names := d.Headers
+	if len(names) == 0 {
+		names = defaultDKIMHeaders
+	}
+
+	if !containsFold(names, "From") {
+		names = append([]string{"From"}, names...)
+	}
+
+	used := make([]bool, len(fields))
+	var signedNames []string
+	var signed bytes.Buffer
+
+	for _, name := range names {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), name) {
+				continue
+			}
+
+			used[i] = true
+			signedNames = append(signedNames, strings.ToLower(name))
+			signed.WriteString(canonicalHeader(fields[i], headerCanonicalization))
+			break
+		}
+	}
+
+	now := time.Now
+	if d.Clock != nil {
+		now = d.Clock
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=" + headerCanonicalization + "/" + bodyCanonicalization,
+		"d=" + d.Domain,
+		"s=" + d.Selector,
+		"t=" + strconv.FormatInt(now().Unix(), 10),
+		"h=" + strings.Join(signedNames, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+
+	folded := bytes.NewBuffer(nil)
+	writeHeader(&writer{w: folded}, "DKIM-Signature", strings.Join(tags, "; "))
+	dkimHeader := strings.TrimSuffix(folded.String(), "\r\n")
+
+	signed.WriteString(strings.TrimSuffix(canonicalHeader(dkimHeader+"\r\n", headerCanonicalization), "\r\n"))
+
+	hash := sha256.Sum256(signed.Bytes())
+
+	var signature []byte
+	if algorithm == "rsa-sha256" {
+		signature, err = d.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	} else {
+		signature, err = d.Key.Sign(rand.Reader, hash[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Whitespace in the b= value is ignored, so fold it freely.
+	b := base64.StdEncoding.EncodeToString(signature)
+	for len(b) > 70 {
+		dkimHeader += "\r\n " + b[:70]
+		b = b[70:]
+	}
+	dkimHeader += "\r\n " + b + "\r\n"
+
+	return append([]byte(dkimHeader), message...), nil
+}
+
+func dkimCanonicalization(c string) (string, error) {
+	switch c {
+	case "":
+		return DKIMRelaxed, nil
+	case DKIMSimple, DKIMRelaxed:
+		return c, nil
+	default:
+		return "", errors.New("dkim: unknown canonicalization " + c)
+	}
+}
+
+// splitHeaderFields splits a header in its fields, each with its
+// continuation lines and trailing CRLF.
+func splitHeaderFields(header []byte) []string {
+	var fields []string
+
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+
+	return fields
+}
+
+func fieldName(field string) string {
+	if i := strings.Index(field, ":"); i != -1 {
+		return strings.TrimSpace(field[:i])
+	}
+
+	return ""
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func canonicalHeader(field, canonicalization string) string {
+	if canonicalization == DKIMSimple {
+		return field
+	}
+
+	i := strings.Index(field, ":")
+	name := strings.ToLower(strings.TrimSpace(field[:i]))
+
+	value := collapseWhitespace(strings.Replace(field[i+1:], "\r\n", "", -1))
+
+	return name + ":" + strings.TrimPrefix(value, " ") + "\r\n"
+}
+
+func canonicalBody(body []byte, canonicalization string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	if canonicalization == DKIMRelaxed {
+		for i, line := range lines {
+			lines[i] = collapseWhitespace(line)
+		}
+	}
+
+	// Ignore the empty lines at the end of the body.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if canonicalization == DKIMSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWhitespace reduces each run of spaces and tabs to a single space
+// and removes the trailing ones.
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	space := false
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			space = true
+			continue
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package email
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDKIMCanonicalBody(t *testing.T) {
+	// From the example of RFC 8463, appendix A.
+	body := []byte("Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n\r\n")
+
+	hash := sha256.Sum256(canonicalBody(body, DKIMRelaxed))
+	if bh := base64.StdEncoding.EncodeToString(hash[:]); bh != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+		t.Fatalf("unexpected body hash %s", bh)
+	}
+
+	for _, test := range []struct {
+		body, simple, relaxed string
+	}{
+		{"", "\r\n", ""},
+		{" C \r\nD \t E\r\n\r\n\r\n", " C \r\nD \t E\r\n", " C\r\nD E\r\n"},
+	} {
+		if c := string(canonicalBody([]byte(test.body), DKIMSimple)); c != test.simple {
+			t.Errorf("simple %q: expected %q, got %q", test.body, test.simple, c)
+		}
+
+		if c := string(canonicalBody([]byte(test.body), DKIMRelaxed)); c != test.relaxed {
+			t.Errorf("relaxed %q: expected %q, got %q", test.body, test.relaxed, c)
+		}
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString("nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A=")
+	key := ed25519.NewKeyFromSeed(seed)
+
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Date = time.Date(2012, 4, 5, 10, 30, 0, 0, time.UTC)
+	m.MessageID = "<1@example.com>"
+	m.DKIM = &DKIMSigner{
+		Domain:   "example.com",
+		Selector: "brisbane",
+		Key:      key,
+		Headers:  []string{"Subject", "Date"},
+		Clock:    func() time.Time { return time.Unix(1528637909, 0) },
+	}
+
+	data := string(m.Bytes())
+
+	if !strings.HasPrefix(data, "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=example.com;\r\n s=brisbane; t=1528637909; h=from:subject:date;\r\n bh=") {
+		t.Fatalf("unexpected DKIM-Signature:\n%s", data)
+	}
+
+	fields := splitHeaderFields([]byte(data[:strings.Index(data, "\r\n\r\n")+2]))
+
+	i := strings.Index(fields[0], "b=")
+	for strings.Contains(fields[0][i+2:], "b=") {
+		i += 2 + strings.Index(fields[0][i+2:], "b=")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(fields[0][i+2:]), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var from, subject, date string
+	for _, field := range fields {
+		switch fieldName(field) {
+		case "From":
+			from = field
+		case "Subject":
+			subject = field
+		case "Date":
+			date = field
+		}
+	}
+
+	signed := "from:" + strings.TrimSpace(from[5:]) + "\r\n" +
+		"subject:" + strings.TrimSpace(subject[8:]) + "\r\n" +
+		"date:" + strings.TrimSpace(date[5:]) + "\r\n" +
+		"dkim-signature:" + strings.Join(strings.Fields(strings.Replace(fields[0][len("DKIM-Signature:"):i+2], "\r\n", "", -1)), " ")
+
+	hash := sha256.Sum256([]byte(signed))
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), hash[:], signature) {
+		t.Fatalf("invalid signature over:\n%s", signed)
+	}
+	m.DKIM.Key = nil
+	if _, err := m.WriteTo(io.Discard); err == nil {
+		t.Fatal("expected an error without a key")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+)
+
+// DryRunSender validates and serializes messages as SMTPSender does, but
+// logs them, or hands them to Handler, instead of sending them, so that
+// staging environments can run the whole pipeline without mailing anyone.
+type DryRunSender struct {
+	// Handler, if set, is called with the envelope and the serialized
+	// bytes of each message instead of logging it, and its error is that
+	// of the send.
+	Handler func(from string, to []string, data []byte) error
+
+	// ErrorLog logs a line for each message, as SMTPSender logs its
+	// warnings. If nil, it is logged with the standard logger of the log
+	// package.
+	ErrorLog *log.Logger
+
+	// MaxSize, if positive, is the size in bytes of the largest message,
+	// as in SMTPSender.
+	MaxSize int64
+}
+
+// Send sends the message. See SendContext.
+func (s *DryRunSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext validates and serializes the message and logs it, or calls
+// Handler. It fails as SMTPSender would before connecting, like with
+// ErrMessageTooLarge, or if ctx is done.
+func (s *DryRunSender) SendContext(ctx context.Context, m *Message) error {
+	smtp := &SMTPSender{MaxSize: s.MaxSize, ErrorLog: s.ErrorLog}
+
+	from, err := smtp.prepare(m)
+	if err != nil {
+		return err
+	}
+
+	to := m.Tolist()
+	if len(to) == 0 {
+		return ErrNoRecipients
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The logged Message-ID is the one that the data has.
+	m = m.withMessageID()
+
+	data := bytes.NewBuffer(nil)
+	if _, err := m.WriteTo(data); err != nil {
+		return err
+	}
+
+	if s.Handler != nil {
+		return s.Handler(from, to, data.Bytes())
+	}
+
+	smtp.logf("email: dry run: %s from <%s> to <%s>, %q, %d bytes", m.MessageID, from, strings.Join(to, ">, <"), m.Subject, data.Len())
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"regexp"
+	"testing"
+)
+
+func TestDryRunSender(t *testing.T) {
+	logs := bytes.NewBuffer(nil)
+
+	m := testMessage()
+	m.Bcc = []Address{{Email: "bcc@example.com"}}
+
+	s := &DryRunSender{ErrorLog: log.New(logs, "", 0)}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := regexp.MustCompile(`^email: dry run: <\w+@example\.com> from <from@example\.com> to <to@example\.com>, <bcc@example\.com>, "Hi", \d+ bytes\n$`)
+	if !expected.MatchString(logs.String()) {
+		t.Fatalf("unexpected log: %s", logs)
+	}
+
+	var data []byte
+	s.Handler = func(from string, to []string, b []byte) error {
+		data = b
+		return nil
+	}
+
+	m.MessageID = "<1@example.com>"
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, m.Bytes()) {
+		t.Fatalf("unexpected data:\n%s", data)
+	}
+
+	s.MaxSize = 10
+	if err := s.Send(m); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+
+	m.From = Address{}
+	if err := s.Send(m); err == nil {
+		t.Fatal("expected an invalid message to fail")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// DSN requests delivery status notifications, as RFC 3461 describes:
+// machine-readable reports that the servers send to the envelope sender
+// when the message is delivered, delayed or fails. It is ignored by the
+// servers that don't offer the DSN extension.
+type DSN struct {
+	// Notify is when to send a notification. If zero, servers notify
+	// failures, as they do without DSN.
+	Notify Notify
+
+	// Return is how much of the message failure notifications include,
+	// ReturnHeaders or ReturnFull. If empty, the server chooses.
+	Return string
+
+	// EnvelopeID is an identifier of the message, up to 100 characters,
+	// that the notifications include to match them with it.
+	EnvelopeID string
+}
+
+// Notify is a set of events that trigger a delivery status notification.
+type Notify int
+
+const (
+	NotifySuccess Notify = 1 << iota
+	NotifyFailure
+	NotifyDelay
+
+	// NotifyNever requests no notifications at all, not even of failures.
+	// It can't be combined with the others.
+	NotifyNever
+)
+
+const (
+	// ReturnHeaders includes only the headers of the message in failure
+	// notifications.
+	ReturnHeaders = "HDRS"
+
+	// ReturnFull includes the whole message in failure notifications.
+	ReturnFull = "FULL"
+)
+
+func (n Notify) String() string {
+	if n&NotifyNever != 0 {
+		return "NEVER"
+	}
+
+	var events []string
+	for _, e := range []struct {
+		notify Notify
+		name   string
+	}{
+		{NotifySuccess, "SUCCESS"},
+		{NotifyFailure, "FAILURE"},
+		{NotifyDelay, "DELAY"},
+	} {
+		if n&e.notify != 0 {
+			events = append(events, e.name)
+		}
+	}
+
+	return strings.Join(events, ",")
+}
+
+// mailParams returns the DSN parameters of the MAIL FROM command, if the
+// server offers DSN.
+func (d *DSN) mailParams(c *smtp.Client) string {
+	if d == nil {
+		return ""
+	}
+
+	if ok, _ := c.Extension("DSN"); !ok {
+		return ""
+	}
+
+	var params string
+	if d.Return != "" {
+		params += " RET=" + d.Return
+	}
+
+	if d.EnvelopeID != "" {
+		params += " ENVID=" + xtext(d.EnvelopeID)
+	}
+
+	return params
+}
+
+// rcptParams returns the DSN parameters of the RCPT TO command for the
+// recipient, if the server offers DSN.
+func (d *DSN) rcptParams(c *smtp.Client, to string) string {
+	if d == nil {
+		return ""
+	}
+
+	if ok, _ := c.Extension("DSN"); !ok {
+		return ""
+	}
+
+	var params string
+	if d.Notify != 0 {
+		params += " NOTIFY=" + d.Notify.String()
+	}
+
+	// The original recipient, which the notifications report even if the
+	// message is forwarded to another address.
+	if isASCII(to) {
+		params += " ORCPT=rfc822;" + xtext(to)
+	} else {
+		params += " ORCPT=utf-8;" + utf8AddrXtext(to)
+	}
+
+	return params
+}
+
+// xtext encodes s as the xtext of RFC 3461, section 4, with the characters
+// that aren't printable ASCII, "+" and "=" as "+" and their hexadecimal
+// code.
+func xtext(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// utf8AddrXtext encodes the address for a utf-8 ORCPT as RFC 6533 does,
+// with the ASCII controls, spaces, "+", "=" and "\" as "\x{...}".
+func utf8AddrXtext(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < '!' || r == '+' || r == '=' || r == '\\' || r == 0x7f {
+			fmt.Fprintf(&b, `\x{%X}`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
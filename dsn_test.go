@@ -0,0 +1,68 @@
+package email
+
+import "testing"
+
+func TestDSN(t *testing.T) {
+	server := newTestServer(t)
+	server.replies["EHLO"] = "250-localhost\r\n250-8BITMIME\r\n250 DSN"
+
+	m := testMessage()
+	m.To = append(m.To, Address{Email: "a+b@example.com"})
+	m.DSN = &DSN{Notify: NotifySuccess | NotifyDelay, Return: ReturnHeaders, EnvelopeID: "id=1"}
+
+	s := &SMTPSender{Addr: server.Addr()}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"EHLO localhost",
+		"MAIL FROM:<from@example.com> BODY=8BITMIME RET=HDRS ENVID=id+3D1",
+		"RCPT TO:<to@example.com> NOTIFY=SUCCESS,DELAY ORCPT=rfc822;to@example.com",
+		"RCPT TO:<a+b@example.com> NOTIFY=SUCCESS,DELAY ORCPT=rfc822;a+2Bb@example.com",
+		"DATA",
+	}
+
+	commands := server.Commands()
+	for i, command := range expected {
+		if commands[i] != command {
+			t.Fatalf("expected %q, got %q", command, commands[i])
+		}
+	}
+
+	// Servers without DSN get no parameters.
+	server = newTestServer(t)
+
+	s = &SMTPSender{Addr: server.Addr()}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if commands := server.Commands(); commands[1] != "MAIL FROM:<from@example.com> BODY=8BITMIME" || commands[2] != "RCPT TO:<to@example.com>" {
+		t.Fatalf("expected no DSN parameters, got %q", commands)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tests := map[Notify]string{
+		NotifyFailure: "FAILURE",
+		NotifySuccess | NotifyFailure | NotifyDelay: "SUCCESS,FAILURE,DELAY",
+		NotifyNever: "NEVER",
+	}
+
+	for notify, expected := range tests {
+		if s := notify.String(); s != expected {
+			t.Errorf("expected %s, got %s", expected, s)
+		}
+	}
+}
+
+func TestXtext(t *testing.T) {
+	if s := xtext("a+b=c d\x01"); s != "a+2Bb+3Dc+20d+01" {
+		t.Fatalf("unexpected xtext %s", s)
+	}
+
+	if s := utf8AddrXtext("josé+1@example.com"); s != `josé\x{2B}1@example.com` {
+		t.Fatalf("unexpected utf-8 xtext %s", s)
+	}
+}
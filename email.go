@@ -4,65 +4,517 @@ package email
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net/http"
 	"net/mail"
 	"net/smtp"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Attachment struct {
 	Filename string
 	Data     []byte
 	Inline   bool
+
+	// ContentType overrides the type detected from the filename extension
+	// or, failing that, from the content.
+	ContentType string
+
+	// ContentID is set for attachments embedded in the body, which reference
+	// them with a cid: URL.
+	ContentID string
+
+	// Description is written as the Content-Description of the part.
+	Description string
+
+	// Headers holds extra header fields for the part.
+	Headers Headers
+
+	// TransferEncoding is the Content-Transfer-Encoding of the part,
+	// base64 if empty, or 7bit or 8bit for message/rfc822 attachments,
+	// which can't be encoded. 8bit parts can only be sent to servers that
+	// offer 8BITMIME.
+	TransferEncoding TransferEncoding
+}
+
+// AttachOption configures an attachment when it is added to a message.
+type AttachOption func(*Attachment)
+
+// WithContentType sets the Content-Type of the attachment instead of
+// detecting it.
+func WithContentType(contentType string) AttachOption {
+	return func(a *Attachment) {
+		a.ContentType = contentType
+	}
+}
+
+// WithFilename sets the filename shown to the recipient, which by default
+// is the name of the attached file.
+func WithFilename(filename string) AttachOption {
+	return func(a *Attachment) {
+		a.Filename = filename
+	}
+}
+
+// WithTransferEncoding sets the Content-Transfer-Encoding of the
+// attachment, such as SevenBit for a plain text file that should stay
+// readable in the source of the message.
+func WithTransferEncoding(encoding TransferEncoding) AttachOption {
+	return func(a *Attachment) {
+		a.TransferEncoding = encoding
+	}
+}
+
+// WithDescription sets the Content-Description of the attachment.
+func WithDescription(description string) AttachOption {
+	return func(a *Attachment) {
+		a.Description = description
+	}
+}
+
+// WithHeader adds a header field to the attachment part.
+func WithHeader(key, value string) AttachOption {
+	return func(a *Attachment) {
+		a.Headers.Add(key, value)
+	}
+}
+
+// contentType returns the MIME type of the attachment.
+func (a *Attachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(a.Filename)); contentType != "" {
+		return contentType
+	}
+
+	return http.DetectContentType(a.Data)
+}
+
+// Priority flags a message as more or less important than usual.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityLow
+)
+
+// Signer signs the MIME entity of a message, its Content-* headers and body
+// exactly as they are sent, and returns the signed entity that replaces it.
+type Signer interface {
+	Sign(entity []byte) ([]byte, error)
+}
+
+// Encrypter encrypts the MIME entity of a message, after signing it if it
+// has a Signer, and returns the encrypted entity that replaces it.
+type Encrypter interface {
+	Encrypt(entity []byte) ([]byte, error)
+}
+
+// Alternative is an alternative representation of the message body, such as
+// a plain text fallback for an HTML message.
+type Alternative struct {
+	ContentType string
+	Body        string
+
+	// Charset is the charset the body is sent in, that of the message if
+	// empty. See Message.Charset.
+	Charset string
+
+	// TransferEncoding is the Content-Transfer-Encoding of the body,
+	// chosen from its content if empty.
+	TransferEncoding TransferEncoding
 }
 
 type Message struct {
-	From            string
-	To              []string
-	Cc              []string
-	Bcc             []string
+	From            Address
+	Sender          Address
+	To              []Address
+	Cc              []Address
+	Bcc             []Address
+	ReplyTo         []Address
 	ReturnPath      string
 	Subject         string
 	Body            string
 	BodyContentType string
-	Attachments     map[string]*Attachment
+	Alternatives    []*Alternative
+	Attachments     []*Attachment
+
+	// BodyTransferEncoding is the Content-Transfer-Encoding of the body,
+	// chosen from its content if empty: 7bit for short lines of ASCII,
+	// 8bit for other text if the server accepts it, and quoted-printable
+	// or base64, whichever is smaller, otherwise. Alternatives have their
+	// own.
+	BodyTransferEncoding TransferEncoding
+
+	// Headers holds extra header fields. Fields that would duplicate the
+	// ones generated from the message, like From or Content-Type, are
+	// ignored.
+	Headers Headers
+
+	// MessageID is the value of the Message-ID header, including the angle
+	// brackets. If empty, a new one is generated from a random token and
+	// the domain of From every time the message is serialized, without
+	// storing it here, so that the same message can be sent concurrently
+	// or again. Set it to know the ID of a message before sending it.
+	MessageID string
+
+	// Date is the value of the Date header. If zero, the time at which the
+	// message is serialized is used, as returned by Clock or time.Now when
+	// Clock is nil.
+	Date  time.Time
+	Clock func() time.Time
+
+	// InReplyTo and References hold the Message-IDs, including the angle
+	// brackets, of the messages this one replies to so clients can thread
+	// the conversation.
+	InReplyTo  string
+	References []string
+
+	// ReadReceiptTo requests a read receipt (an MDN, see ParseMDN) to be
+	// sent to these addresses when the message is displayed.
+	ReadReceiptTo []string
+
+	// Priority is written as the X-Priority, Importance and
+	// X-MSMail-Priority headers unless it is PriorityNormal.
+	Priority Priority
+
+	// ListUnsubscribe holds the mailto: and https: URIs recipients can use
+	// to unsubscribe. With ListUnsubscribeOneClick the https URI accepts
+	// the one-click POST of RFC 8058, as Gmail and Yahoo require from bulk
+	// senders.
+	ListUnsubscribe         []string
+	ListUnsubscribeOneClick bool
+
+	// EnvelopeFrom is the address used in the SMTP MAIL FROM command, where
+	// bounces are sent, instead of Sender or From. Unlike ReturnPath, which
+	// receiving servers replace, it isn't written in the headers.
+	EnvelopeFrom string
+
+	// DSN, if set, requests delivery status notifications from the servers
+	// that support them.
+	DSN *DSN
+
+	// UTF8Domains keeps internationalized domains, like bücher.de, in UTF-8
+	// when the server offers SMTPUTF8. Otherwise they are converted to
+	// their punycode form, like xn--bcher-kva.de, in the envelope and the
+	// headers.
+	UTF8Domains bool
+
+	// Signer, if set, signs the MIME entity of the message, like
+	// SMIMESigner does, and Encrypter encrypts it, like SMIMEEncrypter.
+	Signer    Signer
+	Encrypter Encrypter
+
+	// DKIM, if set, adds a DKIM signature to the message.
+	DKIM *DKIMSigner
+
+	// InlineCSS moves the style sheets of the HTML body into style
+	// attributes when the message is serialized. See the InlineCSS function.
+	InlineCSS bool
+
+	// Tracker, if set, tracks when the message is opened.
+	Tracker *Tracker
+
+	// Zip, if set, bundles the attachments into a zip file when the message
+	// is serialized.
+	Zip *ZipArchive
+
+	// Charset is the charset the body and alternatives are sent in, UTF-8
+	// if empty. Others must be US-ASCII, ISO-8859-1 or registered with
+	// RegisterCharset. Text that can't be encoded in it fails to serialize.
+	Charset string
 }
 
-func (m *Message) attach(file string, inline bool) error {
+func (m *Message) attach(file string, inline bool, options []AttachOption) (*Attachment, error) {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, filename := filepath.Split(file)
 
-	m.Attachments[filename] = &Attachment{
+	return m.attachBytes(filename, data, inline, options), nil
+}
+
+func (m *Message) attachReader(filename string, r io.Reader, inline bool, options []AttachOption) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.attachBytes(filename, data, inline, options)
+
+	return nil
+}
+
+func (m *Message) attachBytes(filename string, data []byte, inline bool, options []AttachOption) *Attachment {
+	attachment := &Attachment{
 		Filename: filename,
 		Data:     data,
 		Inline:   inline,
 	}
 
+	for _, option := range options {
+		option(attachment)
+	}
+
+	m.Attachments = append(m.Attachments, attachment)
+
+	return attachment
+}
+
+// Attachment returns the first attachment with the given filename or nil if
+// there is none.
+func (m *Message) Attachment(filename string) *Attachment {
+	for _, attachment := range m.Attachments {
+		if attachment.Filename == filename {
+			return attachment
+		}
+	}
+
 	return nil
 }
 
-func (m *Message) Attach(file string) error {
-	return m.attach(file, false)
+func (m *Message) Attach(file string, options ...AttachOption) error {
+	_, err := m.attach(file, false, options)
+	return err
 }
 
-func (m *Message) Inline(file string) error {
-	return m.attach(file, true)
+// AttachReader attaches the content read from r with the given filename.
+func (m *Message) AttachReader(filename string, r io.Reader, options ...AttachOption) error {
+	return m.attachReader(filename, r, false, options)
 }
 
-func newMessage(subject string, body string, bodyContentType string) *Message {
-	m := &Message{Subject: subject, Body: body, BodyContentType: bodyContentType}
+// AttachBytes attaches data with the given filename.
+func (m *Message) AttachBytes(filename string, data []byte, options ...AttachOption) {
+	m.attachBytes(filename, data, false, options)
+}
+
+// AttachMessage attaches original as a message/rfc822 part, which is how
+// messages are forwarded as attachments.
+func (m *Message) AttachMessage(original *Message, options ...AttachOption) error {
+	buf := bytes.NewBuffer(nil)
+	if _, err := original.WriteTo(buf); err != nil {
+		return err
+	}
+
+	return m.AttachRawMessage(buf.Bytes(), options...)
+}
+
+// AttachRawMessage is like AttachMessage for a message already serialized,
+// like one that was received. The filename is taken from its subject.
+func (m *Message) AttachRawMessage(original []byte, options ...AttachOption) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(original))
+	if err != nil {
+		return err
+	}
+
+	subject := msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		subject = decoded
+	}
 
-	m.Attachments = make(map[string]*Attachment)
+	filename := "message.eml"
+	if subject = strings.TrimSpace(subject); subject != "" {
+		filename = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+				return '_'
+			}
+			return r
+		}, subject) + ".eml"
+	}
 
-	return m
+	options = append([]AttachOption{WithContentType("message/rfc822")}, options...)
+	m.attachBytes(filename, original, false, options)
+
+	return nil
+}
+
+// AttachURLClient is the client AttachURL downloads with. Its timeout
+// bounds downloads whose context has no deadline.
+var AttachURLClient = &http.Client{Timeout: time.Minute}
+
+// AttachURLMaxSize is the largest content AttachURL downloads.
+var AttachURLMaxSize int64 = 25 << 20
+
+// ErrAttachmentTooLarge is returned by AttachURL for content larger than
+// AttachURLMaxSize.
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// AttachURL downloads the content at url and attaches it with the
+// Content-Type of the response and the filename from its
+// Content-Disposition or, failing that, the URL path.
+func (m *Message) AttachURL(ctx context.Context, url string, options ...AttachOption) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := AttachURLClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("attach %s: %s", url, resp.Status)
+	}
+
+	if resp.ContentLength > AttachURLMaxSize {
+		return ErrAttachmentTooLarge
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, AttachURLMaxSize+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) > AttachURLMaxSize {
+		return ErrAttachmentTooLarge
+	}
+
+	filename := path.Base(req.URL.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = path.Base(params["filename"])
+	}
+
+	if filename == "/" || filename == "." {
+		filename = "attachment"
+	}
+
+	options = append([]AttachOption{WithContentType(resp.Header.Get("Content-Type"))}, options...)
+	m.attachBytes(filename, data, false, options)
+
+	return nil
+}
+
+func (m *Message) Inline(file string, options ...AttachOption) error {
+	_, err := m.attach(file, true, options)
+	return err
+}
+
+// InlineReader is like AttachReader but the content is displayed inline.
+func (m *Message) InlineReader(filename string, r io.Reader, options ...AttachOption) error {
+	return m.attachReader(filename, r, true, options)
+}
+
+// InlineBytes is like AttachBytes but the content is displayed inline.
+func (m *Message) InlineBytes(filename string, data []byte, options ...AttachOption) {
+	m.attachBytes(filename, data, true, options)
+}
+
+// Embed attaches an image to be displayed inside an HTML body and returns
+// its generated Content-ID, a random token in the domain of the sender,
+// which the body references as "cid:" + id:
+//
+//	cid, err := m.Embed("logo.png")
+//	m.Body = `<img src="cid:` + cid + `">`
+func (m *Message) Embed(file string, options ...AttachOption) (string, error) {
+	token, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+
+	attachment, err := m.attach(file, false, options)
+	if err != nil {
+		return "", err
+	}
+
+	attachment.ContentID = token + "@" + m.domain()
+
+	return attachment.ContentID, nil
+}
+
+// AddAlternative adds an alternative representation of the body. When a
+// message has alternatives the body is sent as multipart/alternative, with
+// plain text first and HTML last so clients pick the richest version.
+func (m *Message) AddAlternative(contentType string, body string) {
+	m.Alternatives = append(m.Alternatives, &Alternative{ContentType: contentType, Body: body})
+}
+
+// AddAMP adds an AMP for Email version of the body. Clients that support it
+// show it instead of the HTML body, which must also be present.
+func (m *Message) AddAMP(body string) {
+	m.AddAlternative("text/x-amp-html", body)
+}
+
+// ReplyHeadersFrom sets InReplyTo and References to reply to original,
+// which must have a MessageID.
+func (m *Message) ReplyHeadersFrom(original *Message) error {
+	if original.MessageID == "" {
+		return errors.New("the original message has no Message-ID")
+	}
+
+	references := original.References
+	if len(references) == 0 && original.InReplyTo != "" {
+		references = []string{original.InReplyTo}
+	}
+
+	m.InReplyTo = original.MessageID
+	m.References = append(append([]string(nil), references...), original.MessageID)
+
+	return nil
+}
+
+// Clone returns a copy of the message that can be changed without
+// affecting it, such as to send a base message to each recipient with its
+// own To and subject. The addresses, alternatives, attachments, headers and
+// DSN are copied, but not the attachment data, which the package never
+// changes, nor the Signer, Encrypter, DKIM, Tracker and Zip settings.
+//
+// The MessageID is copied too. If it is set, clear it in the copy so that
+// it gets its own.
+func (m *Message) Clone() *Message {
+	c := *m
+	c.To = append([]Address(nil), m.To...)
+	c.Cc = append([]Address(nil), m.Cc...)
+	c.Bcc = append([]Address(nil), m.Bcc...)
+	c.ReplyTo = append([]Address(nil), m.ReplyTo...)
+	c.Headers = append(Headers(nil), m.Headers...)
+	c.References = append([]string(nil), m.References...)
+	c.ReadReceiptTo = append([]string(nil), m.ReadReceiptTo...)
+	c.ListUnsubscribe = append([]string(nil), m.ListUnsubscribe...)
+
+	c.Alternatives = make([]*Alternative, len(m.Alternatives))
+	for i, alternative := range m.Alternatives {
+		a := *alternative
+		c.Alternatives[i] = &a
+	}
+
+	c.Attachments = make([]*Attachment, len(m.Attachments))
+	for i, attachment := range m.Attachments {
+		a := *attachment
+		a.Headers = append(Headers(nil), attachment.Headers...)
+		c.Attachments[i] = &a
+	}
+
+	if m.DSN != nil {
+		dsn := *m.DSN
+		c.DSN = &dsn
+	}
+
+	return &c
+}
+
+func newMessage(subject string, body string, bodyContentType string) *Message {
+	return &Message{Subject: subject, Body: body, BodyContentType: bodyContentType}
 }
 
 // NewMessage returns a new Message that can compose an email with attachments
@@ -74,92 +526,787 @@ func NewHTMLMessage(subject string, body string) *Message {
 	return newMessage(subject, body, "text/html")
 }
 
-func (m *Message) Tolist() []string {
-	tolist := m.To
+// SetFrom parses address and sets it as From.
+func (m *Message) SetFrom(address string) error {
+	return setAddress(&m.From, "From", address)
+}
 
-	for _, cc := range m.Cc {
-		tolist = append(tolist, cc)
+// SetSender parses address and sets it as Sender.
+func (m *Message) SetSender(address string) error {
+	return setAddress(&m.Sender, "Sender", address)
+}
+
+// AddTo parses addresses and adds them to To. If any is invalid, none is
+// added.
+func (m *Message) AddTo(addresses ...string) error {
+	return addAddresses(&m.To, "To", addresses)
+}
+
+// AddCc is like AddTo for Cc.
+func (m *Message) AddCc(addresses ...string) error {
+	return addAddresses(&m.Cc, "Cc", addresses)
+}
+
+// AddBcc is like AddTo for Bcc.
+func (m *Message) AddBcc(addresses ...string) error {
+	return addAddresses(&m.Bcc, "Bcc", addresses)
+}
+
+// AddReplyTo is like AddTo for ReplyTo.
+func (m *Message) AddReplyTo(addresses ...string) error {
+	return addAddresses(&m.ReplyTo, "Reply-To", addresses)
+}
+
+func setAddress(a *Address, field string, address string) error {
+	parsed, err := ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid %s address %q: %v", field, address, err)
 	}
 
-	for _, bcc := range m.Bcc {
-		tolist = append(tolist, bcc)
+	*a = parsed
+
+	return nil
+}
+
+func addAddresses(list *[]Address, field string, addresses []string) error {
+	parsed := make([]Address, len(addresses))
+	for i, address := range addresses {
+		if err := setAddress(&parsed[i], field, address); err != nil {
+			return err
+		}
+	}
+
+	*list = append(*list, parsed...)
+
+	return nil
+}
+
+// Tolist returns the emails of all the recipients, in To, Cc and Bcc.
+func (m *Message) Tolist() []string {
+	var tolist []string
+	for _, list := range [][]Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			tolist = append(tolist, a.Email)
+		}
 	}
 
 	return tolist
 }
 
+// Bytes returns the serialized message, or nil if it can't be serialized
+// because the Signer, Encrypter or DKIM signer failed. Use WriteTo to get the error.
 func (m *Message) Bytes() []byte {
 	buf := bytes.NewBuffer(nil)
+	if _, err := m.WriteTo(buf); err != nil {
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+// EstimatedSize returns the size in bytes of the message as it is sent,
+// with the transfer encoding of the body and attachments. It serializes the
+// message to count it, but doesn't keep it in memory. If the message can't
+// be serialized, the size is only that of the part written before failing.
+func (m *Message) EstimatedSize() int64 {
+	n, _ := m.WriteTo(ioutil.Discard)
+	return n
+}
+
+// WriteTo writes the message to w as it is serialized, without holding it
+// in memory as Bytes does unless it has to be signed.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	return m.write(&writer{w: w})
+}
+
+// write serializes the message into buf, whose flags tell which SMTP
+// extensions the message can rely on, and signs it with DKIM if set.
+func (m *Message) write(buf *writer) (int64, error) {
+	m = m.withMessageID()
+
+	if m.DKIM == nil {
+		err := m.writeTo(buf)
+		return buf.n, err
+	}
+
+	unsigned := bytes.NewBuffer(nil)
+	if err := m.writeTo(&writer{w: unsigned, utf8: buf.utf8, utf8Domains: buf.utf8Domains, eightBit: buf.eightBit, smtp: buf.smtp}); err != nil {
+		return 0, err
+	}
+
+	signed, err := m.DKIM.SignMessage(unsigned.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	buf.Write(signed)
+
+	return buf.n, buf.err
+}
+
+func (m *Message) writeTo(buf *writer) error {
+	if err := m.checkHeaders(); err != nil {
+		return err
+	}
 
 	if len(m.ReturnPath) > 0 {
-		buf.WriteString("Return-Path: " + m.ReturnPath + "\n")
+		writeHeader(buf, "Return-Path", m.ReturnPath)
 	}
 
-	buf.WriteString("From: " + m.From + "\n")
-	buf.WriteString("To: " + strings.Join(m.To, ",") + "\n")
+	writeHeader(buf, "From", buf.address(m.From))
+	if m.Sender.Email != "" {
+		writeHeader(buf, "Sender", buf.address(m.Sender))
+	}
+
+	writeHeader(buf, "To", buf.addressList(m.To))
 	if len(m.Cc) > 0 {
-		buf.WriteString("Cc: " + strings.Join(m.Cc, ",") + "\n")
+		writeHeader(buf, "Cc", buf.addressList(m.Cc))
+	}
+
+	if len(m.ReplyTo) > 0 {
+		writeHeader(buf, "Reply-To", buf.addressList(m.ReplyTo))
+	}
+
+	writeHeader(buf, "Subject", buf.word(m.Subject))
+	writeHeader(buf, "Date", m.date().Format(time.RFC1123Z))
+
+	writeHeader(buf, "Message-ID", m.MessageID)
+
+	if m.InReplyTo != "" {
+		writeHeader(buf, "In-Reply-To", m.InReplyTo)
 	}
 
-	buf.WriteString("Subject: " + m.Subject + "\n")
-	buf.WriteString("MIME-Version: 1.0\n")
+	if len(m.References) > 0 {
+		writeHeader(buf, "References", strings.Join(m.References, " "))
+	}
 
-	boundary := "f46d043c813270fc6b04c2d223da"
+	if len(m.ReadReceiptTo) > 0 {
+		writeHeader(buf, "Disposition-Notification-To", buf.addressList(parseAddresses(m.ReadReceiptTo)))
+		writeHeader(buf, "Return-Receipt-To", buf.addressList(parseAddresses(m.ReadReceiptTo)))
+	}
 
-	if len(m.Attachments) > 0 {
-		buf.WriteString("Content-Type: multipart/mixed; boundary=" + boundary + "\n\n")
-		buf.WriteString("--" + boundary + "\n")
+	if len(m.ListUnsubscribe) > 0 {
+		uris := make([]string, len(m.ListUnsubscribe))
+		for i, uri := range m.ListUnsubscribe {
+			uris[i] = "<" + uri + ">"
+		}
+
+		writeHeader(buf, "List-Unsubscribe", strings.Join(uris, ", "))
+
+		if m.ListUnsubscribeOneClick {
+			writeHeader(buf, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
 	}
 
-	buf.WriteString(fmt.Sprintf("Content-Type: %s; charset=utf-8\n", m.BodyContentType))
-	buf.WriteString(m.Body)
+	switch m.Priority {
+	case PriorityHigh:
+		writeHeader(buf, "X-Priority", "1 (Highest)")
+		writeHeader(buf, "Importance", "High")
+		writeHeader(buf, "X-MSMail-Priority", "High")
+	case PriorityLow:
+		writeHeader(buf, "X-Priority", "5 (Lowest)")
+		writeHeader(buf, "Importance", "Low")
+		writeHeader(buf, "X-MSMail-Priority", "Low")
+	}
 
-	if len(m.Attachments) > 0 {
-		for _, attachment := range m.Attachments {
-			buf.WriteString("\n\n--" + boundary + "\n")
+	m.Headers.writeTo(buf, reservedHeaders)
+	buf.WriteString("MIME-Version: 1.0\r\n")
 
-			if attachment.Inline {
-				buf.WriteString("Content-Type: message/rfc822\n")
-				buf.WriteString("Content-Disposition: inline; filename=\"" + attachment.Filename + "\"\n\n")
+	if (m.Signer == nil && m.Encrypter == nil) || buf.err != nil {
+		m.writeEntity(buf)
+		return buf.err
+	}
 
-				buf.Write(attachment.Data)
-			} else {
-				buf.WriteString("Content-Type: application/octet-stream\n")
-				buf.WriteString("Content-Transfer-Encoding: base64\n")
-				buf.WriteString("Content-Disposition: attachment; filename=\"" + attachment.Filename + "\"\n\n")
+	entity, err := m.protectedEntity()
+	if err != nil {
+		return err
+	}
 
-				b := make([]byte, base64.StdEncoding.EncodedLen(len(attachment.Data)))
-				base64.StdEncoding.Encode(b, attachment.Data)
-				buf.Write(b)
-			}
+	buf.Write(entity)
 
-			buf.WriteString("\n--" + boundary)
+	return buf.err
+}
+
+// protectedEntity returns the MIME entity of the message signed and
+// encrypted with the Signer and Encrypter of the message.
+func (m *Message) protectedEntity() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	m.writeEntity(&writer{w: buf})
+
+	entity := buf.Bytes()
+
+	var err error
+	if m.Signer != nil {
+		if entity, err = m.Signer.Sign(entity); err != nil {
+			return nil, err
 		}
+	}
 
-		buf.WriteString("--")
+	if m.Encrypter != nil {
+		if entity, err = m.Encrypter.Encrypt(entity); err != nil {
+			return nil, err
+		}
 	}
 
-	return buf.Bytes()
+	return entity, nil
 }
 
-func Send(addr string, auth smtp.Auth, m *Message) error {
-	from, err := mail.ParseAddress(m.From)
+// writeEntity writes the MIME entity of the message: the Content-Type and
+// related headers followed by the body and attachments.
+func (m *Message) writeEntity(buf *writer) {
+	mixed, related := m.splitAttachments()
+	if m.Zip != nil {
+		mixed = m.Zip.bundle(mixed, m.date())
+	}
+
+	boundary := randomBoundary()
+
+	if len(mixed) > 0 {
+		buf.WriteString("Content-Type: multipart/mixed; boundary=" + boundary + "\r\n\r\n")
+		buf.WriteString("--" + boundary + "\r\n")
+	}
+
+	m.writeBody(buf, related)
+
+	if len(mixed) > 0 {
+		for _, attachment := range mixed {
+			buf.WriteString("\r\n--" + boundary + "\r\n")
+			writeAttachment(buf, attachment)
+		}
+
+		buf.WriteString("\r\n--" + boundary + "--")
+	}
+}
+
+// date returns the time to write in the Date header.
+func (m *Message) date() time.Time {
+	if !m.Date.IsZero() {
+		return m.Date
+	}
+
+	if m.Clock != nil {
+		return m.Clock()
+	}
+
+	return time.Now()
+}
+
+// maxParamSection is the length of each section of a long RFC 2231
+// parameter, which keeps the lines of the header short.
+const maxParamSection = 60
+
+// filenameParams returns the filename parameter of a Content-Disposition
+// field. Non-ASCII names are written as RFC 2231 describes, in sections
+// if long, after a quoted RFC 2047 word for the clients that only read
+// the plain parameter.
+func filenameParams(filename string) string {
+	if isASCII(filename) {
+		return `filename="` + escapeQuotes(filename) + `"`
+	}
+
+	params := []string{`filename="` + escapeQuotes(encodeWord(filename)) + `"`}
+
+	value := "utf-8''" + percentEncode(filename)
+	if len(value) <= maxParamSection {
+		return strings.Join(append(params, "filename*="+value), "; ")
+	}
+
+	for i := 0; value != ""; i++ {
+		n := maxParamSection
+		if n >= len(value) {
+			n = len(value)
+		} else if j := strings.LastIndexByte(value[n-2:n], '%'); j != -1 {
+			// Don't split a percent-encoded octet.
+			n -= 2 - j
+		}
+
+		params = append(params, "filename*"+strconv.Itoa(i)+"*="+value[:n])
+		value = value[n:]
+	}
+
+	return strings.Join(params, "; ")
+}
+
+// percentEncode encodes s as an RFC 2231 value, escaping the octets that
+// aren't attribute characters.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) != -1) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// randomToken returns n random bytes encoded as hex.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// randomBoundary returns a multipart boundary that can't be predicted from
+// or found in the content. Like mime/multipart it panics if the system's
+// random source fails.
+func randomBoundary() string {
+	token, err := randomToken(15)
 	if err != nil {
-		return err
+		panic(err)
 	}
 
-	return smtp.SendMail(addr, auth, from.Address, m.Tolist(), m.Bytes())
+	return token
 }
 
-func SendUnencrypted(addr, user, password string, m *Message) error {
-	from, err := mail.ParseAddress(m.From)
+// domain returns the domain of the sender, falling back to the local
+// hostname if From has no usable domain.
+func (m *Message) domain() string {
+	if from, err := mail.ParseAddress(idnaAddress(m.From.Email)); err == nil {
+		if i := strings.LastIndex(from.Address, "@"); i != -1 {
+			return from.Address[i+1:]
+		}
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+
+	return "localhost"
+}
+
+// withMessageID returns m, or a copy of it with a new MessageID if it has
+// none, so that serializing it doesn't change m.
+func (m *Message) withMessageID() *Message {
+	if m.MessageID != "" {
+		return m
+	}
+
+	c := *m
+	c.MessageID = m.generateMessageID()
+
+	return &c
+}
+
+// generateMessageID returns a unique Message-ID in the domain of the sender.
+func (m *Message) generateMessageID() string {
+	token, err := randomToken(16)
 	if err != nil {
+		// crypto/rand doesn't fail on supported platforms, but keep the ID
+		// unique anyway.
+		token = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return "<" + token + "@" + m.domain() + ">"
+}
+
+// alternativeRank orders the parts of a multipart/alternative body from the
+// least to the most faithful representation.
+func alternativeRank(contentType string) int {
+	switch mediaType(contentType) {
+	case "text/plain":
+		return 0
+	case "text/x-amp-html":
+		// Gmail only shows the AMP part if it comes before the HTML one.
+		return 1
+	case "text/html":
+		return 3
+	case "text/calendar":
+		return 4
+	default:
+		return 2
+	}
+}
+
+// bodyParts returns the body and its alternatives in emission order.
+func (m *Message) bodyParts() []*Alternative {
+	parts := []*Alternative{m.body()}
+	parts = append(parts, m.Alternatives...)
+
+	sort.SliceStable(parts, func(i, j int) bool {
+		return alternativeRank(parts[i].ContentType) < alternativeRank(parts[j].ContentType)
+	})
+
+	return parts
+}
+
+// body returns the body as a part, like the alternatives.
+func (m *Message) body() *Alternative {
+	return &Alternative{ContentType: m.BodyContentType, Body: m.Body, TransferEncoding: m.BodyTransferEncoding}
+}
+
+// splitAttachments separates regular attachments from the embedded ones that
+// are referenced from the body by Content-ID.
+func (m *Message) splitAttachments() (mixed, related []*Attachment) {
+	for _, attachment := range m.Attachments {
+		if attachment.ContentID != "" {
+			related = append(related, attachment)
+		} else {
+			mixed = append(mixed, attachment)
+		}
+	}
+
+	return mixed, related
+}
+
+// writeRelated writes a body part and, if there are any, the embedded
+// attachments it references grouped with it in a multipart/related part.
+func (m *Message) writeRelated(buf *writer, part *Alternative, related []*Attachment) {
+	if len(related) == 0 {
+		m.writeText(buf, part)
+		return
+	}
+
+	boundary := randomBoundary()
+
+	buf.WriteString("Content-Type: multipart/related; boundary=" + boundary + "; type=\"" + mediaType(part.ContentType) + "\"\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+
+	m.writeText(buf, part)
+
+	for _, attachment := range related {
+		buf.WriteString("\r\n--" + boundary + "\r\n")
+		writeAttachment(buf, attachment)
+	}
+
+	buf.WriteString("\r\n--" + boundary + "--")
+}
+
+func writeAttachment(buf *writer, attachment *Attachment) {
+	contentType := attachment.contentType()
+	message := mediaType(contentType) == "message/rfc822"
+
+	// A message/rfc822 part can't be base64 encoded, so the message is
+	// sent as is.
+	encoding := attachment.TransferEncoding
+	switch {
+	case encoding != "":
+	case !message:
+		encoding = Base64
+	case isASCII(string(attachment.Data)):
+		encoding = SevenBit
+	default:
+		encoding = EightBit
+	}
+
+	if err := encoding.check(attachment.Data); err != nil {
+		buf.fail(fmt.Errorf("attachment %q: %v", attachment.Filename, err))
+		return
+	}
+	if message && encoding != SevenBit && encoding != EightBit {
+		buf.fail(fmt.Errorf("attachment %q: message/rfc822 can't be sent as %s", attachment.Filename, encoding))
+		return
+	}
+	if encoding == EightBit && buf.smtp && !buf.eightBit {
+		buf.fail(fmt.Errorf("attachment %q: %w", attachment.Filename, Err8BitMIMEUnsupported))
+		return
+	}
+
+	buf.WriteString("Content-Type: " + contentType + "\r\n")
+	buf.WriteString("Content-Transfer-Encoding: " + string(encoding) + "\r\n")
+
+	if attachment.ContentID != "" {
+		buf.WriteString("Content-ID: <" + attachment.ContentID + ">\r\n")
+	}
+
+	disposition := "attachment"
+	if attachment.Inline || attachment.ContentID != "" {
+		disposition = "inline"
+	}
+	writeHeader(buf, "Content-Disposition", disposition+"; "+filenameParams(attachment.Filename))
+
+	if attachment.Description != "" {
+		writeHeader(buf, "Content-Description", buf.word(attachment.Description))
+	}
+
+	attachment.Headers.writeTo(buf, reservedPartHeaders)
+	buf.WriteString("\r\n")
+
+	writeEncoded(buf, encoding, attachment.Data)
+}
+
+// writeBody writes the body and its alternatives, with the embedded
+// attachments next to the HTML part that references them:
+//
+//	multipart/alternative
+//	├── text/plain
+//	└── multipart/related
+//	    ├── text/html
+//	    └── image/png
+func (m *Message) writeBody(buf *writer, related []*Attachment) {
+	if len(m.Alternatives) == 0 {
+		m.writeRelated(buf, m.body(), related)
+		return
+	}
+
+	parts := m.bodyParts()
+
+	// Only HTML can reference the embedded attachments. Without it, they go
+	// with the most faithful part.
+	root := len(parts) - 1
+	for i, part := range parts {
+		if mediaType(part.ContentType) == "text/html" {
+			root = i
+			break
+		}
+	}
+
+	boundary := randomBoundary()
+
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + boundary + "\r\n\r\n")
+
+	for i, part := range parts {
+		buf.WriteString("--" + boundary + "\r\n")
+		if i == root {
+			m.writeRelated(buf, part, related)
+		} else {
+			m.writeText(buf, part)
+		}
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("--" + boundary + "--")
+}
+
+// render returns a body part as it is sent, with the CSS of HTML parts
+// inlined and tracking added if the message asks for it.
+func (m *Message) render(contentType, body string) string {
+	if mediaType(contentType) != "text/html" {
+		return body
+	}
+
+	if m.InlineCSS {
+		body = InlineCSS(body)
+	}
+
+	if m.Tracker != nil {
+		body = m.Tracker.track(m, body)
+	}
+
+	return body
+}
+
+// mediaType returns the media type of a Content-Type, without parameters.
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i != -1 {
+		contentType = contentType[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// writer wraps the destination of WriteTo to count the bytes written and
+// keep the first error, so serialization code doesn't check every write.
+//
+// utf8 and eightBit are set when the message is sent to a server that
+// offers the SMTPUTF8 and 8BITMIME extensions. Otherwise headers and bodies
+// are encoded to 7-bit ASCII. utf8Domains keeps internationalized domains
+// in UTF-8 instead of converting them to punycode. smtp is set when the
+// message is sent to a server, which then fails the parts that can only
+// be sent as 8bit if eightBit isn't set.
+type writer struct {
+	w   io.Writer
+	n   int64
+	err error
+
+	utf8        bool
+	utf8Domains bool
+	eightBit    bool
+	smtp        bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+
+	return n, err
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// fail stops the serialization with err, unless it already failed.
+func (w *writer) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// toCRLF converts bare LF line endings to CRLF, as required by RFC 5322.
+func toCRLF(data []byte) []byte {
+	return bytes.Replace(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+}
+
+// writeText writes a body part as it is sent, in the charset of the part
+// or else the message, as described by Message.Charset.
+func (m *Message) writeText(buf *writer, part *Alternative) {
+	charset := part.Charset
+	if charset == "" {
+		charset = m.Charset
+	}
+
+	writeText(buf, part.ContentType, charset, part.TransferEncoding, m.render(part.ContentType, part.Body))
+}
+
+// writeText writes a text part with the transfer encoding, or the one
+// chooseEncoding picks for it if empty.
+func writeText(buf *writer, contentType, charset string, encoding TransferEncoding, body string) {
+	data, err := encodeCharset(charset, body)
+	if err != nil {
+		buf.fail(err)
+		return
+	}
+
+	if encoding == "" {
+		encoding = chooseEncoding(data, buf.eightBit)
+	} else if err := encoding.check(data); err != nil {
+		buf.fail(fmt.Errorf("%s part: %v", contentType, err))
+		return
+	} else if encoding == EightBit && buf.smtp && !buf.eightBit {
+		buf.fail(fmt.Errorf("%s part: %w", contentType, Err8BitMIMEUnsupported))
+		return
+	}
+
+	if isUTF8(charset) {
+		charset = "utf-8"
+	}
+
+	buf.WriteString(fmt.Sprintf("Content-Type: %s; charset=%s\r\n", contentType, strings.ToLower(charset)))
+	buf.WriteString("Content-Transfer-Encoding: " + string(encoding) + "\r\n\r\n")
+	writeEncoded(buf, encoding, data)
+}
+
+// maxSMTPLineLength is the longest line SMTP allows, without the CRLF.
+const maxSMTPLineLength = 998
+
+// is8bit reports whether data can be sent with the 8bit transfer encoding
+// of RFC 2045: octets of any charset, without NULs, bare CRs or lines over
+// maxSMTPLineLength octets.
+func is8bit(data []byte) bool {
+	for _, line := range bytes.Split(bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1), []byte("\n")) {
+		if len(line) > maxSMTPLineLength || bytes.ContainsAny(line, "\x00\r") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// base64Lines encodes data as base64 split in lines of 76 characters.
+func base64Lines(data []byte) string {
+	var b strings.Builder
+	writeBase64(&b, data)
+
+	return b.String()
+}
+
+// validate checks the header values before connecting, and the addresses
+// that are written in the headers but not used in the SMTP envelope, which
+// would otherwise go out unchecked.
+func (m *Message) validate() error {
+	if err := m.checkHeaders(); err != nil {
 		return err
 	}
 
-	auth := UnEncryptedAuth(user, password)
+	for _, replyTo := range m.ReplyTo {
+		if _, err := mail.ParseAddress(replyTo.Email); err != nil {
+			return fmt.Errorf("invalid Reply-To address %q: %v", replyTo.Email, err)
+		}
+	}
 
-	return smtp.SendMail(addr, auth, from.Address, m.Tolist(), m.Bytes())
+	for _, receiptTo := range m.ReadReceiptTo {
+		if _, err := mail.ParseAddress(receiptTo); err != nil {
+			return fmt.Errorf("invalid read receipt address %q: %v", receiptTo, err)
+		}
+	}
+
+	return m.validateListUnsubscribe()
+}
+
+func (m *Message) validateListUnsubscribe() error {
+	oneClick := false
+
+	for _, uri := range m.ListUnsubscribe {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return fmt.Errorf("invalid List-Unsubscribe URI %q: %v", uri, err)
+		}
+
+		switch u.Scheme {
+		case "mailto":
+			if _, err := mail.ParseAddress(u.Opaque); err != nil {
+				return fmt.Errorf("invalid List-Unsubscribe URI %q: %v", uri, err)
+			}
+		case "https":
+			if u.Host == "" {
+				return fmt.Errorf("invalid List-Unsubscribe URI %q: missing host", uri)
+			}
+			oneClick = true
+		default:
+			return fmt.Errorf("invalid List-Unsubscribe URI %q: must be mailto or https", uri)
+		}
+	}
+
+	if m.ListUnsubscribeOneClick && !oneClick {
+		return errors.New("one-click List-Unsubscribe requires an https URI")
+	}
+
+	return nil
+}
+
+// envelopeFrom returns the address used in the SMTP MAIL FROM command,
+// which receives the bounces: EnvelopeFrom or Sender if set, or else From.
+func (m *Message) envelopeFrom() (string, error) {
+	from := m.From.Email
+	switch {
+	case m.EnvelopeFrom != "":
+		from = m.EnvelopeFrom
+	case m.Sender.Email != "":
+		from = m.Sender.Email
+	}
+
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", err
+	}
+
+	return address.Address, nil
+}
+
+func Send(addr string, auth smtp.Auth, m *Message) error {
+	s := &SMTPSender{Addr: addr, Auth: auth}
+	return s.Send(m)
+}
+
+// SendContext is like Send, but aborts the session if ctx is done before
+// the message is sent.
+func SendContext(ctx context.Context, addr string, auth smtp.Auth, m *Message) error {
+	s := &SMTPSender{Addr: addr, Auth: auth}
+	return s.SendContext(ctx, m)
+}
+
+// SendTLS is like Send for servers that expect TLS from the start of the
+// connection, usually on port 465, instead of STARTTLS.
+func SendTLS(addr string, auth smtp.Auth, m *Message) error {
+	s := &SMTPSender{Addr: addr, Auth: auth, ImplicitTLS: true}
+	return s.Send(m)
+}
+
+func SendUnencrypted(addr, user, password string, m *Message) error {
+	s := &SMTPSender{Addr: addr, Auth: UnEncryptedAuth(user, password)}
+	return s.Send(m)
 }
 
 type unEncryptedAuth struct {
@@ -1,16 +1,28 @@
 package email
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
 	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSend(t *testing.T) {
 	m := NewMessage("Hi", "this is the body")
-	m.From = "to@example.com"
-	m.To = []string{"to@example.com"}
-	m.Cc = []string{"to@example.com", "to@example.com"}
-	m.Bcc = []string{"to@example.com", "to@example.com"}
+	m.From = Address{Email: "to@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Cc = []Address{{Email: "to@example.com"}, {Email: "to@example.com"}}
+	m.Bcc = []Address{{Email: "to@example.com"}, {Email: "to@example.com"}}
 
 	err := m.Attach("email_test.go")
 	if err != nil {
@@ -22,3 +34,803 @@ func TestSend(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestAlternative(t *testing.T) {
+	m := NewHTMLMessage("Hi", "<p>this is the body</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AddAlternative("text/plain", "this is the body")
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "Content-Type: multipart/alternative;") {
+		t.Fatalf("expected multipart/alternative body:\n%s", data)
+	}
+
+	text := strings.Index(data, "Content-Type: text/plain")
+	html := strings.Index(data, "Content-Type: text/html")
+	if text == -1 || html == -1 || text > html {
+		t.Fatalf("expected text/plain before text/html:\n%s", data)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logo de José.png")
+	if err := os.WriteFile(file, []byte("\x89PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewHTMLMessage("Hi", "")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	cid, err := m.Embed(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Body = `<img src="cid:` + cid + `">`
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "Content-Type: multipart/related;") {
+		t.Fatalf("expected multipart/related body:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Content-ID: <"+cid+">") {
+		t.Fatalf("expected Content-ID header for %s:\n%s", cid, data)
+	}
+
+	if !strings.HasSuffix(cid, "@example.com") || strings.Contains(cid, "logo") {
+		t.Fatalf("expected a random Content-ID in the domain of the sender, got %q", cid)
+	}
+
+	if !strings.Contains(data, "Content-Type: image/png") {
+		t.Fatalf("expected image/png part:\n%s", data)
+	}
+}
+
+func TestAttachmentFilename(t *testing.T) {
+	long := strings.Repeat("informe año ", 8) + ".pdf"
+
+	for filename, expected := range map[string]string{
+		`say "hi" \ bye.txt`: `Content-Disposition: attachment; filename="say \"hi\" \\ bye.txt"`,
+		"año.pdf":            "Content-Disposition: attachment; filename=\"=?utf-8?q?a=C3=B1o.pdf?=\";\r\n filename*=utf-8''a%C3%B1o.pdf",
+		long:                 "filename*0*=utf-8''informe%20a%C3%B1o%20informe%20a%C3%B1o%20informe%20a;\r\n filename*1*=%C3%B1o%20",
+	} {
+		m := NewMessage("Hi", "this is the body")
+		m.From = Address{Email: "from@example.com"}
+		m.To = []Address{{Email: "to@example.com"}}
+		m.AttachBytes(filename, []byte("data"))
+
+		data := m.Bytes()
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %q:\n%s", expected, data)
+		}
+
+		parsed, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.Attachments[0].Filename != filename {
+			t.Fatalf("expected the filename %q to be parsed back, got %q", filename, parsed.Attachments[0].Filename)
+		}
+	}
+}
+
+func TestReplyTo(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.ReplyTo = []Address{{Email: "support@example.com"}, {Name: "Sales", Email: "sales@example.com"}}
+
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "Reply-To: support@example.com, Sales <sales@example.com>\r\n") {
+		t.Fatalf("expected Reply-To header:\n%s", data)
+	}
+
+	m.ReplyTo = []Address{{Email: "not an address"}}
+	if err := m.validate(); err == nil {
+		t.Fatal("expected an error for an invalid Reply-To")
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Name: "From", Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	messageID := func(data []byte) string {
+		parsed, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return parsed.Header.Get("Message-Id")
+	}
+
+	id := messageID(m.Bytes())
+	if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Fatalf("unexpected Message-ID %q", id)
+	}
+
+	// The generated ID isn't stored, so every send gets its own.
+	if m.MessageID != "" {
+		t.Fatalf("expected the MessageID to stay empty, got %q", m.MessageID)
+	}
+	if other := messageID(m.Bytes()); other == id {
+		t.Fatal("expected unique Message-IDs")
+	}
+
+	m.MessageID = "<custom@example.com>"
+	if data := string(m.Bytes()); !strings.Contains(data, "Message-ID: <custom@example.com>\r\n") {
+		t.Fatalf("expected custom Message-ID:\n%s", data)
+	}
+}
+
+func TestDate(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Clock = func() time.Time {
+		return time.Date(2012, 4, 5, 10, 30, 0, 0, time.UTC)
+	}
+
+	if data := string(m.Bytes()); !strings.Contains(data, "Date: Thu, 05 Apr 2012 10:30:00 +0000\r\n") {
+		t.Fatalf("expected Date from the clock:\n%s", data)
+	}
+
+	m.Date = time.Date(2013, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	if data := string(m.Bytes()); !strings.Contains(data, "Date: Wed, 02 Jan 2013 03:04:05 +0100\r\n") {
+		t.Fatalf("expected the explicit Date:\n%s", data)
+	}
+}
+
+func TestReplyHeadersFrom(t *testing.T) {
+	original := NewMessage("Hi", "this is the body")
+	original.MessageID = "<2@example.com>"
+	original.InReplyTo = "<1@example.com>"
+
+	m := NewMessage("Re: Hi", "this is the reply")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	if err := m.ReplyHeadersFrom(original); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "In-Reply-To: <2@example.com>\r\n") {
+		t.Fatalf("expected In-Reply-To header:\n%s", data)
+	}
+
+	if !strings.Contains(data, "References: <1@example.com> <2@example.com>\r\n") {
+		t.Fatalf("expected References header:\n%s", data)
+	}
+
+	if err := m.ReplyHeadersFrom(NewMessage("Hi", "")); err == nil {
+		t.Fatal("expected an error for an original without Message-ID")
+	}
+}
+
+func TestBodyEncoding(t *testing.T) {
+	m := NewMessage("Hi", "José says hi = "+strings.Repeat("long line ", 20))
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "Content-Transfer-Encoding: quoted-printable\r\n") {
+		t.Fatalf("expected a quoted-printable body:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Jos=C3=A9 says hi =3D") {
+		t.Fatalf("expected encoded body:\n%s", data)
+	}
+
+	m.Body = "日本語のテキスト"
+	data = string(m.Bytes())
+	if !strings.Contains(data, "Content-Transfer-Encoding: base64\r\n") {
+		t.Fatalf("expected a base64 body for mostly non-ASCII text:\n%s", data)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "forward.eml")
+	if err := os.WriteFile(file, []byte("Subject: forwarded\n\nline 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewHTMLMessage("Hi", "<p>line 1</p>\n<p>line 2</p>\n")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Cc = []Address{{Email: "cc@example.com"}}
+	m.Headers.Add("X-Campaign-Id", "1")
+	m.AddAlternative("text/plain", "line 1\nline 2\n")
+
+	if err := m.Attach("email_test.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	forward, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AttachRawMessage(forward); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+
+	if i := strings.Index(strings.Replace(data, "\r\n", "", -1), "\n"); i != -1 {
+		t.Fatalf("found a bare LF in:\n%q", data)
+	}
+
+	if i := strings.Index(strings.Replace(data, "\r\n", "", -1), "\r"); i != -1 {
+		t.Fatalf("found a bare CR in:\n%q", data)
+	}
+
+	if !strings.Contains(data, "\r\n\r\nline 1\r\nline 2\r\n") {
+		t.Fatalf("expected CRLF line endings in the forwarded message:\n%q", data)
+	}
+}
+
+func TestRandomBoundary(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(file, []byte("\x89PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewHTMLMessage("Hi", "<p>this is the body</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AddAlternative("text/plain", "this is the body")
+
+	if err := m.Attach("email_test.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Embed(file); err != nil {
+		t.Fatal(err)
+	}
+
+	boundaries := func(data string) []string {
+		var found []string
+		for _, line := range strings.Split(data, "\r\n") {
+			if i := strings.Index(line, "boundary="); i != -1 {
+				found = append(found, strings.SplitN(line[i+len("boundary="):], ";", 2)[0])
+			}
+		}
+		return found
+	}
+
+	first := boundaries(string(m.Bytes()))
+	if len(first) != 3 || first[0] == first[1] || first[1] == first[2] || first[0] == first[2] {
+		t.Fatalf("expected three distinct boundaries, got %q", first)
+	}
+
+	second := boundaries(string(m.Bytes()))
+	if first[0] == second[0] {
+		t.Fatal("expected a new boundary for each serialization")
+	}
+}
+
+func TestAttachmentContentType(t *testing.T) {
+	for _, test := range []struct {
+		attachment  *Attachment
+		contentType string
+	}{
+		{&Attachment{Filename: "report.pdf"}, "application/pdf"},
+		{&Attachment{Filename: "picture", Data: []byte("\x89PNG\x0D\x0A\x1A\x0A")}, "image/png"},
+		{&Attachment{Filename: "data.bin", Data: []byte("a"), ContentType: "text/csv"}, "text/csv"},
+	} {
+		if contentType := test.attachment.contentType(); contentType != test.contentType {
+			t.Errorf("%s: expected %s, got %s", test.attachment.Filename, test.contentType, contentType)
+		}
+	}
+}
+
+func TestAttachReaderAndBytes(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+
+	if err := m.AttachReader("report.csv", strings.NewReader("a,b\n1,2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.AttachBytes("data.bin", []byte{1, 2, 3})
+	m.InlineBytes("note.eml", []byte("Subject: note\n\nhello\n"))
+
+	if a := m.Attachment("report.csv"); a == nil || string(a.Data) != "a,b\n1,2\n" || a.Inline {
+		t.Fatalf("unexpected report.csv attachment %+v", a)
+	}
+
+	if a := m.Attachment("data.bin"); a == nil || len(a.Data) != 3 {
+		t.Fatalf("unexpected data.bin attachment %+v", a)
+	}
+
+	if a := m.Attachment("note.eml"); a == nil || !a.Inline {
+		t.Fatalf("unexpected note.eml attachment %+v", a)
+	}
+}
+
+func TestAttachOptions(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	err := m.Attach("email_test.go",
+		WithFilename("source.txt"),
+		WithContentType("text/x-go"),
+		WithDescription("Test source"),
+		WithHeader("X-Attachment-Id", "42"),
+		WithHeader("Content-Type", "ignored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+
+	for _, line := range []string{
+		"Content-Type: text/x-go\r\n",
+		"Content-Disposition: attachment; filename=\"source.txt\"\r\n",
+		"Content-Description: Test source\r\n",
+		"X-Attachment-Id: 42\r\n",
+	} {
+		if !strings.Contains(data, line) {
+			t.Fatalf("expected %q in:\n%s", line, data)
+		}
+	}
+
+	if strings.Contains(data, "ignored") {
+		t.Fatalf("expected the reserved part header to be ignored:\n%s", data)
+	}
+}
+
+func TestAttachmentOrder(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	m.AttachBytes("report.pdf", []byte("first"))
+	m.AttachBytes("summary.txt", []byte("second"))
+	m.AttachBytes("report.pdf", []byte("third"))
+
+	if len(m.Attachments) != 3 {
+		t.Fatalf("expected 3 attachments, got %d", len(m.Attachments))
+	}
+
+	if a := m.Attachment("report.pdf"); string(a.Data) != "first" {
+		t.Fatalf("expected the first report.pdf, got %q", a.Data)
+	}
+
+	data := string(m.Bytes())
+
+	first := strings.Index(data, base64.StdEncoding.EncodeToString([]byte("first")))
+	second := strings.Index(data, base64.StdEncoding.EncodeToString([]byte("second")))
+	third := strings.Index(data, base64.StdEncoding.EncodeToString([]byte("third")))
+	if first == -1 || second == -1 || third == -1 || first > second || second > third {
+		t.Fatalf("expected the attachments in order:\n%s", data)
+	}
+}
+
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("write failed")
+	}
+
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AttachBytes("data.bin", bytes.Repeat([]byte{0xff}, 10000))
+
+	buf := bytes.NewBuffer(nil)
+	n, err := m.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", buf.Len(), n)
+	}
+
+	n, err = m.WriteTo(&failingWriter{limit: 100})
+	if err == nil {
+		t.Fatal("expected the write error")
+	}
+
+	if n != 100 {
+		t.Fatalf("expected 100 bytes written, got %d", n)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	if data := string(m.Bytes()); strings.Contains(data, "Importance") {
+		t.Fatalf("expected no priority headers for a normal message:\n%s", data)
+	}
+
+	m.Priority = PriorityHigh
+	data := string(m.Bytes())
+
+	for _, line := range []string{
+		"X-Priority: 1 (Highest)\r\n",
+		"Importance: High\r\n",
+		"X-MSMail-Priority: High\r\n",
+	} {
+		if !strings.Contains(data, line) {
+			t.Fatalf("expected %q in:\n%s", line, data)
+		}
+	}
+}
+
+func TestListUnsubscribe(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.ListUnsubscribe = []string{"mailto:unsubscribe@example.com?subject=unsubscribe", "https://example.com/unsubscribe/abc"}
+	m.ListUnsubscribeOneClick = true
+
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "List-Unsubscribe: <mailto:unsubscribe@example.com?subject=unsubscribe>,\r\n <https://example.com/unsubscribe/abc>\r\n") {
+		t.Fatalf("expected List-Unsubscribe header:\n%s", data)
+	}
+
+	if !strings.Contains(data, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n") {
+		t.Fatalf("expected List-Unsubscribe-Post header:\n%s", data)
+	}
+
+	for _, test := range []struct {
+		uris     []string
+		oneClick bool
+	}{
+		{[]string{"http://example.com/unsubscribe"}, false},
+		{[]string{"mailto:not an address"}, false},
+		{[]string{"https:///unsubscribe"}, false},
+		{[]string{"mailto:unsubscribe@example.com"}, true},
+	} {
+		m.ListUnsubscribe = test.uris
+		m.ListUnsubscribeOneClick = test.oneClick
+
+		if err := m.validate(); err == nil {
+			t.Errorf("expected an error for %q (one-click %v)", test.uris, test.oneClick)
+		}
+	}
+}
+
+func TestSender(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	if from, err := m.envelopeFrom(); err != nil || from != "alice@example.com" {
+		t.Fatalf("expected From as envelope sender, got %q, %v", from, err)
+	}
+
+	m.Sender = Address{Name: "System", Email: "noreply@example.com"}
+
+	if from, err := m.envelopeFrom(); err != nil || from != "noreply@example.com" {
+		t.Fatalf("expected Sender as envelope sender, got %q, %v", from, err)
+	}
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "From: Alice <alice@example.com>\r\nSender: System <noreply@example.com>\r\n") {
+		t.Fatalf("expected From and Sender headers:\n%s", data)
+	}
+}
+
+func TestEnvelopeFrom(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.Sender = Address{Email: "noreply@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.EnvelopeFrom = "bounces+to=example.com@example.com"
+
+	if from, err := m.envelopeFrom(); err != nil || from != m.EnvelopeFrom {
+		t.Fatalf("expected EnvelopeFrom as envelope sender, got %q, %v", from, err)
+	}
+
+	if data := string(m.Bytes()); strings.Contains(data, "bounces") {
+		t.Fatalf("expected EnvelopeFrom not to be in the headers:\n%s", data)
+	}
+
+	m.EnvelopeFrom = "not an address"
+	if _, err := m.envelopeFrom(); err == nil {
+		t.Fatal("expected an error for an invalid EnvelopeFrom")
+	}
+}
+
+func TestAMP(t *testing.T) {
+	m := NewHTMLMessage("Hi", "<p>this is the body</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AddAMP("<!doctype html><html amp4email><body>this is the body</body></html>")
+	m.AddAlternative("text/plain", "this is the body")
+
+	data := string(m.Bytes())
+
+	text := strings.Index(data, "Content-Type: text/plain")
+	amp := strings.Index(data, "Content-Type: text/x-amp-html")
+	html := strings.Index(data, "Content-Type: text/html")
+	if text == -1 || amp == -1 || html == -1 || text > amp || amp > html {
+		t.Fatalf("expected text, AMP and HTML alternatives in order:\n%s", data)
+	}
+}
+
+func TestSMTPUTF8(t *testing.T) {
+	m := NewMessage("Olá", "José says hi")
+	m.From = Address{Name: "José", Email: "jose@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	if m.hasUTF8Addresses("jose@example.com", m.Tolist(), false) {
+		t.Fatal("expected non-ASCII display names not to require SMTPUTF8")
+	}
+
+	m.To = []Address{{Email: "用户@例子.广告"}}
+	if !m.hasUTF8Addresses("jose@example.com", m.Tolist(), false) {
+		t.Fatal("expected a non-ASCII recipient to require SMTPUTF8")
+	}
+
+	data := string(m.Bytes())
+	if !strings.Contains(data, "Subject: =?utf-8?q?Ol=C3=A1?=\r\n") {
+		t.Fatalf("expected an encoded subject without SMTPUTF8:\n%s", data)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := m.write(&writer{w: buf, utf8: true, utf8Domains: true, eightBit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	data = buf.String()
+	for _, expected := range []string{
+		"Subject: Olá\r\n",
+		"From: José <jose@example.com>\r\n",
+		"To: 用户@例子.广告\r\n",
+		"Content-Transfer-Encoding: 8bit\r\n\r\nJosé says hi",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %q with SMTPUTF8 and 8BITMIME:\n%s", expected, data)
+		}
+	}
+
+	m.Body = strings.Repeat("ã", maxSMTPLineLength)
+	buf.Reset()
+	m.write(&writer{w: buf, eightBit: true})
+	if strings.Contains(buf.String(), "8bit") {
+		t.Fatalf("expected lines over the SMTP limit to be encoded:\n%s", buf.String())
+	}
+}
+
+func TestAttachURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/invoices/42":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="invoice-42.pdf"`)
+			w.Write([]byte("%PDF-1.4"))
+		case "/report.csv":
+			w.Write([]byte("a,b\n1,2\n"))
+		case "/large":
+			w.Write(bytes.Repeat([]byte("x"), 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	m := NewMessage("Hi", "this is the body")
+	ctx := context.Background()
+
+	if err := m.AttachURL(ctx, server.URL+"/invoices/42"); err != nil {
+		t.Fatal(err)
+	}
+
+	if a := m.Attachment("invoice-42.pdf"); a == nil || a.ContentType != "application/pdf" || string(a.Data) != "%PDF-1.4" {
+		t.Fatalf("unexpected attachment: %+v", m.Attachments[0])
+	}
+
+	if err := m.AttachURL(ctx, server.URL+"/report.csv", WithContentType("text/csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	if a := m.Attachment("report.csv"); a == nil || a.ContentType != "text/csv" {
+		t.Fatalf("expected the options to override the response, got %+v", a)
+	}
+
+	if err := m.AttachURL(ctx, server.URL+"/missing"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	defer func(size int64) { AttachURLMaxSize = size }(AttachURLMaxSize)
+	AttachURLMaxSize = 99
+	if err := m.AttachURL(ctx, server.URL+"/large"); err != ErrAttachmentTooLarge {
+		t.Fatalf("expected ErrAttachmentTooLarge, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := m.AttachURL(canceled, server.URL+"/report.csv"); err == nil {
+		t.Fatal("expected an error for a canceled context")
+	}
+
+	if len(m.Attachments) != 2 {
+		t.Fatalf("expected only the downloaded attachments, got %d", len(m.Attachments))
+	}
+}
+
+func TestEstimatedSize(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AttachBytes("data.bin", bytes.Repeat([]byte{0xff}, 3000))
+
+	size := m.EstimatedSize()
+	if size < 4000 {
+		t.Fatalf("expected the size to include the base64 overhead, got %d", size)
+	}
+
+	if actual := int64(len(m.Bytes())); actual != size {
+		t.Fatalf("expected the size of the serialized message %d, got %d", actual, size)
+	}
+
+	s := &SMTPSender{Addr: "127.0.0.1:1", MaxSize: 4000}
+	if err := s.Send(m); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge before connecting, got %v", err)
+	}
+}
+
+func TestAttachMessage(t *testing.T) {
+	original := NewMessage("Re: Q3 report?", "Olá, here it is")
+	original.From = Address{Email: "alice@example.com"}
+	original.To = []Address{{Email: "bob@example.com"}}
+
+	m := NewMessage("Fwd: Q3 report?", "see the original message")
+	m.From = Address{Email: "bob@example.com"}
+	m.To = []Address{{Email: "carol@example.com"}}
+
+	if err := m.AttachMessage(original); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AttachRawMessage([]byte("not a message")); err == nil {
+		t.Fatal("expected an error for an invalid message")
+	}
+
+	m.InlineBytes("logo.png", []byte("\x89PNG"))
+
+	data := string(m.Bytes())
+	for _, expected := range []string{
+		"Content-Type: message/rfc822\r\nContent-Transfer-Encoding: 7bit\r\nContent-Disposition: attachment; filename=\"Re_ Q3 report_.eml\"\r\n\r\nFrom: alice@example.com\r\n",
+		"Content-Type: image/png\r\nContent-Transfer-Encoding: base64\r\nContent-Disposition: inline; filename=\"logo.png\"\r\n",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %q:\n%s", expected, data)
+		}
+	}
+}
+
+func TestAttachMessage8bit(t *testing.T) {
+	m := NewMessage("Fwd: Q3 report?", "see the original message")
+	m.From = Address{Email: "bob@example.com"}
+	m.To = []Address{{Email: "carol@example.com"}}
+	if err := m.AttachRawMessage([]byte("From: alice@example.com\r\n\r\nOlá, here it is")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := m.write(&writer{w: buf, smtp: true, eightBit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Content-Type: message/rfc822\r\nContent-Transfer-Encoding: 8bit\r\n") {
+		t.Fatalf("expected an 8bit attachment:\n%s", buf)
+	}
+
+	if _, err := m.write(&writer{w: io.Discard, smtp: true}); !errors.Is(err, Err8BitMIMEUnsupported) {
+		t.Fatalf("expected Err8BitMIMEUnsupported, got %v", err)
+	}
+
+	m.Attachments[0].Data = []byte("From: alice@example.com\r\n\r\n" + strings.Repeat("a", 1000))
+	if _, err := m.WriteTo(io.Discard); err == nil {
+		t.Fatal("expected an error for a line longer than 998 octets")
+	}
+}
+
+func TestNesting(t *testing.T) {
+	m := NewHTMLMessage("Hi", `<img src="cid:logo">`)
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AddAlternative("text/plain", "this is the body")
+	m.AttachBytes("report.csv", []byte("a,b\n"))
+	m.AttachBytes("logo.png", []byte("\x89PNG"))
+	m.Attachment("logo.png").ContentID = "logo"
+
+	data := string(m.Bytes())
+
+	var types []string
+	for _, line := range strings.Split(data, "\r\n") {
+		if strings.HasPrefix(line, "Content-Type: ") {
+			types = append(types, strings.SplitN(strings.TrimPrefix(line, "Content-Type: "), ";", 2)[0])
+		}
+	}
+
+	expected := []string{"multipart/mixed", "multipart/alternative", "text/plain", "multipart/related", "text/html", "image/png", "text/csv"}
+	if strings.Join(types, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected the parts %v, got %v:\n%s", expected, types, data)
+	}
+
+	if !strings.Contains(data, `type="text/html"`) {
+		t.Fatalf("expected the type of the related root:\n%s", data)
+	}
+}
+
+func TestAddRecipients(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+
+	if err := m.AddTo("Tom <tom@example.com>", "jerry@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.AddCc("cc@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.AddBcc("bcc@example.com", "not an address")
+	if err == nil || !strings.Contains(err.Error(), `invalid Bcc address "not an address"`) {
+		t.Fatalf("expected an invalid Bcc address error, got %v", err)
+	}
+
+	if len(m.To) != 2 || len(m.Cc) != 1 || len(m.Bcc) != 0 {
+		t.Fatalf("unexpected recipients: %q %q %q", m.To, m.Cc, m.Bcc)
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := NewHTMLMessage("Hi", "<p>Hi</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "base@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.AttachBytes("report.txt", []byte("numbers"), WithHeader("X-Part", "1"))
+	m.Headers.Add("X-Campaign", "q3")
+	m.DSN = &DSN{Notify: NotifyFailure}
+
+	c := m.Clone()
+	c.To[0] = Address{Email: "alice@example.com"}
+	c.Subject = "Hi Alice"
+	c.Alternatives[0].Body = "Hi Alice"
+	c.Attachments[0].Filename = "alice.txt"
+	c.Attachments[0].Headers.Set("X-Part", "2")
+	c.Headers.Set("X-Campaign", "q4")
+	c.DSN.Notify = NotifySuccess
+
+	if m.To[0].Email != "base@example.com" || m.Subject != "Hi" || m.Alternatives[0].Body != "Hi" ||
+		m.Attachments[0].Filename != "report.txt" || m.Attachments[0].Headers.Get("X-Part") != "1" ||
+		m.Headers.Get("X-Campaign") != "q3" || m.DSN.Notify != NotifyFailure {
+		t.Fatalf("expected the original to be unchanged: %+v", m)
+	}
+
+	if &c.Attachments[0].Data[0] != &m.Attachments[0].Data[0] {
+		t.Fatal("expected the attachment data to be shared")
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+
+// Package emailtest provides an in-process SMTP server for the tests of
+// code that sends messages, like net/http/httptest does for HTTP:
+//
+//	server := emailtest.NewServer()
+//	defer server.Close()
+//
+//	sender := &email.SMTPSender{Addr: server.Addr}
+//	...
+//	messages := server.Messages()
+//
+// It records the envelope and the data of each message, and can be made to
+// offer STARTTLS, to require authentication, or to fail commands with the
+// replies of a real server.
+package emailtest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message that the server accepted.
+type Message struct {
+	// From and To are the addresses of the MAIL FROM and RCPT TO
+	// commands, without the angle brackets.
+	From string
+	To   []string
+
+	// Data is the message as it was sent, with CRLF line endings and
+	// without the dot stuffing.
+	Data []byte
+}
+
+// Server is an SMTP server that listens on a loopback address.
+type Server struct {
+	// Addr is the address of the server, like "127.0.0.1:54321".
+	Addr string
+
+	// TLSConfig, if set before the server is started, makes it offer
+	// STARTTLS.
+	TLSConfig *tls.Config
+
+	// Users, if set before the server is started, are the usernames and
+	// passwords that the server accepts with AUTH PLAIN or LOGIN, and it
+	// requires that clients authenticate before sending.
+	Users map[string]string
+
+	listener    net.Listener
+	certificate *x509.Certificate
+	wg          sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	closed   bool
+	replies  []*reply
+	commands []string
+	messages []Message
+}
+
+type reply struct {
+	prefix, line string
+
+	// n is the number of commands left to answer, or 0 for all of them.
+	n int
+}
+
+// NewServer returns a server that has been started.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+
+	return s
+}
+
+// NewUnstartedServer returns a server that listens but doesn't answer until
+// it is started, so that it can be configured.
+func NewUnstartedServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("emailtest: failed to listen: %v", err))
+	}
+
+	return &Server{Addr: l.Addr().String(), listener: l, conns: make(map[net.Conn]bool)}
+}
+
+// Start starts the server.
+func (s *Server) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.conns[conn] = true
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+}
+
+// StartTLS starts the server offering STARTTLS, with a TLSConfig that has
+// a self-signed certificate for 127.0.0.1 if it doesn't have one. See
+// Certificate.
+func (s *Server) StartTLS() {
+	if s.TLSConfig == nil {
+		s.TLSConfig = &tls.Config{}
+	}
+
+	if len(s.TLSConfig.Certificates) == 0 {
+		cert := newCertificate()
+		s.TLSConfig.Certificates = []tls.Certificate{cert}
+		s.certificate = cert.Leaf
+	}
+
+	s.Start()
+}
+
+// Certificate returns the certificate that StartTLS generated, or nil. To
+// trust it:
+//
+//	pool := x509.NewCertPool()
+//	pool.AddCert(server.Certificate())
+//	sender.TLSConfig = &tls.Config{RootCAs: pool}
+func (s *Server) Certificate() *x509.Certificate {
+	return s.certificate
+}
+
+// Close stops the server, closing its connections, and waits for them to
+// end.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Reply makes the server answer the commands that begin with prefix,
+// without case, like "RCPT TO:<bad@", with the line, like
+// "550 5.1.1 No such user", instead of handling them. It answers the next n
+// of them, or all of them if n is 0, so that a temporary failure like
+// "451 4.3.0 Try again later" can be followed by a success.
+func (s *Server) Reply(prefix, line string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies = append(s.replies, &reply{prefix: strings.ToUpper(prefix), line: line, n: n})
+}
+
+// Messages returns the messages that the server accepted, in order.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Commands returns the lines that clients sent, except for the data of
+// the messages, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// Reset forgets the messages and commands, and the replies set with Reply.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies, s.commands, s.messages = nil, nil, nil
+}
+
+// record records a line of a client and returns the reply set for it with
+// Reply, if any.
+func (s *Server) record(line string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = append(s.commands, line)
+
+	upper := strings.ToUpper(line)
+	for i, r := range s.replies {
+		if !strings.HasPrefix(upper, r.prefix) {
+			continue
+		}
+
+		if r.n > 0 {
+			if r.n--; r.n == 0 {
+				s.replies = append(s.replies[:i:i], s.replies[i+1:]...)
+			}
+		}
+
+		return r.line, true
+	}
+
+	return "", false
+}
+
+// session is the state of a connection.
+type session struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	tls    bool
+	authed bool
+	from   string
+	to     []string
+	mail   bool
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	c := &session{conn: conn, r: bufio.NewReader(conn)}
+	defer func() { c.conn.Close() }()
+
+	c.reply("220 emailtest ESMTP ready")
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+
+		if forced, ok := s.record(line); ok {
+			c.reply(forced)
+			continue
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			c.reset()
+			extensions := []string{"250-emailtest", "250-PIPELINING", "250-8BITMIME", "250-SMTPUTF8"}
+			if s.TLSConfig != nil && !c.tls {
+				extensions = append(extensions, "250-STARTTLS")
+			}
+			if s.Users != nil {
+				extensions = append(extensions, "250-AUTH PLAIN LOGIN")
+			}
+			c.reply(strings.Join(extensions, "\r\n") + "\r\n250 HELP")
+		case "HELO":
+			c.reset()
+			c.reply("250 emailtest")
+		case "STARTTLS":
+			if s.TLSConfig == nil || c.tls {
+				c.reply("502 5.5.1 STARTTLS not available")
+				continue
+			}
+			c.reply("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(c.conn, s.TLSConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			c.conn, c.r, c.tls = tlsConn, bufio.NewReader(tlsConn), true
+			c.reset()
+		case "AUTH":
+			if !s.auth(c, arg) {
+				return
+			}
+		case "MAIL":
+			if s.Users != nil && !c.authed {
+				c.reply("530 5.7.0 Authentication required")
+				continue
+			}
+			c.reset()
+			c.from, c.mail = path(arg, "FROM:"), true
+			c.reply("250 2.1.0 OK")
+		case "RCPT":
+			if !c.mail {
+				c.reply("503 5.5.1 MAIL first")
+				continue
+			}
+			c.to = append(c.to, path(arg, "TO:"))
+			c.reply("250 2.1.5 OK")
+		case "DATA":
+			if len(c.to) == 0 {
+				c.reply("503 5.5.1 RCPT first")
+				continue
+			}
+			c.reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := c.readData()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, Message{From: c.from, To: c.to, Data: data})
+			s.mu.Unlock()
+			c.reset()
+			c.reply("250 2.0.0 Queued")
+		case "RSET":
+			c.reset()
+			c.reply("250 2.0.0 OK")
+		case "NOOP":
+			c.reply("250 2.0.0 OK")
+		case "QUIT":
+			c.reply("221 2.0.0 Bye")
+			return
+		default:
+			c.reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// auth authenticates the client with the PLAIN or LOGIN mechanism. It
+// returns false if the connection failed.
+func (s *Server) auth(c *session, arg string) bool {
+	fields := strings.Fields(arg)
+	if s.Users == nil || len(fields) == 0 || c.authed {
+		c.reply("503 5.5.1 AUTH not available")
+		return true
+	}
+
+	// The responses of the client after the initial one.
+	next := func(challenge string) (string, bool) {
+		c.reply("334 " + challenge)
+		line, err := c.readLine()
+		if err != nil {
+			return "", false
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		return line, true
+	}
+
+	var username, password string
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		response := ""
+		if len(fields) > 1 {
+			response = fields[1]
+		} else {
+			var ok bool
+			if response, ok = next(""); !ok {
+				return false
+			}
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(response)
+		if parts := strings.Split(string(decoded), "\x00"); len(parts) == 3 {
+			username, password = parts[1], parts[2]
+		}
+	case "LOGIN":
+		for _, field := range []*string{&username, &password} {
+			challenge := "VXNlcm5hbWU6" // Username:
+			if field == &password {
+				challenge = "UGFzc3dvcmQ6" // Password:
+			}
+			response, ok := next(challenge)
+			if !ok {
+				return false
+			}
+			decoded, _ := base64.StdEncoding.DecodeString(response)
+			*field = string(decoded)
+		}
+	default:
+		c.reply("504 5.5.4 Unrecognized authentication type")
+		return true
+	}
+
+	if expected, ok := s.Users[username]; !ok || expected != password {
+		c.reply("535 5.7.8 Authentication credentials invalid")
+		return true
+	}
+
+	c.authed = true
+	c.reply("235 2.7.0 Authentication successful")
+
+	return true
+}
+
+func (c *session) reply(line string) {
+	c.conn.Write([]byte(line + "\r\n"))
+}
+
+func (c *session) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readData reads the data of a message, up to the line with a single dot.
+func (c *session) readData() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if line == ".\r\n" {
+			return data, nil
+		}
+
+		data = append(data, strings.TrimPrefix(line, ".")...)
+	}
+}
+
+// reset clears the envelope.
+func (c *session) reset() {
+	c.from, c.to, c.mail = "", nil, false
+}
+
+// path returns the address of a MAIL FROM or RCPT TO argument, without the
+// prefix, the angle brackets and the parameters.
+func path(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+
+	arg = strings.TrimSpace(arg)
+	if i := strings.IndexByte(arg, '>'); strings.HasPrefix(arg, "<") && i > 0 {
+		return arg[1:i]
+	}
+
+	return strings.Fields(arg + " ")[0]
+}
+
+// newCertificate returns a self-signed certificate for 127.0.0.1.
+func newCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("emailtest: failed to generate key: %v", err))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("emailtest: failed to create certificate: %v", err))
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("emailtest: failed to parse certificate: %v", err))
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
@@ -0,0 +1,105 @@
+package emailtest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scorredoira/email"
+)
+
+func testMessage() *email.Message {
+	m := email.NewMessage("Hi", "this is the body\n.hidden")
+	m.From = email.Address{Email: "from@example.com"}
+	m.To = []email.Address{{Email: "to@example.com"}}
+	m.Bcc = []email.Address{{Email: "bcc@example.com"}}
+
+	return m
+}
+
+func TestServer(t *testing.T) {
+	server := NewUnstartedServer()
+	server.Users = map[string]string{"alice": "secret"}
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	s := &email.SMTPSender{
+		Addr:      server.Addr,
+		Auth:      smtp.PlainAuth("", "alice", "secret", "127.0.0.1"),
+		StartTLS:  email.StartTLSRequired,
+		TLSConfig: &tls.Config{RootCAs: pool},
+	}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected a message, got %d", len(messages))
+	}
+
+	m := messages[0]
+	if m.From != "from@example.com" || strings.Join(m.To, " ") != "to@example.com bcc@example.com" {
+		t.Fatalf("unexpected envelope: %s %v", m.From, m.To)
+	}
+
+	if !strings.Contains(string(m.Data), "\r\n.hidden") || !strings.HasSuffix(string(m.Data), "\r\n") {
+		t.Fatalf("unexpected data:\n%s", m.Data)
+	}
+
+	s.Auth = email.LoginAuth("alice", "wrong")
+	var protocolErr *textproto.Error
+	if err := s.Send(testMessage()); !errors.As(err, &protocolErr) || protocolErr.Code != 535 {
+		t.Fatalf("expected the credentials to be rejected, got %v", err)
+	}
+
+	s.Auth = nil
+	if err := s.Send(testMessage()); !errors.As(err, &protocolErr) || protocolErr.Code != 530 {
+		t.Fatalf("expected authentication to be required, got %v", err)
+	}
+}
+
+func TestReply(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.Reply("RCPT TO:<bcc@", "550 5.1.1 No such user", 0)
+	server.Reply("MAIL FROM:", "451 4.3.0 Try again later", 1)
+
+	s := &email.RetrySender{
+		Sender:   &email.SMTPSender{Addr: server.Addr},
+		MinDelay: time.Millisecond,
+	}
+
+	m := testMessage()
+	m.Bcc = nil
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.Messages()) != 1 {
+		t.Fatal("expected the message to be sent on the second attempt")
+	}
+
+	var retryErr *email.RetryError
+	if err := s.Send(testMessage()); !errors.As(err, &retryErr) || len(retryErr.Attempts) != 1 {
+		t.Fatalf("expected a permanent failure, got %v", err)
+	}
+
+	server.Reset()
+	if len(server.Messages()) != 0 || len(server.Commands()) != 0 {
+		t.Fatal("expected the server to be reset")
+	}
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+}
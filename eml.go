@@ -0,0 +1,46 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"io"
+	"os"
+)
+
+// WriteEML writes the message to w as an .eml file, which desktop mail
+// clients open. It is the message as it is sent, with a Bcc header added
+// so that LoadEML can restore the blind copies too.
+func (m *Message) WriteEML(w io.Writer) error {
+	if err := m.checkHeaders(); err != nil {
+		return err
+	}
+
+	buf := &writer{w: w}
+	if len(m.Bcc) > 0 {
+		writeHeader(buf, "Bcc", buf.addressList(m.Bcc))
+	}
+
+	_, err := m.write(buf)
+	return err
+}
+
+// SaveEML writes the message to the file at path with WriteEML.
+func (m *Message) SaveEML(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.WriteEML(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LoadEML reads a message written by WriteEML, or another .eml file, so
+// that it can be inspected or sent again. See Parse.
+func LoadEML(r io.Reader) (*Message, error) {
+	return Parse(r)
+}
@@ -0,0 +1,112 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestEML(t *testing.T) {
+	m := NewHTMLMessage("Café report", "<p>Hola José</p><img src=\"cid:logo@example.com\">")
+	m.From = Address{Name: "José", Email: "jose@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Cc = []Address{{Name: "Carol", Email: "carol@example.com"}}
+	m.Bcc = []Address{{Email: "audit@example.com"}}
+	m.ReplyTo = []Address{{Email: "reply@example.com"}}
+	m.AddAlternative("text/plain", "Hola José\nsecond line")
+	m.AttachBytes("data.bin", []byte{0, 1, 2, 0xff}, WithDescription("Raw data"), WithHeader("X-Part", "1"))
+	m.AttachBytes("logo.png", []byte("png"))
+	m.Attachments[1].ContentID = "logo@example.com"
+	m.Headers.Add("X-Campaign", "q3")
+	m.Date = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.MessageID = "<1@example.com>"
+	m.References = []string{"<0@example.com>"}
+	m.InReplyTo = "<0@example.com>"
+	m.Priority = PriorityHigh
+	m.ListUnsubscribe = []string{"mailto:unsubscribe@example.com", "https://example.com/unsubscribe"}
+	m.ListUnsubscribeOneClick = true
+
+	path := filepath.Join(t.TempDir(), "message.eml")
+	if err := m.SaveEML(path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	loaded, err := LoadEML(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.Bcc, m.Bcc) || !reflect.DeepEqual(loaded.Cc, m.Cc) || loaded.From != m.From ||
+		loaded.Subject != m.Subject || loaded.MessageID != m.MessageID || !loaded.Date.Equal(m.Date) ||
+		loaded.InReplyTo != m.InReplyTo || !reflect.DeepEqual(loaded.References, m.References) ||
+		loaded.Priority != PriorityHigh || !reflect.DeepEqual(loaded.ListUnsubscribe, m.ListUnsubscribe) ||
+		!loaded.ListUnsubscribeOneClick || !reflect.DeepEqual(loaded.Headers, m.Headers) {
+		t.Fatalf("expected the headers to round trip:\n%+v\n%+v", m, loaded)
+	}
+
+	if loaded.Body != m.Body || loaded.BodyContentType != "text/html" || len(loaded.Alternatives) != 1 ||
+		loaded.Alternatives[0].Body != m.Alternatives[0].Body {
+		t.Fatalf("expected the body to round trip: %+v", loaded)
+	}
+
+	if len(loaded.Attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(loaded.Attachments))
+	}
+
+	data := loaded.Attachment("data.bin")
+	if data == nil || !bytes.Equal(data.Data, m.Attachments[0].Data) || data.Description != "Raw data" || data.Headers.Get("X-Part") != "1" {
+		t.Fatalf("expected the attachment to round trip: %+v", data)
+	}
+
+	if logo := loaded.Attachment("logo.png"); logo == nil || logo.ContentID != "logo@example.com" || string(logo.Data) != "png" {
+		t.Fatalf("expected the embedded image to round trip: %+v", logo)
+	}
+
+	// Written again, the loaded message is the same but for the boundaries.
+	var original, again bytes.Buffer
+	m.WriteEML(&original)
+	loaded.WriteEML(&again)
+	boundary := regexp.MustCompile(`[0-9a-f]{30}`)
+	if boundary.ReplaceAllString(original.String(), "") != boundary.ReplaceAllString(again.String(), "") {
+		t.Fatalf("expected the same message:\n%s\n%s", original.String(), again.String())
+	}
+}
+
+func TestLoadEMLCharset(t *testing.T) {
+	m := NewMessage("Hi", "Hola José")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Charset = "iso-8859-1"
+
+	var buf bytes.Buffer
+	if err := m.WriteEML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadEML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Body != "Hola José" || loaded.Charset != "iso-8859-1" {
+		t.Fatalf("expected the body decoded from ISO-8859-1: %q %q", loaded.Body, loaded.Charset)
+	}
+
+	if _, err := LoadEML(bytes.NewReader([]byte("Subject: hi\r\nContent-Type: text/plain; charset=koi8-r\r\n\r\nhi"))); err == nil {
+		t.Fatal("expected an error for an unknown charset")
+	}
+
+	if _, err := LoadEML(bytes.NewReader([]byte("From: not an address\r\n\r\nhi"))); err == nil {
+		t.Fatal("expected an error for an invalid From")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+)
+
+// TransferEncoding is the Content-Transfer-Encoding of a part. The empty
+// one chooses it from the content of the part.
+type TransferEncoding string
+
+const (
+	// SevenBit sends short lines of ASCII as is.
+	SevenBit TransferEncoding = "7bit"
+
+	// EightBit sends short lines of any text as is. It needs a server that
+	// accepts 8BITMIME.
+	EightBit TransferEncoding = "8bit"
+
+	// QuotedPrintable escapes the non-ASCII bytes, keeping mostly ASCII text
+	// readable.
+	QuotedPrintable TransferEncoding = "quoted-printable"
+
+	// Base64 encodes any data in 4 characters for every 3 bytes.
+	Base64 TransferEncoding = "base64"
+)
+
+// chooseEncoding returns the transfer encoding for text: 7bit if it is
+// ASCII with short lines, 8bit if it has other octets, in any charset, in
+// lines that are still short enough and eightBit is set,
+// and otherwise quoted-printable, or base64 when that is more compact, as
+// is the case for mostly non-ASCII text.
+func chooseEncoding(text []byte, eightBit bool) TransferEncoding {
+	switch {
+	case is7bit(text):
+		return SevenBit
+	case eightBit && is8bit(text):
+		return EightBit
+	case len(encodeQuotedPrintable(text)) <= base64.StdEncoding.EncodedLen(len(text)):
+		return QuotedPrintable
+	default:
+		return Base64
+	}
+}
+
+// is7bit reports whether text can be sent with the 7bit transfer encoding
+// and arrive unchanged: short lines of ASCII without trailing spaces, which
+// some servers remove.
+func is7bit(text []byte) bool {
+	if !isASCII(string(text)) || !is8bit(text) {
+		return false
+	}
+
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) > 0 && (line[len(line)-1] == ' ' || line[len(line)-1] == '\t') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check returns an error if data can't be sent with the encoding.
+func (e TransferEncoding) check(data []byte) error {
+	switch e {
+	case SevenBit:
+		if !isASCII(string(data)) || !is8bit(data) {
+			return fmt.Errorf("data can't be sent as %s: it has non-ASCII characters, NULs or long lines", e)
+		}
+	case EightBit:
+		if !is8bit(data) {
+			return fmt.Errorf("data can't be sent as %s: it has NULs, bare CRs or long lines", e)
+		}
+	case QuotedPrintable, Base64:
+	default:
+		return fmt.Errorf("unknown transfer encoding %q", string(e))
+	}
+
+	return nil
+}
+
+// writeEncoded writes data encoded with the encoding, with CRLF line
+// endings in 7bit and 8bit text.
+func writeEncoded(buf *writer, encoding TransferEncoding, data []byte) {
+	switch encoding {
+	case SevenBit, EightBit:
+		buf.Write(toCRLF(data))
+	case QuotedPrintable:
+		buf.Write(encodeQuotedPrintable(data))
+	default:
+		writeBase64(buf, data)
+	}
+}
+
+// writeBase64 streams data to w encoded as base64, in lines of 76
+// characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: w})
+	encoder.Write(data)
+	encoder.Close()
+}
+
+// maxBase64Line is the longest line of base64 that RFC 2045 allows.
+const maxBase64Line = 76
+
+// lineWriter splits what is written to it in lines of maxBase64Line
+// characters, separated by CRLF. The last line isn't ended, as the
+// boundary that follows a part begins with its own CRLF.
+type lineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if l.column == maxBase64Line {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return n, err
+			}
+			l.column = 0
+		}
+
+		line := p
+		if len(line) > maxBase64Line-l.column {
+			line = line[:maxBase64Line-l.column]
+		}
+
+		written, err := l.w.Write(line)
+		n += written
+		l.column += written
+		if err != nil {
+			return n, err
+		}
+
+		p = p[len(line):]
+	}
+
+	return n, nil
+}
+
+func encodeQuotedPrintable(data []byte) []byte {
+	qp := bytes.NewBuffer(nil)
+	w := quotedprintable.NewWriter(qp)
+	w.Write(data)
+	w.Close()
+
+	return qp.Bytes()
+}
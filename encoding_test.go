@@ -0,0 +1,94 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	tests := []struct {
+		text     string
+		eightBit bool
+		encoding TransferEncoding
+	}{
+		{"hello\nworld", false, SevenBit},
+		{"trailing space \nworld", false, QuotedPrintable},
+		{strings.Repeat("a", 1000), false, QuotedPrintable},
+		{"José says hi", false, QuotedPrintable},
+		{"José says hi", true, EightBit},
+		{"日本語のテキスト", false, Base64},
+		{"nul\x00", true, QuotedPrintable},
+		{"Jos\xe9 says hi", true, EightBit},
+		{"bare\rcr \xe9", true, QuotedPrintable},
+		{strings.Repeat("\xe9", 999), true, Base64},
+	}
+
+	for _, test := range tests {
+		if encoding := chooseEncoding([]byte(test.text), test.eightBit); encoding != test.encoding {
+			t.Errorf("%q, 8bit %v: expected %s, got %s", test.text, test.eightBit, test.encoding, encoding)
+		}
+	}
+}
+
+func TestTransferEncoding(t *testing.T) {
+	m := NewMessage("Hi", "hello")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.BodyTransferEncoding = Base64
+	m.Alternatives = append(m.Alternatives, &Alternative{ContentType: "text/html", Body: "<p>hello</p>", TransferEncoding: QuotedPrintable})
+	m.AttachBytes("notes.txt", []byte("some notes\n"), WithTransferEncoding(SevenBit))
+
+	data := string(m.Bytes())
+	expected := []string{
+		"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=",
+		"Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>hello</p>",
+		"Content-Transfer-Encoding: 7bit\r\nContent-Disposition: attachment; filename=\"notes.txt\"\r\n\r\nsome notes\r\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(data, e) {
+			t.Fatalf("expected %q:\n%s", e, data)
+		}
+	}
+
+	m.BodyTransferEncoding = SevenBit
+	m.Body = "José"
+	if _, err := m.WriteTo(ioutil.Discard); err == nil {
+		t.Fatal("expected an error for non-ASCII text sent as 7bit")
+	}
+
+	m.BodyTransferEncoding = ""
+	m.AttachRawMessage([]byte("From: alice@example.com\r\n\r\nhi"))
+	m.Attachments[len(m.Attachments)-1].TransferEncoding = Base64
+	if _, err := m.WriteTo(ioutil.Discard); err == nil {
+		t.Fatal("expected an error for a base64 message/rfc822 attachment")
+	}
+}
+
+func TestAttachmentLines(t *testing.T) {
+	m := NewMessage("Hi", "hello")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AttachBytes("data.bin", bytes.Repeat([]byte{0xff}, 1000))
+
+	data := string(m.Bytes())
+	i := strings.Index(data, "filename=\"data.bin\"\r\n\r\n")
+	if i == -1 {
+		t.Fatalf("expected the attachment:\n%s", data)
+	}
+
+	encoded := data[i+len("filename=\"data.bin\"\r\n\r\n") : strings.LastIndex(data, "\r\n--")]
+	lines := strings.Split(encoded, "\r\n")
+	for _, line := range lines[:len(lines)-1] {
+		if len(line) != 76 {
+			t.Fatalf("expected lines of 76 characters, got %d:\n%s", len(line), encoded)
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+	if err != nil || !bytes.Equal(decoded, bytes.Repeat([]byte{0xff}, 1000)) {
+		t.Fatalf("expected the data to round trip: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// FailoverSender sends messages with the first of several senders, like
+// the SMTPSenders of a primary relay and its backups, and goes on to the
+// next one when a sender can't be reached: it times out, the connection
+// fails, the server answers 421 because it is shutting down, or an HTTP
+// API fails with a transient error. When the server rejects the message
+// itself, the next senders aren't tried.
+type FailoverSender struct {
+	// Senders are tried in order.
+	Senders []Sender
+}
+
+// NewFailoverSender returns a FailoverSender that tries the relays at the
+// addresses in order, each with the other options of s.
+func NewFailoverSender(s *SMTPSender, addrs ...string) *FailoverSender {
+	f := &FailoverSender{}
+	for _, addr := range addrs {
+		relay := *s
+		relay.Addr = addr
+		f.Senders = append(f.Senders, &relay)
+	}
+
+	return f
+}
+
+// FailoverError is returned by FailoverSender when a message isn't sent,
+// with the error of each sender that was tried, in order.
+type FailoverError struct {
+	Errs []error
+}
+
+func (e *FailoverError) Error() string {
+	messages := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("email: %d senders failed: %s", len(e.Errs), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the senders.
+func (e *FailoverError) Unwrap() []error {
+	return e.Errs
+}
+
+// Send sends the message. See SendContext.
+func (f *FailoverSender) Send(m *Message) error {
+	return f.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message with the first sender that can be
+// reached. If it isn't sent, the error is a FailoverError.
+func (f *FailoverSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := f.SendWithIndex(ctx, m)
+	return err
+}
+
+// SendWithIndex sends the message like SendContext and returns the index
+// in Senders of the one that sent it, so that the relay that accepted it
+// can be logged.
+func (f *FailoverSender) SendWithIndex(ctx context.Context, m *Message) (int, error) {
+	if len(f.Senders) == 0 {
+		return -1, errors.New("email: no senders")
+	}
+
+	e := &FailoverError{}
+	for i, s := range f.Senders {
+		err := s.SendContext(ctx, m)
+		if err == nil {
+			return i, nil
+		}
+
+		e.Errs = append(e.Errs, err)
+		if !unreachable(err) {
+			break
+		}
+	}
+
+	return -1, e
+}
+
+// unreachable reports whether a send that failed with err may succeed
+// with another server.
+func unreachable(err error) bool {
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code == 421
+	}
+
+	var timeout *TimeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+
+	return Transient(err)
+}
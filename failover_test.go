@@ -0,0 +1,65 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+// closedAddr returns an address where connections are refused.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	return l.Addr().String()
+}
+
+func TestFailoverSender(t *testing.T) {
+	server := newTestServer(t)
+
+	f := NewFailoverSender(&SMTPSender{CommandTimeout: 50 * time.Millisecond}, closedAddr(t), newSilentServer(t), server.Addr())
+	i, err := f.SendWithIndex(context.Background(), testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i != 2 || len(server.Messages()) != 1 {
+		t.Fatalf("expected the third relay to send the message, got %d", i)
+	}
+}
+
+func TestFailoverRejected(t *testing.T) {
+	rejecting := newTestServer(t)
+	rejecting.replies["RCPT TO:"] = "550 5.1.1 No such user"
+	backup := newTestServer(t)
+
+	f := NewFailoverSender(&SMTPSender{}, rejecting.Addr(), backup.Addr())
+	err := f.Send(testMessage())
+
+	var failoverErr *FailoverError
+	var protocolErr *textproto.Error
+	if !errors.As(err, &failoverErr) || len(failoverErr.Errs) != 1 || !errors.As(err, &protocolErr) || protocolErr.Code != 550 {
+		t.Fatalf("expected the rejection, got %v", err)
+	}
+
+	if len(backup.Commands()) != 0 {
+		t.Fatal("the backup relay was tried")
+	}
+
+	rejecting.mu.Lock()
+	rejecting.replies = map[string]string{"MAIL FROM:": "421 4.3.2 Shutting down"}
+	rejecting.mu.Unlock()
+	if err := f.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backup.Messages()) != 1 {
+		t.Fatal("expected the backup relay to send the message")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// FileSender writes each message into a .eml file of a directory instead
+// of sending it, so that during development the messages can be opened
+// with a mail client without a relay. The files are written by WriteEML,
+// so they have the Bcc recipients too. The files are named after the time
+// they were written, like 20060102-150405.000000-123456.eml, so that
+// they sort in order.
+type FileSender struct {
+	// Dir is the directory of the files, which is created if it doesn't
+	// exist.
+	Dir string
+}
+
+// Send sends the message. See SendContext.
+func (s *FileSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext validates the message and writes it into a new file.
+func (s *FileSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithPath(ctx, m)
+	return err
+}
+
+// SendWithPath writes the message like SendContext and returns the path
+// of the file.
+func (s *FileSender) SendWithPath(ctx context.Context, m *Message) (string, error) {
+	if s.Dir == "" {
+		return "", errors.New("email: missing directory")
+	}
+
+	if _, err := m.envelopeFrom(); err != nil {
+		return "", err
+	}
+
+	if len(m.Tolist()) == 0 {
+		return "", ErrNoRecipients
+	}
+
+	if err := m.validate(); err != nil {
+		return "", err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(s.Dir, time.Now().Format("20060102-150405.000000")+"-*.eml")
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.WriteEML(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,47 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	s := &FileSender{Dir: dir}
+
+	var paths []string
+	for i := 0; i < 2; i++ {
+		m := testMessage()
+		m.MessageID = "<1@example.com>"
+		m.Bcc = []Address{{Email: "audit@example.com"}}
+		path, err := s.SendWithPath(context.Background(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, append([]byte("Bcc: audit@example.com\r\n"), m.Bytes()...)) {
+			t.Fatalf("unexpected file:\n%s", data)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 || paths[0] == paths[1] {
+		t.Fatalf("unexpected files: %v", files)
+	}
+
+	m := testMessage()
+	m.To = nil
+	if _, err := s.SendWithPath(context.Background(), m); !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("expected ErrNoRecipients, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// GmailSender sends messages with the users.messages.send method of the
+// Gmail API, as raw messages, so that every feature of the message is
+// kept. It authenticates with an OAuth 2.0 access token with the
+// gmail.send scope, instead of a password.
+type GmailSender struct {
+	// Token returns the access token. It is called for each message, so
+	// that it can return a fresh token; with golang.org/x/oauth2:
+	//
+	//	Token: func() (string, error) {
+	//		t, err := tokenSource.Token()
+	//		if err != nil {
+	//			return "", err
+	//		}
+	//		return t.AccessToken, nil
+	//	},
+	Token func() (string, error)
+
+	// User is the email address of the mailbox that sends the messages,
+	// that of the token if empty.
+	User string
+
+	// DeleteSent deletes the copy of the messages that Gmail saves with
+	// the SENT label, which the API can't skip. It requires the
+	// https://mail.google.com/ scope.
+	DeleteSent bool
+
+	// Endpoint is the URL of the API, https://gmail.googleapis.com if
+	// empty.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Send sends the message. See SendContext.
+func (s *GmailSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If Gmail
+// rejects it, the error is an APIError.
+func (s *GmailSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithID(ctx, m)
+	return err
+}
+
+// SendWithID sends the message like SendContext and returns the ID of the
+// message in the mailbox, unless DeleteSent is set.
+func (s *GmailSender) SendWithID(ctx context.Context, m *Message) (string, error) {
+	if s.Token == nil {
+		return "", errors.New("email: missing Gmail token")
+	}
+
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return "", err
+	}
+
+	// Gmail takes the recipients from the headers, so the Bcc ones are in
+	// the Bcc header that WriteEML adds, which Gmail removes.
+	raw := bytes.NewBuffer(nil)
+	if err := m.WriteEML(raw); err != nil {
+		return "", err
+	}
+
+	token, err := s.Token()
+	if err != nil {
+		return "", err
+	}
+
+	user := s.User
+	if user == "" {
+		user = "me"
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://gmail.googleapis.com"
+	}
+	messages := endpoint + "/gmail/v1/users/" + url.PathEscape(user) + "/messages"
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	request := map[string]string{"raw": base64.URLEncoding.EncodeToString(raw.Bytes())}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := postJSON(ctx, s.HTTPClient, "gmail", messages+"/send", header, request, &response); err != nil {
+		return "", err
+	}
+
+	if !s.DeleteSent {
+		return response.ID, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, messages+"/"+url.PathEscape(response.ID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if err := doAPI(s.HTTPClient, req, "gmail", nil); err != nil {
+		return "", fmt.Errorf("email: message sent but not deleted: %w", err)
+	}
+
+	return "", nil
+}
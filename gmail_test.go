@@ -0,0 +1,84 @@
+package email
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGmailSender(t *testing.T) {
+	var raw []byte
+	var deleted string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization: %s", r.Header.Get("Authorization"))
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/gmail/v1/users/me/messages/send":
+			var request struct {
+				Raw string `json:"raw"`
+			}
+			data, _ := io.ReadAll(r.Body)
+			json.Unmarshal(data, &request)
+
+			var err error
+			if raw, err = base64.URLEncoding.DecodeString(request.Raw); err != nil {
+				t.Error(err)
+			}
+			io.WriteString(w, `{"id":"18b2f1c4","threadId":"18b2f1c4","labelIds":["SENT"]}`)
+
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/gmail/v1/users/me/messages/"):
+			deleted = strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	m := testMessage()
+	m.Bcc = []Address{{Email: "bcc@example.com"}}
+
+	s := &GmailSender{Token: func() (string, error) { return "token", nil }, Endpoint: server.URL}
+	id, err := s.SendWithID(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "18b2f1c4" || deleted != "" {
+		t.Fatalf("unexpected ID %q, deleted %q", id, deleted)
+	}
+
+	if !strings.HasPrefix(string(raw), "Bcc: bcc@example.com\r\nFrom: from@example.com\r\n") {
+		t.Fatalf("unexpected message:\n%s", raw)
+	}
+
+	s.DeleteSent = true
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != "18b2f1c4" {
+		t.Fatalf("expected the sent copy to be deleted, got %q", deleted)
+	}
+
+	m.From = Address{}
+	if err := s.Send(m); err == nil {
+		t.Fatal("expected an error without a From address")
+	}
+
+	m = testMessage()
+	m.ReplyTo = []Address{{Email: "not an address"}}
+	if err := s.Send(m); err == nil {
+		t.Fatal("expected an error for an invalid message")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphSender sends messages with the sendMail action of Microsoft Graph,
+// for Microsoft 365 tenants where SMTP AUTH is disabled. It authenticates
+// as an application registered in the tenant, with the OAuth 2.0 client
+// credentials flow, which requires the Mail.Send application permission.
+//
+// Graph builds the MIME message itself, so the Signer, Encrypter, DKIM,
+// Charset and transfer encoding settings of the message don't apply. A
+// message has either an HTML or a plain text body, the HTML one if it has
+// both, and Graph only accepts custom headers that start with X-, so the
+// others are left out.
+type GraphSender struct {
+	// TenantID is the directory ID of the tenant, or one of its domains.
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+
+	// User is the ID or user principal name of the mailbox that sends
+	// the messages, the From address of each message if empty.
+	User string
+
+	// SkipSentItems doesn't save a copy of the messages in the Sent Items
+	// folder of the mailbox.
+	SkipSentItems bool
+
+	// Endpoint is the URL of the API, https://graph.microsoft.com/v1.0 if
+	// empty, and TokenEndpoint that of the identity platform,
+	// https://login.microsoftonline.com if empty.
+	Endpoint      string
+	TokenEndpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+type graphRequest struct {
+	Message         graphMessage `json:"message"`
+	SaveToSentItems bool         `json:"saveToSentItems"`
+}
+
+type graphMessage struct {
+	Subject                string            `json:"subject"`
+	Body                   graphBody         `json:"body"`
+	From                   *graphRecipient   `json:"from,omitempty"`
+	ToRecipients           []graphRecipient  `json:"toRecipients,omitempty"`
+	CcRecipients           []graphRecipient  `json:"ccRecipients,omitempty"`
+	BccRecipients          []graphRecipient  `json:"bccRecipients,omitempty"`
+	ReplyTo                []graphRecipient  `json:"replyTo,omitempty"`
+	InternetMessageID      string            `json:"internetMessageId,omitempty"`
+	InternetMessageHeaders []graphHeader     `json:"internetMessageHeaders,omitempty"`
+	Attachments            []graphAttachment `json:"attachments,omitempty"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphRecipient struct {
+	EmailAddress struct {
+		Address string `json:"address"`
+		Name    string `json:"name,omitempty"`
+	} `json:"emailAddress"`
+}
+
+type graphHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// graphAttachment has the content encoded as base64, as encoding/json
+// does with byte slices.
+type graphAttachment struct {
+	Type         string `json:"@odata.type"`
+	Name         string `json:"name"`
+	ContentType  string `json:"contentType"`
+	ContentBytes []byte `json:"contentBytes"`
+	ContentID    string `json:"contentId,omitempty"`
+	IsInline     bool   `json:"isInline,omitempty"`
+}
+
+// Send sends the message. See SendContext.
+func (s *GraphSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If Graph
+// rejects it, or the identity platform the credentials, the error is an
+// APIError.
+func (s *GraphSender) SendContext(ctx context.Context, m *Message) error {
+	payload, err := m.graph()
+	if err != nil {
+		return err
+	}
+	payload.SaveToSentItems = !s.SkipSentItems
+
+	user := s.User
+	if user == "" {
+		user = m.From.Email
+	}
+
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://graph.microsoft.com/v1.0"
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+
+	err = postJSON(ctx, s.HTTPClient, "graph", endpoint+"/users/"+url.PathEscape(user)+"/sendMail", header, payload, nil)
+
+	// The token was revoked, so the next send gets a new one.
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+
+	return err
+}
+
+// accessToken returns the access token of the application, requesting a
+// new one if it doesn't have one that is valid for another minute.
+func (s *GraphSender) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expiry) > time.Minute {
+		return s.token, nil
+	}
+
+	endpoint := s.TokenEndpoint
+	if endpoint == "" {
+		endpoint = "https://login.microsoftonline.com"
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/"+url.PathEscape(s.TenantID)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doAPI(s.HTTPClient, req, "graph", &response); err != nil {
+		return "", err
+	}
+
+	if response.AccessToken == "" {
+		return "", errors.New("email: graph: missing access token")
+	}
+
+	s.token = response.AccessToken
+	s.expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+
+	return s.token, nil
+}
+
+// graph returns the payload of the sendMail action for the message.
+func (m *Message) graph() (*graphRequest, error) {
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &graphRequest{Message: graphMessage{
+		Subject:       m.Subject,
+		From:          &graphAddresses([]Address{m.From})[0],
+		ToRecipients:  graphAddresses(m.To),
+		CcRecipients:  graphAddresses(m.Cc),
+		BccRecipients: graphAddresses(m.Bcc),
+		ReplyTo:       graphAddresses(m.ReplyTo),
+
+		InternetMessageID: m.MessageID,
+	}}
+	msg := &payload.Message
+
+	// The parts are ordered text/plain first, so the HTML one, if any,
+	// replaces it.
+	for _, part := range m.apiParts() {
+		switch mediaType(part.ContentType) {
+		case "text/plain":
+			msg.Body = graphBody{ContentType: "Text", Content: part.Body}
+		case "text/html":
+			msg.Body = graphBody{ContentType: "HTML", Content: part.Body}
+		}
+	}
+
+	for _, field := range m.apiHeaders() {
+		if strings.HasPrefix(field.Key, "X-") {
+			msg.InternetMessageHeaders = append(msg.InternetMessageHeaders, graphHeader{Name: field.Key, Value: field.Value})
+		}
+	}
+
+	for _, a := range m.apiAttachments() {
+		msg.Attachments = append(msg.Attachments, graphAttachment{
+			Type:         "#microsoft.graph.fileAttachment",
+			Name:         a.Filename,
+			ContentType:  a.contentType(),
+			ContentBytes: a.Data,
+			ContentID:    a.ContentID,
+			IsInline:     a.Inline || a.ContentID != "",
+		})
+	}
+
+	return payload, nil
+}
+
+func graphAddresses(addresses []Address) []graphRecipient {
+	var list []graphRecipient
+	for _, a := range addresses {
+		var r graphRecipient
+		r.EmailAddress.Address = a.Email
+		r.EmailAddress.Name = a.Name
+		list = append(list, r)
+	}
+
+	return list
+}
@@ -0,0 +1,105 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGraphSender(t *testing.T) {
+	var tokens int
+	var payload map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			tokens++
+			if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" ||
+				r.FormValue("client_secret") != "secret" || r.FormValue("scope") != "https://graph.microsoft.com/.default" {
+				t.Errorf("unexpected token request: %v", r.Form)
+			}
+			io.WriteString(w, `{"token_type":"Bearer","expires_in":3599,"access_token":"token"}`)
+
+		case "/users/alice@example.com/sendMail":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("unexpected authorization: %s", r.Header.Get("Authorization"))
+			}
+			data, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(data, &payload); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusAccepted)
+
+		default:
+			t.Errorf("unexpected request: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	m := NewHTMLMessage("Hi", "<p>Hi</p>")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.InlineBytes("logo.png", []byte("png"))
+	m.Attachments[0].ContentID = "logo"
+	m.Headers.Add("X-Campaign", "q3")
+	m.InReplyTo = "<1@example.com>"
+	m.MessageID = "<2@example.com>"
+
+	s := &GraphSender{TenantID: "tenant", ClientID: "id", ClientSecret: "secret", SkipSentItems: true, Endpoint: server.URL, TokenEndpoint: server.URL}
+	for i := 0; i < 2; i++ {
+		if err := s.Send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tokens != 1 {
+		t.Fatalf("expected one token request, got %d", tokens)
+	}
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"message": {
+			"subject": "Hi",
+			"body": {"contentType": "HTML", "content": "<p>Hi</p>"},
+			"from": {"emailAddress": {"address": "alice@example.com", "name": "Alice"}},
+			"toRecipients": [{"emailAddress": {"address": "bob@example.com"}}],
+			"internetMessageHeaders": [{"name": "X-Campaign", "value": "q3"}],
+			"internetMessageId": "<2@example.com>",
+			"attachments": [{
+				"@odata.type": "#microsoft.graph.fileAttachment",
+				"name": "logo.png",
+				"contentType": "image/png",
+				"contentBytes": "cG5n",
+				"contentId": "logo",
+				"isInline": true
+			}]
+		},
+		"saveToSentItems": false
+	}`), &expected)
+
+	if !reflect.DeepEqual(payload, expected) {
+		t.Fatalf("unexpected payload:\n%v\n%v", payload, expected)
+	}
+}
+
+func TestGraphTokenError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":"invalid_client"}`)
+	}))
+	defer server.Close()
+
+	s := &GraphSender{TenantID: "tenant", TokenEndpoint: server.URL}
+	err := s.Send(testMessage())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// HeaderField is a single header line of a message.
+type HeaderField struct {
+	Key   string
+	Value string
+}
+
+// Headers is an ordered list of extra header fields. A key may appear more
+// than once; fields are written in the order they were added.
+type Headers []HeaderField
+
+// reservedHeaders are written by the package itself and can't be
+// overridden or duplicated with custom headers.
+var reservedHeaders = map[string]bool{
+	"Return-Path":                 true,
+	"From":                        true,
+	"Sender":                      true,
+	"To":                          true,
+	"Cc":                          true,
+	"Bcc":                         true,
+	"Reply-To":                    true,
+	"Subject":                     true,
+	"Message-Id":                  true,
+	"Date":                        true,
+	"In-Reply-To":                 true,
+	"References":                  true,
+	"Disposition-Notification-To": true,
+	"Return-Receipt-To":           true,
+	"X-Priority":                  true,
+	"Importance":                  true,
+	"X-Msmail-Priority":           true,
+	"List-Unsubscribe":            true,
+	"List-Unsubscribe-Post":       true,
+	"Mime-Version":                true,
+	"Content-Type":                true,
+	"Content-Transfer-Encoding":   true,
+}
+
+// reservedPartHeaders are written by the package for attachment parts.
+var reservedPartHeaders = map[string]bool{
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Id":                true,
+	"Content-Description":       true,
+}
+
+// Add appends a value for key, keeping any existing ones.
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, HeaderField{Key: textproto.CanonicalMIMEHeaderKey(key), Value: value})
+}
+
+// Set replaces all the values of key with value.
+func (h *Headers) Set(key, value string) {
+	h.Del(key)
+	h.Add(key, value)
+}
+
+// Del removes all the values of key.
+func (h *Headers) Del(key string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	fields := (*h)[:0]
+	for _, field := range *h {
+		if field.Key != key {
+			fields = append(fields, field)
+		}
+	}
+
+	*h = fields
+}
+
+// Get returns the first value of key or an empty string.
+func (h Headers) Get(key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	for _, field := range h {
+		if field.Key == key {
+			return field.Value
+		}
+	}
+
+	return ""
+}
+
+// Values returns all the values of key in order.
+func (h Headers) Values(key string) []string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+
+	var values []string
+	for _, field := range h {
+		if field.Key == key {
+			values = append(values, field.Value)
+		}
+	}
+
+	return values
+}
+
+// writeTo writes the fields that don't collide with the reserved headers
+// generated by the package, encoding non-ASCII values as RFC 2047 words.
+// Fields whose key isn't a valid field name are skipped: they could write
+// other fields, like a Bcc, and the reserved ones in any case.
+func (h Headers) writeTo(buf *writer, reserved map[string]bool) {
+	for _, field := range h {
+		key := textproto.CanonicalMIMEHeaderKey(field.Key)
+		if reserved[key] || !validFieldName(key) {
+			continue
+		}
+
+		writeHeader(buf, key, buf.word(field.Value))
+	}
+}
+
+// validFieldName reports whether key is a field name of RFC 5322: printable
+// ASCII characters other than the colon.
+func validFieldName(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 33 || key[i] > 126 || key[i] == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HeaderInjectionError is returned when serializing a message with a header
+// value that has a line break, which would otherwise end the field and let
+// the rest of the value add fields of its own, like a Bcc.
+type HeaderInjectionError struct {
+	Field string
+	Value string
+}
+
+func (e *HeaderInjectionError) Error() string {
+	return fmt.Sprintf("invalid %s header %q: line breaks aren't allowed", e.Field, e.Value)
+}
+
+// checkHeader returns a HeaderInjectionError if the value of field has a
+// line break.
+func checkHeader(field string, values ...string) error {
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return &HeaderInjectionError{Field: field, Value: value}
+		}
+	}
+
+	return nil
+}
+
+type headerCheck struct {
+	field  string
+	values []string
+}
+
+// checkHeaders checks all the values written in the headers of the message
+// and its parts, and the recipients of the envelope.
+func (m *Message) checkHeaders() error {
+	checks := []headerCheck{
+		{"Return-Path", []string{m.ReturnPath}},
+		{"From", addressValues(m.From)},
+		{"Sender", addressValues(m.Sender)},
+		{"To", addressValues(m.To...)},
+		{"Cc", addressValues(m.Cc...)},
+		{"Bcc", addressValues(m.Bcc...)},
+		{"Reply-To", addressValues(m.ReplyTo...)},
+		{"Subject", []string{m.Subject}},
+		{"Message-ID", []string{m.MessageID}},
+		{"In-Reply-To", []string{m.InReplyTo}},
+		{"References", m.References},
+		{"Disposition-Notification-To", m.ReadReceiptTo},
+		{"List-Unsubscribe", m.ListUnsubscribe},
+		{"Content-Type", []string{m.BodyContentType}},
+	}
+
+	for _, alternative := range m.Alternatives {
+		checks = append(checks, headerCheck{"Content-Type", []string{alternative.ContentType}})
+	}
+
+	for _, a := range m.Attachments {
+		checks = append(checks,
+			headerCheck{"Content-Type", []string{a.ContentType}},
+			headerCheck{"Content-ID", []string{a.ContentID}},
+			headerCheck{"Content-Disposition", []string{a.Filename}},
+			headerCheck{"Content-Description", []string{a.Description}})
+	}
+
+	for _, check := range checks {
+		if err := checkHeader(check.field, check.values...); err != nil {
+			return err
+		}
+	}
+
+	if err := m.Headers.check(); err != nil {
+		return err
+	}
+
+	for _, attachment := range m.Attachments {
+		if err := attachment.Headers.check(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HeaderNameError is returned when serializing a message with a custom
+// header whose key isn't a valid field name, like "Bcc: attacker@evil.com,
+// X-Campaign-Id", which would write a different field than the one asked for.
+type HeaderNameError struct {
+	Key string
+}
+
+func (e *HeaderNameError) Error() string {
+	return fmt.Sprintf("invalid header name %q", e.Key)
+}
+
+func (h Headers) check() error {
+	for _, field := range h {
+		if err := checkHeader(field.Key, field.Key, field.Value); err != nil {
+			return err
+		}
+
+		if !validFieldName(field.Key) {
+			return &HeaderNameError{Key: field.Key}
+		}
+	}
+
+	return nil
+}
+
+// maxLineLength is the line length recommended by RFC 5322. Longer header
+// values are folded at whitespace.
+const maxLineLength = 78
+
+// writeHeader writes a header field, folding the value into continuation
+// lines so that no line exceeds maxLineLength unless it has a single word
+// longer than that.
+func writeHeader(buf *writer, key, value string) {
+	buf.WriteString(key + ":")
+
+	lineLength := len(key) + 1
+	for _, word := range strings.Split(value, " ") {
+		if lineLength > len(key)+1 && word != "" && lineLength+1+len(word) > maxLineLength {
+			buf.WriteString("\r\n")
+			lineLength = 0
+		}
+
+		buf.WriteString(" " + word)
+		lineLength += 1 + len(word)
+	}
+
+	buf.WriteString("\r\n")
+}
+
+// encodeWord encodes a header value as an RFC 2047 word if it contains
+// non-ASCII characters.
+func encodeWord(s string) string {
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// word returns s as written in an unstructured header: raw when the
+// server accepts UTF-8 headers and as an RFC 2047 word otherwise.
+func (w *writer) word(s string) string {
+	if w.utf8 {
+		return s
+	}
+
+	return encodeWord(s)
+}
+
+// address returns an address as written in a header, with its domain in
+// punycode unless utf8Domains is set. See word.
+func (w *writer) address(a Address) string {
+	if !w.utf8Domains {
+		a = a.idna()
+	}
+
+	return a.format(w.utf8)
+}
+
+func (w *writer) addressList(addresses []Address) string {
+	written := make([]string, len(addresses))
+	for i, address := range addresses {
+		written[i] = w.address(address)
+	}
+
+	return strings.Join(written, ", ")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
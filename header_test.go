@@ -0,0 +1,146 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHeaders(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	m.Headers.Add("x-campaign-id", "1")
+	m.Headers.Add("X-Campaign-ID", "2")
+	m.Headers.Set("Organization", "José & Co")
+	m.Headers.Add("Precedence", "bulk")
+	m.Headers.Del("precedence")
+	m.Headers.Add("Subject", "injected")
+
+	if v := m.Headers.Values("X-Campaign-Id"); len(v) != 2 || v[0] != "1" || v[1] != "2" {
+		t.Fatalf("unexpected values %q", v)
+	}
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "X-Campaign-Id: 1\r\n") || !strings.Contains(data, "X-Campaign-Id: 2\r\n") {
+		t.Fatalf("expected both X-Campaign-Id values:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Organization: =?utf-8?q?Jos=C3=A9_&_Co?=\r\n") {
+		t.Fatalf("expected encoded Organization:\n%s", data)
+	}
+
+	if strings.Contains(data, "Precedence") {
+		t.Fatalf("expected Precedence to be deleted:\n%s", data)
+	}
+
+	if strings.Count(data, "Subject:") != 1 {
+		t.Fatalf("expected a single Subject header:\n%s", data)
+	}
+
+	m.Headers = append(m.Headers, HeaderField{Key: "bcc", Value: "hidden@example.com"})
+	if data := string(m.Bytes()); strings.Contains(data, "hidden@example.com") {
+		t.Fatalf("expected the lowercase bcc field to be skipped:\n%s", data)
+	}
+}
+
+func TestEncodedHeaders(t *testing.T) {
+	m := NewMessage("日本語", "this is the body")
+	m.From = Address{Name: "José", Email: "jose@example.com"}
+	m.To = []Address{{Email: "to@example.com"}, {Name: "Zoë", Email: "zoe@example.com"}}
+
+	data := string(m.Bytes())
+
+	for _, line := range []string{
+		"From: =?utf-8?q?Jos=C3=A9?= <jose@example.com>\r\n",
+		"To: to@example.com, =?utf-8?q?Zo=C3=AB?= <zoe@example.com>\r\n",
+		"Subject: =?utf-8?q?=E6=97=A5=E6=9C=AC=E8=AA=9E?=\r\n",
+	} {
+		if !strings.Contains(data, line) {
+			t.Fatalf("expected %q in:\n%s", line, data)
+		}
+	}
+}
+
+func TestHeaderFolding(t *testing.T) {
+	m := NewMessage(strings.Repeat("a very long subject ", 10), "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	for i := 0; i < 40; i++ {
+		m.To = append(m.To, Address{Email: "recipient@example.com"})
+	}
+
+	data := string(m.Bytes())
+	header := data[:strings.Index(data, "\r\n\r\n")]
+
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > maxLineLength {
+			t.Fatalf("line longer than %d characters: %q", maxLineLength, line)
+		}
+	}
+
+	if !strings.Contains(header, "Subject: a very long subject a very long subject a very long subject a very\r\n long subject") {
+		t.Fatalf("expected a folded Subject:\n%s", header)
+	}
+
+	if !strings.Contains(header, "recipient@example.com,\r\n recipient@example.com") {
+		t.Fatalf("expected a folded To:\n%s", header)
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	for _, inject := range []func(m *Message){
+		func(m *Message) { m.Subject = "Hi\r\nBcc: attacker@evil.com" },
+		func(m *Message) { m.From = Address{Email: "from@example.com\nBcc: attacker@evil.com"} },
+		func(m *Message) { m.To = append(m.To, Address{Email: "to@example.com\r\nBcc: attacker@evil.com"}) },
+		func(m *Message) { m.Headers.Add("X-Campaign-Id", "1\r\nBcc: attacker@evil.com") },
+		func(m *Message) { m.Headers.Add("X-Campaign-Id\r\nBcc", "attacker@evil.com") },
+		func(m *Message) { m.AttachBytes("a.txt\"\r\nBcc: attacker@evil.com", nil) },
+	} {
+		m := NewMessage("Hi", "this is the body")
+		m.From = Address{Email: "from@example.com"}
+		m.To = []Address{{Email: "to@example.com"}}
+		inject(m)
+
+		_, err := m.WriteTo(new(bytes.Buffer))
+
+		var injection *HeaderInjectionError
+		if !errors.As(err, &injection) {
+			t.Fatalf("expected a HeaderInjectionError, got %v", err)
+		}
+
+		if m.Bytes() != nil {
+			t.Fatal("expected no message to be serialized")
+		}
+
+		if err := m.validate(); !errors.As(err, &injection) {
+			t.Fatalf("expected the injection to be found before sending, got %v", err)
+		}
+	}
+}
+
+func TestHeaderName(t *testing.T) {
+	for _, key := range []string{"", "Bcc: attacker@evil.com, X-Campaign-Id", "X Campaign", "X-Señal"} {
+		m := NewMessage("Hi", "this is the body")
+		m.From = Address{Email: "from@example.com"}
+		m.To = []Address{{Email: "to@example.com"}}
+		m.Headers.Add(key, "1")
+
+		_, err := m.WriteTo(new(bytes.Buffer))
+
+		var name *HeaderNameError
+		if !errors.As(err, &name) || name.Key != key {
+			t.Fatalf("expected a HeaderNameError for %q, got %v", key, err)
+		}
+
+		m.Headers = nil
+		m.AttachBytes("a.txt", nil)
+		m.Attachments[0].Headers = Headers{{Key: key, Value: "1"}}
+
+		if err := m.validate(); !errors.As(err, &name) {
+			t.Fatalf("expected a HeaderNameError for the %q part header, got %v", key, err)
+		}
+	}
+}
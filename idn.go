@@ -0,0 +1,171 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// idnaAddress converts the domain of an address, with or without a display
+// name, to its ASCII form. The address is returned as given if it has an
+// ASCII domain or can't be parsed.
+func idnaAddress(address string) string {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+
+	domain := a.Address[strings.LastIndex(a.Address, "@")+1:]
+	if isASCII(domain) {
+		return address
+	}
+
+	ascii, err := idnaDomain(domain)
+	if err != nil {
+		return address
+	}
+
+	i := strings.LastIndex(address, domain)
+	if i == -1 {
+		return address
+	}
+
+	return address[:i] + ascii + address[i+len(domain):]
+}
+
+// idnaDomain converts an internationalized domain name to the ASCII
+// compatible encoding of RFC 5891, encoding non-ASCII labels as punycode.
+// Labels are only lowercased, not normalized with the full UTS #46 mapping,
+// so they should be given in NFC as typed by users.
+func idnaDomain(domain string) (string, error) {
+	domain = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(domain)
+
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+
+		encoded, err := punycode(strings.ToLower(label))
+		if err != nil {
+			return "", err
+		}
+
+		labels[i] = "xn--" + encoded
+		if len(labels[i]) > 63 {
+			return "", errors.New("idna: label too long: " + label)
+		}
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// Punycode parameters from RFC 3492, section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes a label as described in RFC 3492, without the "xn--"
+// prefix.
+func punycode(label string) (string, error) {
+	runes := []rune(label)
+
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for h := basic; h < len(runes); {
+		m := rune(0x10ffff)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+
+		if int(m-n) > (1<<31-1-delta)/(h+1) {
+			return "", errors.New("idna: punycode overflow")
+		}
+		delta += int(m-n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+
+			if r != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+
+				if q < t {
+					break
+				}
+
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out), nil
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+
+	delta += delta / points
+
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,50 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPunycode(t *testing.T) {
+	for domain, expected := range map[string]string{
+		"example.com":   "example.com",
+		"bücher.de":     "xn--bcher-kva.de",
+		"München.de":    "xn--mnchen-3ya.de",
+		"例子。广告":         "xn--fsqu00a.xn--4rr70v",
+		"mail.ñandú.ar": "mail.xn--and-6ma2c.ar",
+	} {
+		ascii, err := idnaDomain(domain)
+		if err != nil || ascii != expected {
+			t.Fatalf("expected %q for %q, got %q, %v", expected, domain, ascii, err)
+		}
+	}
+}
+
+func TestIDNAddresses(t *testing.T) {
+	if a := idnaAddress("José <jose@bücher.de>"); a != "José <jose@xn--bcher-kva.de>" {
+		t.Fatalf("expected the domain in punycode, got %q", a)
+	}
+
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "jose@bücher.de"}
+	m.To = []Address{{Name: "Anna", Email: "anna@münchen.de"}}
+
+	data := string(m.Bytes())
+	for _, expected := range []string{
+		"From: jose@xn--bcher-kva.de\r\n",
+		"To: Anna <anna@xn--mnchen-3ya.de>\r\n",
+		"@xn--bcher-kva.de>\r\n",
+	} {
+		if !strings.Contains(data, expected) {
+			t.Fatalf("expected %q:\n%s", expected, data)
+		}
+	}
+
+	if m.hasUTF8Addresses("jose@xn--bcher-kva.de", []string{"anna@xn--mnchen-3ya.de"}, false) {
+		t.Fatal("expected punycode domains not to require SMTPUTF8")
+	}
+
+	if !m.hasUTF8Addresses("jose@bücher.de", []string{"anna@münchen.de"}, true) {
+		t.Fatal("expected UTF-8 domains to require SMTPUTF8")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	htemplate "html/template"
+	"strings"
+	ttemplate "text/template"
+)
+
+// Recipient is the recipient of a copy of a message sent with
+// SendIndividually.
+type Recipient struct {
+	Address Address
+
+	// Data, if set, is the data of the copy: its subject and bodies are
+	// templates rendered with it, the HTML ones with html/template so
+	// that it is escaped, like {{.Name}}.
+	Data interface{}
+}
+
+// SendIndividually sends a copy of the message to each recipient. See
+// Client.SendIndividuallyContext.
+func (s *SMTPSender) SendIndividually(m *Message, recipients []Recipient) []error {
+	return s.SendIndividuallyContext(context.Background(), m, recipients)
+}
+
+// SendIndividuallyContext sends a copy of the message to each recipient
+// over a single session. See Client.SendIndividuallyContext.
+func (s *SMTPSender) SendIndividuallyContext(ctx context.Context, m *Message, recipients []Recipient) []error {
+	c := NewClient(s)
+	defer c.Close()
+
+	return c.SendIndividuallyContext(ctx, m, recipients)
+}
+
+// SendIndividually sends a copy of the message to each recipient. See
+// SendIndividuallyContext.
+func (c *Client) SendIndividually(m *Message, recipients []Recipient) []error {
+	return c.SendIndividuallyContext(context.Background(), m, recipients)
+}
+
+// SendIndividuallyContext sends a copy of the message to each recipient,
+// with only that recipient in To and no Cc or Bcc, so that the recipients
+// don't see each other and bounces tell which one failed. It goes on when
+// a copy fails, and returns the error of each recipient, nil for those
+// that were sent to, in the same order.
+func (c *Client) SendIndividuallyContext(ctx context.Context, m *Message, recipients []Recipient) []error {
+	errs := make([]error, len(recipients))
+	for i, r := range recipients {
+		individual, err := m.individual(r)
+		if err == nil {
+			err = c.SendContext(ctx, individual)
+		}
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// individual returns the copy of the message for the recipient.
+func (m *Message) individual(r Recipient) (*Message, error) {
+	c := m.Clone()
+	c.To = []Address{r.Address}
+	c.Cc = nil
+	c.Bcc = nil
+
+	// Each copy is a different message.
+	c.MessageID = ""
+
+	if r.Data == nil {
+		return c, nil
+	}
+
+	var err error
+	if c.Subject, err = render(c.Subject, "text/plain", r.Data); err != nil {
+		return nil, err
+	}
+
+	if c.Body, err = render(c.Body, c.BodyContentType, r.Data); err != nil {
+		return nil, err
+	}
+
+	for _, a := range c.Alternatives {
+		if a.Body, err = render(a.Body, a.ContentType, r.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// render executes the template src with data, with html/template if the
+// content type is HTML.
+func render(src, contentType string, data interface{}) (string, error) {
+	buf := bytes.NewBuffer(nil)
+
+	if strings.HasPrefix(contentType, "text/html") {
+		tmpl, err := htemplate.New("").Parse(src)
+		if err != nil {
+			return "", err
+		}
+
+		if err := tmpl.Execute(buf, data); err != nil {
+			return "", err
+		}
+
+		return buf.String(), nil
+	}
+
+	tmpl, err := ttemplate.New("").Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
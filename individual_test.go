@@ -0,0 +1,58 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendIndividually(t *testing.T) {
+	server := newTestServer(t)
+
+	m := NewHTMLMessage("Hi {{.Name}}", "<p>Hello {{.Name}}</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.Cc = []Address{{Email: "cc@example.com"}}
+	m.AddAlternative("text/plain", "Hello {{.Name}}")
+
+	s := &SMTPSender{Addr: server.Addr()}
+	errs := s.SendIndividually(m, []Recipient{
+		{Address: Address{Email: "alice@example.com"}, Data: map[string]string{"Name": "Alice"}},
+		{Address: Address{Email: "bob@example.com"}, Data: map[string]string{"Name": "<Bob>"}},
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := server.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+
+	for _, expected := range []string{"Subject: Hi Alice", "To: alice@example.com", "<p>Hello Alice</p>", "Hello Alice"} {
+		if !strings.Contains(messages[0], expected) {
+			t.Errorf("expected %q in the first message:\n%s", expected, messages[0])
+		}
+	}
+
+	for _, expected := range []string{"To: bob@example.com", "<p>Hello &lt;Bob&gt;</p>", "Hello <Bob>"} {
+		if !strings.Contains(messages[1], expected) {
+			t.Errorf("expected %q in the second message:\n%s", expected, messages[1])
+		}
+	}
+
+	for _, message := range messages {
+		if strings.Contains(message, "Cc:") || strings.Contains(message, "{{") {
+			t.Errorf("expected a rendered message without Cc:\n%s", message)
+		}
+	}
+
+	if strings.Contains(m.Subject, "Alice") || strings.Contains(m.Body, "Alice") {
+		t.Error("expected the original message to be unchanged")
+	}
+
+	if n := count(server.Commands(), "RCPT"); n != 2 {
+		t.Fatalf("expected one recipient per message, got %d", n)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// jsonMessage is the JSON form of a Message. Its field names are part of
+// the format and must not change, as messages may be queued by one version
+// of a program and sent by another.
+type jsonMessage struct {
+	From                    *jsonAddress      `json:"from,omitempty"`
+	Sender                  *jsonAddress      `json:"sender,omitempty"`
+	To                      []jsonAddress     `json:"to,omitempty"`
+	Cc                      []jsonAddress     `json:"cc,omitempty"`
+	Bcc                     []jsonAddress     `json:"bcc,omitempty"`
+	ReplyTo                 []jsonAddress     `json:"reply_to,omitempty"`
+	ReturnPath              string            `json:"return_path,omitempty"`
+	EnvelopeFrom            string            `json:"envelope_from,omitempty"`
+	DSN                     *jsonDSN          `json:"dsn,omitempty"`
+	Subject                 string            `json:"subject,omitempty"`
+	Body                    string            `json:"body,omitempty"`
+	BodyContentType         string            `json:"body_content_type,omitempty"`
+	BodyTransferEncoding    TransferEncoding  `json:"body_transfer_encoding,omitempty"`
+	Charset                 string            `json:"charset,omitempty"`
+	Alternatives            []jsonAlternative `json:"alternatives,omitempty"`
+	Attachments             []jsonAttachment  `json:"attachments,omitempty"`
+	Headers                 []jsonHeaderField `json:"headers,omitempty"`
+	MessageID               string            `json:"message_id,omitempty"`
+	Date                    *time.Time        `json:"date,omitempty"`
+	InReplyTo               string            `json:"in_reply_to,omitempty"`
+	References              []string          `json:"references,omitempty"`
+	ReadReceiptTo           []string          `json:"read_receipt_to,omitempty"`
+	Priority                Priority          `json:"priority,omitempty"`
+	ListUnsubscribe         []string          `json:"list_unsubscribe,omitempty"`
+	ListUnsubscribeOneClick bool              `json:"list_unsubscribe_one_click,omitempty"`
+	UTF8Domains             bool              `json:"utf8_domains,omitempty"`
+	InlineCSS               bool              `json:"inline_css,omitempty"`
+	Zip                     *jsonZipArchive   `json:"zip,omitempty"`
+}
+
+type jsonAddress struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+type jsonAlternative struct {
+	ContentType      string           `json:"content_type"`
+	Body             string           `json:"body"`
+	Charset          string           `json:"charset,omitempty"`
+	TransferEncoding TransferEncoding `json:"transfer_encoding,omitempty"`
+}
+
+// jsonAttachment has the data encoded as base64, as encoding/json does
+// with byte slices.
+type jsonAttachment struct {
+	Filename         string            `json:"filename"`
+	Data             []byte            `json:"data"`
+	Inline           bool              `json:"inline,omitempty"`
+	ContentType      string            `json:"content_type,omitempty"`
+	ContentID        string            `json:"content_id,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	Headers          []jsonHeaderField `json:"headers,omitempty"`
+	TransferEncoding TransferEncoding  `json:"transfer_encoding,omitempty"`
+}
+
+type jsonHeaderField struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type jsonDSN struct {
+	Notify     Notify `json:"notify,omitempty"`
+	Return     string `json:"return,omitempty"`
+	EnvelopeID string `json:"envelope_id,omitempty"`
+}
+
+type jsonZipArchive struct {
+	Filename  string   `json:"filename,omitempty"`
+	Level     int      `json:"level,omitempty"`
+	Filenames []string `json:"filenames,omitempty"`
+}
+
+// MarshalJSON encodes the message as JSON, with the attachment data in
+// base64, so that it can be queued and sent by another process. The
+// Clock, Signer, Encrypter, DKIM and Tracker settings aren't encoded:
+// they hold functions or keys, and the process that sends the message
+// must set them again.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	j := &jsonMessage{
+		From:                    jsonAddressOf(m.From),
+		Sender:                  jsonAddressOf(m.Sender),
+		To:                      jsonAddresses(m.To),
+		Cc:                      jsonAddresses(m.Cc),
+		Bcc:                     jsonAddresses(m.Bcc),
+		ReplyTo:                 jsonAddresses(m.ReplyTo),
+		ReturnPath:              m.ReturnPath,
+		EnvelopeFrom:            m.EnvelopeFrom,
+		Subject:                 m.Subject,
+		Body:                    m.Body,
+		BodyContentType:         m.BodyContentType,
+		BodyTransferEncoding:    m.BodyTransferEncoding,
+		Charset:                 m.Charset,
+		Headers:                 jsonHeaders(m.Headers),
+		MessageID:               m.MessageID,
+		InReplyTo:               m.InReplyTo,
+		References:              m.References,
+		ReadReceiptTo:           m.ReadReceiptTo,
+		Priority:                m.Priority,
+		ListUnsubscribe:         m.ListUnsubscribe,
+		ListUnsubscribeOneClick: m.ListUnsubscribeOneClick,
+		UTF8Domains:             m.UTF8Domains,
+		InlineCSS:               m.InlineCSS,
+	}
+
+	if !m.Date.IsZero() {
+		j.Date = &m.Date
+	}
+
+	for _, a := range m.Alternatives {
+		j.Alternatives = append(j.Alternatives, jsonAlternative{
+			ContentType:      a.ContentType,
+			Body:             a.Body,
+			Charset:          a.Charset,
+			TransferEncoding: a.TransferEncoding,
+		})
+	}
+
+	for _, a := range m.Attachments {
+		j.Attachments = append(j.Attachments, jsonAttachment{
+			Filename:         a.Filename,
+			Data:             a.Data,
+			Inline:           a.Inline,
+			ContentType:      a.ContentType,
+			ContentID:        a.ContentID,
+			Description:      a.Description,
+			Headers:          jsonHeaders(a.Headers),
+			TransferEncoding: a.TransferEncoding,
+		})
+	}
+
+	if m.DSN != nil {
+		j.DSN = &jsonDSN{Notify: m.DSN.Notify, Return: m.DSN.Return, EnvelopeID: m.DSN.EnvelopeID}
+	}
+
+	if m.Zip != nil {
+		j.Zip = &jsonZipArchive{Filename: m.Zip.Filename, Level: m.Zip.Level, Filenames: m.Zip.Filenames}
+	}
+
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON, replacing the
+// fields it encodes and leaving the others, like Signer or DKIM, as they
+// are.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	j := &jsonMessage{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return err
+	}
+
+	m.From = j.From.address()
+	m.Sender = j.Sender.address()
+	m.To = addressesOf(j.To)
+	m.Cc = addressesOf(j.Cc)
+	m.Bcc = addressesOf(j.Bcc)
+	m.ReplyTo = addressesOf(j.ReplyTo)
+	m.ReturnPath = j.ReturnPath
+	m.EnvelopeFrom = j.EnvelopeFrom
+	m.Subject = j.Subject
+	m.Body = j.Body
+	m.BodyContentType = j.BodyContentType
+	m.BodyTransferEncoding = j.BodyTransferEncoding
+	m.Charset = j.Charset
+	m.Headers = headersOf(j.Headers)
+	m.MessageID = j.MessageID
+	m.InReplyTo = j.InReplyTo
+	m.References = j.References
+	m.ReadReceiptTo = j.ReadReceiptTo
+	m.Priority = j.Priority
+	m.ListUnsubscribe = j.ListUnsubscribe
+	m.ListUnsubscribeOneClick = j.ListUnsubscribeOneClick
+	m.UTF8Domains = j.UTF8Domains
+	m.InlineCSS = j.InlineCSS
+
+	m.Date = time.Time{}
+	if j.Date != nil {
+		m.Date = *j.Date
+	}
+
+	m.Alternatives = nil
+	for _, a := range j.Alternatives {
+		m.Alternatives = append(m.Alternatives, &Alternative{
+			ContentType:      a.ContentType,
+			Body:             a.Body,
+			Charset:          a.Charset,
+			TransferEncoding: a.TransferEncoding,
+		})
+	}
+
+	m.Attachments = nil
+	for _, a := range j.Attachments {
+		m.Attachments = append(m.Attachments, &Attachment{
+			Filename:         a.Filename,
+			Data:             a.Data,
+			Inline:           a.Inline,
+			ContentType:      a.ContentType,
+			ContentID:        a.ContentID,
+			Description:      a.Description,
+			Headers:          headersOf(a.Headers),
+			TransferEncoding: a.TransferEncoding,
+		})
+	}
+
+	m.DSN = nil
+	if j.DSN != nil {
+		m.DSN = &DSN{Notify: j.DSN.Notify, Return: j.DSN.Return, EnvelopeID: j.DSN.EnvelopeID}
+	}
+
+	m.Zip = nil
+	if j.Zip != nil {
+		m.Zip = &ZipArchive{Filename: j.Zip.Filename, Level: j.Zip.Level, Filenames: j.Zip.Filenames}
+	}
+
+	return nil
+}
+
+func jsonAddressOf(a Address) *jsonAddress {
+	if a == (Address{}) {
+		return nil
+	}
+
+	return &jsonAddress{Name: a.Name, Email: a.Email}
+}
+
+func jsonAddresses(addresses []Address) []jsonAddress {
+	var list []jsonAddress
+	for _, a := range addresses {
+		list = append(list, jsonAddress{Name: a.Name, Email: a.Email})
+	}
+
+	return list
+}
+
+func (a *jsonAddress) address() Address {
+	if a == nil {
+		return Address{}
+	}
+
+	return Address{Name: a.Name, Email: a.Email}
+}
+
+func addressesOf(list []jsonAddress) []Address {
+	var addresses []Address
+	for _, a := range list {
+		addresses = append(addresses, a.address())
+	}
+
+	return addresses
+}
+
+func jsonHeaders(h Headers) []jsonHeaderField {
+	var fields []jsonHeaderField
+	for _, field := range h {
+		fields = append(fields, jsonHeaderField{Key: field.Key, Value: field.Value})
+	}
+
+	return fields
+}
+
+func headersOf(fields []jsonHeaderField) Headers {
+	var h Headers
+	for _, field := range fields {
+		h = append(h, HeaderField{Key: field.Key, Value: field.Value})
+	}
+
+	return h
+}
@@ -0,0 +1,52 @@
+package email
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	m := NewHTMLMessage("Hi", "<p>Hi</p>")
+	m.From = Address{Name: "José", Email: "jose@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Bcc = []Address{{Name: "Audit", Email: "audit@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.AttachBytes("data.bin", []byte{0, 1, 2, 0xff}, WithHeader("X-Part", "1"))
+	m.Headers.Add("X-Campaign", "q3")
+	m.MessageID = "<1@example.com>"
+	m.Date = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.References = []string{"<0@example.com>"}
+	m.Priority = PriorityHigh
+	m.Charset = "iso-8859-1"
+	m.Zip = &ZipArchive{Filename: "files.zip"}
+	m.DSN = &DSN{Notify: NotifySuccess | NotifyFailure, Return: ReturnHeaders}
+	m.DKIM = &DKIMSigner{Domain: "example.com"}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{`"from":{"name":"José","email":"jose@example.com"}`, `"data":"AAEC/w=="`, `"date":"2020-01-02T03:04:05Z"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expected %s in %s", expected, data)
+		}
+	}
+
+	if strings.Contains(string(data), "dkim") {
+		t.Fatalf("expected DKIM not to be encoded: %s", data)
+	}
+
+	decoded := &Message{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	m.DKIM = nil
+	if !reflect.DeepEqual(m, decoded) {
+		t.Fatalf("expected the message to round trip:\n%+v\n%+v", m, decoded)
+	}
+}
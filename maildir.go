@@ -0,0 +1,96 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir delivers messages into a Maildir directory, which mail clients
+// and servers like Dovecot read, to keep an archive of the messages sent.
+// Unlike an mbox file it needs no locking: each message is a file, written
+// in tmp and moved to new once complete.
+type Maildir string
+
+// maildirDeliveries counts the deliveries of the process, for unique names.
+var maildirDeliveries int64
+
+// Deliver writes the message, as written by WriteEML, into the new
+// directory of the Maildir, creating the tmp, new and cur directories if
+// they don't exist. It returns the path of the file.
+func (d Maildir) Deliver(m *Message) (string, error) {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(string(d), dir), 0700); err != nil {
+			return "", err
+		}
+	}
+
+	name, err := maildirName()
+	if err != nil {
+		return "", err
+	}
+
+	data := bytes.NewBuffer(nil)
+	if err := m.WriteEML(data); err != nil {
+		return "", err
+	}
+
+	tmp := filepath.Join(string(d), "tmp", name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	// The message must be on disk before it shows up in new.
+	if _, err := f.Write(toLF(data.Bytes())); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	path := filepath.Join(string(d), "new", name)
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// maildirName returns a unique name for a delivery, in the format
+// described at https://cr.yp.to/proto/maildir.html: the time, the process
+// ID with a counter and random token, and the host name.
+func maildirName() (string, error) {
+	token, err := randomToken(8)
+	if err != nil {
+		return "", err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+
+	now := time.Now()
+
+	return fmt.Sprintf("%d.M%dP%dQ%dR%s.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		atomic.AddInt64(&maildirDeliveries, 1), token, host), nil
+}
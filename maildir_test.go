@@ -0,0 +1,46 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaildir(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	dir := Maildir(filepath.Join(t.TempDir(), "Sent"))
+
+	first, err := dir.Deliver(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := dir.Deliver(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second || filepath.Dir(first) != filepath.Join(string(dir), "new") {
+		t.Fatalf("expected unique files in new: %s %s", first, second)
+	}
+
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(string(dir), sub))
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("expected an empty %s: %v %v", sub, entries, err)
+		}
+	}
+
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "Subject: Hi\n") || strings.Contains(string(data), "\r\n") {
+		t.Fatalf("expected the message with LF line endings:\n%s", data)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// MailgunSender sends messages with the messages endpoint of the Mailgun
+// API, over HTTPS.
+//
+// Mailgun builds the MIME message itself, so the Signer, Encrypter,
+// DKIM, Charset and transfer encoding settings of the message don't apply.
+// Embedded attachments are referenced by their file name, so their
+// Content-ID is sent as the file name.
+type MailgunSender struct {
+	// Domain is the sending domain, like "mg.example.com".
+	Domain string
+	APIKey string
+
+	// Region is "eu" for domains in the EU region, whose API is at
+	// api.eu.mailgun.net, or empty for the US region.
+	Region string
+
+	// Endpoint is the URL of the API, https://api.mailgun.net/v3 or
+	// https://api.eu.mailgun.net/v3 if empty.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Send sends the message. See SendContext.
+func (s *MailgunSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If
+// Mailgun rejects it, the error is an APIError.
+func (s *MailgunSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithID(ctx, m)
+	return err
+}
+
+// SendWithID sends the message like SendContext and returns the ID that
+// Mailgun assigned to it, like "<20240101.1@mg.example.com>", which its
+// events include.
+func (s *MailgunSender) SendWithID(ctx context.Context, m *Message) (string, error) {
+	if s.Domain == "" {
+		return "", errors.New("email: missing Mailgun domain")
+	}
+
+	body, contentType, err := m.mailgun()
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := s.Endpoint
+	switch {
+	case endpoint != "":
+	case s.Region == "eu":
+		endpoint = "https://api.eu.mailgun.net/v3"
+	default:
+		endpoint = "https://api.mailgun.net/v3"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/"+url.PathEscape(s.Domain)+"/messages", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.SetBasicAuth("api", s.APIKey)
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := doAPI(s.HTTPClient, req, "mailgun", &response); err != nil {
+		return "", err
+	}
+
+	return response.ID, nil
+}
+
+// mailgun returns the form of the messages endpoint for the message, and
+// its content type.
+func (m *Message) mailgun() (*bytes.Buffer, string, error) {
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return nil, "", err
+	}
+
+	body := bytes.NewBuffer(nil)
+	form := multipart.NewWriter(body)
+
+	field := func(name, value string) {
+		form.WriteField(name, value)
+	}
+
+	field("from", m.From.String())
+	for _, a := range m.To {
+		field("to", a.String())
+	}
+	for _, a := range m.Cc {
+		field("cc", a.String())
+	}
+	for _, a := range m.Bcc {
+		field("bcc", a.String())
+	}
+	field("subject", m.Subject)
+
+	if len(m.ReplyTo) > 0 {
+		field("h:Reply-To", joinAddresses(m.ReplyTo))
+	}
+
+	for _, part := range m.apiParts() {
+		switch mediaType(part.ContentType) {
+		case "text/plain":
+			field("text", part.Body)
+		case "text/html":
+			field("html", part.Body)
+		case "text/x-amp-html":
+			field("amp-html", part.Body)
+		}
+	}
+
+	for _, h := range m.apiHeaders() {
+		field("h:"+h.Key, h.Value)
+	}
+
+	for _, a := range m.apiAttachments() {
+		name, filename := "attachment", a.Filename
+		if a.ContentID != "" {
+			name, filename = "inline", a.ContentID
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, name, escapeQuotes(filename)))
+		header.Set("Content-Type", a.contentType())
+
+		w, err := form.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		w.Write(a.Data)
+	}
+
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body, form.FormDataContentType(), nil
+}
+
+// joinAddresses returns the addresses as a header value.
+func joinAddresses(addresses []Address) string {
+	list := make([]string, len(addresses))
+	for i, a := range addresses {
+		list[i] = a.String()
+	}
+
+	return strings.Join(list, ", ")
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// escapeQuotes escapes a quoted string, as mime/multipart does.
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,95 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMailgunSender(t *testing.T) {
+	var form map[string][]string
+	var files map[string][]string
+	var path, user, key string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, key, _ = r.BasicAuth()
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+
+		form = r.MultipartForm.Value
+		files = make(map[string][]string)
+		for name, headers := range r.MultipartForm.File {
+			for _, h := range headers {
+				files[name] = append(files[name], h.Filename+" "+h.Header.Get("Content-Type"))
+			}
+		}
+
+		io.WriteString(w, `{"id":"<1@mg.example.com>","message":"Queued. Thank you."}`)
+	}))
+	defer server.Close()
+
+	m := NewHTMLMessage("Hi", `<img src="cid:logo">`)
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}, {Email: "carol@example.com"}}
+	m.Bcc = []Address{{Email: "audit@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.AttachBytes("report.pdf", []byte("%PDF"))
+	m.InlineBytes("logo.png", []byte("png"))
+	m.Attachments[1].ContentID = "logo"
+	m.Headers.Add("X-Campaign", "q3")
+	m.MessageID = "<1@example.com>"
+
+	s := &MailgunSender{Domain: "mg.example.com", APIKey: "key", Endpoint: server.URL}
+	id, err := s.SendWithID(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "<1@mg.example.com>" || path != "/mg.example.com/messages" || user != "api" || key != "key" {
+		t.Fatalf("unexpected request: %s %s %s:%s", id, path, user, key)
+	}
+
+	expected := map[string][]string{
+		"from":         {"Alice <alice@example.com>"},
+		"to":           {"bob@example.com", "carol@example.com"},
+		"bcc":          {"audit@example.com"},
+		"subject":      {"Hi"},
+		"text":         {"Hi"},
+		"html":         {`<img src="cid:logo">`},
+		"h:Message-Id": {"<1@example.com>"},
+		"h:X-Campaign": {"q3"},
+	}
+	if !reflect.DeepEqual(form, expected) {
+		t.Fatalf("unexpected form:\n%v\n%v", form, expected)
+	}
+
+	expectedFiles := map[string][]string{
+		"attachment": {"report.pdf application/pdf"},
+		"inline":     {"logo image/png"},
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("unexpected files:\n%v\n%v", files, expectedFiles)
+	}
+}
+
+func TestMailgunError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "Forbidden")
+	}))
+	defer server.Close()
+
+	s := &MailgunSender{Domain: "mg.example.com", Endpoint: server.URL}
+
+	var apiErr *APIError
+	if err := s.Send(testMessage()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Provider != "mailgun" {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// MboxWriter appends messages to an mbox file in the mboxrd format, which
+// mail clients and tools like mutt or formail read, to keep an archive of
+// the messages sent. It is safe for concurrent use, but the file isn't
+// locked against other processes.
+type MboxWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewMboxWriter returns an MboxWriter that writes to w.
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{w: w}
+}
+
+// OpenMbox opens the mbox file at path to append messages to it, creating
+// it if it doesn't exist. Close the writer when done.
+func OpenMbox(path string) (*MboxWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMboxWriter(f), nil
+}
+
+var mboxFromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// Write appends the message as written by WriteEML, after a "From " line
+// with its envelope sender and date. Lines of the message that begin with
+// "From ", after any number of ">", are quoted with another ">".
+func (w *MboxWriter) Write(m *Message) error {
+	data := bytes.NewBuffer(nil)
+	if err := m.WriteEML(data); err != nil {
+		return err
+	}
+
+	sender, err := m.envelopeFrom()
+	if err != nil || sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+
+	entry := bytes.NewBuffer(nil)
+	entry.WriteString("From " + sender + " " + m.date().UTC().Format(time.ANSIC) + "\n")
+	entry.Write(mboxFromLine.ReplaceAll(toLF(data.Bytes()), []byte(">$1")))
+	entry.WriteString("\n\n")
+
+	// The entry is written at once so that appends don't interleave.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.w.Write(entry.Bytes())
+	return err
+}
+
+// Close closes the underlying writer if it is an io.Closer, like the file
+// of OpenMbox.
+func (w *MboxWriter) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// toLF converts CRLF line endings to the LF used in mbox and Maildir files.
+func toLF(data []byte) []byte {
+	return bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+}
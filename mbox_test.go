@@ -0,0 +1,50 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMboxWriter(t *testing.T) {
+	m := NewMessage("Hi", "From here on\n>From there\nbye")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Date = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	path := filepath.Join(t.TempDir(), "sent.mbox")
+	for i := 0; i < 2; i++ {
+		w, err := OpenMbox(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Write(m); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(string(data), "From from@example.com Thu Jan  2 03:04:05 2020\n") != 2 {
+		t.Fatalf("expected two entries:\n%s", data)
+	}
+
+	if !strings.Contains(string(data), "\n>From here on\n>>From there\nbye\n\n") {
+		t.Fatalf("expected the From lines to be quoted:\n%s", data)
+	}
+
+	if bytes.Contains(data, []byte("\r\n")) {
+		t.Fatalf("expected LF line endings:\n%s", data)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// MDN is a Message Disposition Notification (RFC 8098), the read receipt
+// sent back for messages with ReadReceiptTo.
+type MDN struct {
+	ReportingUA       string
+	OriginalRecipient string
+	FinalRecipient    string
+	OriginalMessageID string
+
+	// Disposition is the full disposition field, like
+	// "manual-action/MDN-sent-manually; displayed", and DispositionType
+	// just the type after the semicolon, like "displayed" or "deleted".
+	Disposition     string
+	DispositionType string
+}
+
+// ErrNotMDN is returned by ParseMDN for messages that aren't a disposition
+// notification report.
+var ErrNotMDN = errors.New("not a disposition notification")
+
+// ParseMDN parses an incoming read receipt.
+func ParseMDN(r io.Reader) (*MDN, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "disposition-notification") {
+		return nil, ErrNotMDN
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, ErrNotMDN
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); mediaType != "message/disposition-notification" {
+			continue
+		}
+
+		fields, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		mdn := &MDN{
+			ReportingUA:       fields.Get("Reporting-UA"),
+			OriginalRecipient: fields.Get("Original-Recipient"),
+			FinalRecipient:    fields.Get("Final-Recipient"),
+			OriginalMessageID: fields.Get("Original-Message-ID"),
+			Disposition:       fields.Get("Disposition"),
+		}
+
+		if i := strings.LastIndex(mdn.Disposition, ";"); i != -1 {
+			mdn.DispositionType = strings.TrimSpace(mdn.Disposition[i+1:])
+		}
+
+		return mdn, nil
+	}
+}
@@ -0,0 +1,69 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMDN = "From: to@example.com\r\n" +
+	"To: from@example.com\r\n" +
+	"Subject: Read: Hi\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=disposition-notification; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"The message was displayed.\r\n" +
+	"--b\r\n" +
+	"Content-Type: message/disposition-notification\r\n" +
+	"\r\n" +
+	"Reporting-UA: mail.example.com; Example Mail\r\n" +
+	"Final-Recipient: rfc822;to@example.com\r\n" +
+	"Original-Message-ID: <1@example.com>\r\n" +
+	"Disposition: manual-action/MDN-sent-manually; displayed\r\n" +
+	"--b--\r\n"
+
+func TestReadReceipt(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.ReadReceiptTo = []string{"from@example.com"}
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "Disposition-Notification-To: from@example.com\r\n") {
+		t.Fatalf("expected Disposition-Notification-To header:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Return-Receipt-To: from@example.com\r\n") {
+		t.Fatalf("expected Return-Receipt-To header:\n%s", data)
+	}
+}
+
+func TestParseMDN(t *testing.T) {
+	mdn, err := ParseMDN(strings.NewReader(testMDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mdn.OriginalMessageID != "<1@example.com>" {
+		t.Errorf("unexpected Original-Message-ID %q", mdn.OriginalMessageID)
+	}
+
+	if mdn.FinalRecipient != "rfc822;to@example.com" {
+		t.Errorf("unexpected Final-Recipient %q", mdn.FinalRecipient)
+	}
+
+	if mdn.DispositionType != "displayed" {
+		t.Errorf("unexpected disposition type %q", mdn.DispositionType)
+	}
+
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	if _, err := ParseMDN(strings.NewReader(string(m.Bytes()))); err != ErrNotMDN {
+		t.Fatalf("expected ErrNotMDN, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MemorySender records the messages instead of sending them, for the tests
+// of applications, which can check what would have been sent:
+//
+//	sender := &email.MemorySender{}
+//	app := NewApp(sender)
+//	app.ResetPassword("alice@example.com")
+//
+//	m := sender.Last()
+//	if m == nil || m.Subject != "Reset your password" {
+//		t.Fatal("expected a password reset")
+//	}
+//
+// It is safe for concurrent use.
+type MemorySender struct {
+	// Err, if set, is returned by the sends, which don't record the
+	// messages, to test how failures are handled.
+	Err error
+
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// Send sends the message. See SendContext.
+func (s *MemorySender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext validates the message and records a copy of it, so that
+// later changes to the message don't affect it.
+func (s *MemorySender) SendContext(ctx context.Context, m *Message) error {
+	if _, err := m.envelopeFrom(); err != nil {
+		return err
+	}
+
+	if len(m.Tolist()) == 0 {
+		return ErrNoRecipients
+	}
+
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+
+	s.messages = append(s.messages, m.Clone())
+
+	return nil
+}
+
+// Messages returns the messages that were sent, in order.
+func (s *MemorySender) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Message(nil), s.messages...)
+}
+
+// Last returns the last message that was sent, or nil.
+func (s *MemorySender) Last() *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == 0 {
+		return nil
+	}
+
+	return s.messages[len(s.messages)-1]
+}
+
+// ByRecipient returns the messages that were sent to the email address,
+// in To, Cc or Bcc, in order. Addresses are compared without case.
+func (s *MemorySender) ByRecipient(email string) []*Message {
+	return s.filter(func(m *Message) bool {
+		for _, to := range m.Tolist() {
+			if strings.EqualFold(to, email) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// BySubject returns the messages that were sent with the subject, in
+// order.
+func (s *MemorySender) BySubject(subject string) []*Message {
+	return s.filter(func(m *Message) bool {
+		return m.Subject == subject
+	})
+}
+
+// Reset forgets the messages that were sent.
+func (s *MemorySender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+}
+
+func (s *MemorySender) filter(match func(m *Message) bool) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []*Message
+	for _, m := range s.messages {
+		if match(m) {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
@@ -0,0 +1,57 @@
+package email
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestMemorySender(t *testing.T) {
+	s := &MemorySender{}
+
+	if s.Last() != nil {
+		t.Fatal("expected no messages")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Send(testMessage()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m := testMessage()
+	m.Subject = "Reset your password"
+	m.Bcc = []Address{{Email: "Alice@example.com"}}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	m.Subject = "Changed"
+
+	if len(s.Messages()) != 11 || s.Last().Subject != "Reset your password" {
+		t.Fatalf("unexpected messages: %d", len(s.Messages()))
+	}
+
+	if len(s.ByRecipient("alice@example.com")) != 1 || len(s.ByRecipient("to@example.com")) != 11 {
+		t.Fatal("unexpected messages by recipient")
+	}
+
+	if len(s.BySubject("Hi")) != 10 || len(s.BySubject("Changed")) != 0 {
+		t.Fatal("unexpected messages by subject")
+	}
+
+	s.Err = errors.New("unavailable")
+	if err := s.Send(testMessage()); err != s.Err {
+		t.Fatalf("expected the error, got %v", err)
+	}
+
+	s.Reset()
+	if len(s.Messages()) != 0 {
+		t.Fatal("expected no messages")
+	}
+}
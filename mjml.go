@@ -0,0 +1,142 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// MJMLRenderer converts MJML markup (https://mjml.io) into the responsive
+// HTML sent as the body of a message.
+type MJMLRenderer interface {
+	RenderMJML(mjml string) (string, error)
+}
+
+// MJMLCommand renders MJML with the mjml command line tool, reading the
+// markup from its standard input and the HTML from its standard output.
+type MJMLCommand struct {
+	// Path is the command to run, "mjml" in the PATH if empty.
+	Path string
+
+	// Args are the arguments of the command, "-i -s" if nil, which makes
+	// mjml use the standard input and output.
+	Args []string
+}
+
+func (c *MJMLCommand) RenderMJML(mjml string) (string, error) {
+	path := c.Path
+	if path == "" {
+		path = "mjml"
+	}
+
+	args := c.Args
+	if args == nil {
+		args = []string{"-i", "-s"}
+	}
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(mjml)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("mjml: %v: %s", err, msg)
+		}
+		return "", fmt.Errorf("mjml: %v", err)
+	}
+
+	return stdout.String(), nil
+}
+
+// MJMLAPI renders MJML with the MJML HTTP API, authenticating with the
+// application ID and secret key of the account.
+type MJMLAPI struct {
+	ApplicationID string
+	SecretKey     string
+
+	// URL is the render endpoint, https://api.mjml.io/v1/render if empty.
+	URL string
+
+	// Client is used to call the API, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+func (a *MJMLAPI) RenderMJML(mjml string) (string, error) {
+	url := a.URL
+	if url == "" {
+		url = "https://api.mjml.io/v1/render"
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	body, err := json.Marshal(map[string]string{"mjml": mjml})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(a.ApplicationID, a.SecretKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		HTML    string `json:"html"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Line    int    `json:"line"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("mjml: %s: %v", resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if result.Message == "" {
+			result.Message = resp.Status
+		}
+		return "", errors.New("mjml: " + result.Message)
+	}
+
+	// The API renders invalid markup as well as it can and reports the
+	// problems, which would otherwise go unnoticed in the sent message.
+	if len(result.Errors) > 0 {
+		return "", fmt.Errorf("mjml: line %d: %s", result.Errors[0].Line, result.Errors[0].Message)
+	}
+
+	return result.HTML, nil
+}
+
+// NewMJMLMessage returns a new HTML message with the body rendered from
+// MJML markup by r.
+func NewMJMLMessage(subject, mjml string, r MJMLRenderer) (*Message, error) {
+	html, err := r.RenderMJML(mjml)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHTMLMessage(subject, html), nil
+}
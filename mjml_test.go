@@ -0,0 +1,164 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMJMLAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "app" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Unauthorized"}`))
+			return
+		}
+
+		var req struct{ MJML string }
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req.MJML == "<mjml><bad/></mjml>" {
+			w.Write([]byte(`{"html":"","errors":[{"line":1,"message":"bad is not allowed"}]}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"html": "<html>" + req.MJML + "</html>"})
+	}))
+	defer server.Close()
+
+	api := &MJMLAPI{ApplicationID: "app", SecretKey: "secret", URL: server.URL}
+
+	m, err := NewMJMLMessage("Hi", "<mjml></mjml>", api)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.BodyContentType != "text/html" || m.Body != "<html><mjml></mjml></html>" {
+		t.Fatalf("unexpected body: %s %q", m.BodyContentType, m.Body)
+	}
+
+	if _, err := api.RenderMJML("<mjml><bad/></mjml>"); err == nil || err.Error() != "mjml: line 1: bad is not allowed" {
+		t.Fatalf("expected the MJML error, got %v", err)
+	}
+
+	api.SecretKey = "wrong"
+	if _, err := api.RenderMJML("<mjml></mjml>"); err == nil || err.Error() != "mjml: Unauthorized" {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestMJMLTemplate(t *testing.T) {
+	templates := NewTemplates()
+	err := templates.ParseFS(fstest.MapFS{
+		"news.mjml":    {Data: []byte("<mj-text>{{.}}</mj-text>")},
+		"news.subject": {Data: []byte("News")},
+	}, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := templates.NewMessage("news", "Tom & Jerry"); err == nil {
+		t.Fatal("expected an error without an MJML renderer")
+	}
+
+	// The test binary stands in for mjml, see TestMJMLHelperProcess.
+	templates.MJML = &MJMLCommand{Path: os.Args[0], Args: []string{"-test.run=^TestMJMLHelperProcess$"}}
+	t.Setenv("EMAIL_TEST_MJML", "render")
+
+	m, err := templates.NewMessage("news", "Tom & Jerry")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.BodyContentType != "text/html" || m.Body != "<p>Tom &amp; Jerry</p>" {
+		t.Fatalf("expected the rendered MJML as HTML body, got %s %q", m.BodyContentType, m.Body)
+	}
+
+	t.Setenv("EMAIL_TEST_MJML", "fail")
+	if _, err := templates.NewMessage("news", nil); err == nil || !strings.Contains(err.Error(), "invalid markup") {
+		t.Fatalf("expected an error when the command fails, got %v", err)
+	}
+}
+
+func TestMJMLLayout(t *testing.T) {
+	templates := NewTemplates()
+	templates.Layout = "layout"
+	templates.MJML = &MJMLCommand{Path: os.Args[0], Args: []string{"-test.run=^TestMJMLHelperProcess$"}}
+	t.Setenv("EMAIL_TEST_MJML", "render")
+
+	err := templates.ParseFS(fstest.MapFS{
+		"_layout.html": {Data: []byte(`<body>{{template "content" .}}{{template "footer"}}</body>`)},
+		"_footer.html": {Data: []byte(`<p>Bye</p>`)},
+		"_layout.mjml": {Data: []byte(`<mj-body>{{template "content" .}}{{template "footer"}}</mj-body>`)},
+		"_footer.mjml": {Data: []byte(`<mj-text>Bye</mj-text>`)},
+		"welcome.html": {Data: []byte(`{{define "content"}}<h1>{{.}}</h1>{{end}}`)},
+		"news.mjml":    {Data: []byte(`{{define "content"}}<mj-text>{{.}}</mj-text>{{end}}`)},
+		"news.subject": {Data: []byte("News")},
+	}, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.NewMessage("welcome", "Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Body != "<body><h1>Tom</h1><p>Bye</p></body>" {
+		t.Fatalf("expected the HTML layout and footer, got %q", m.Body)
+	}
+
+	m, err = templates.NewMessage("news", "Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Body != "<mj-body><p>Tom</p><p>Bye</p></mj-body>" {
+		t.Fatalf("expected the MJML layout and footer, got %q", m.Body)
+	}
+
+	// Without an MJML layout, the .mjml bodies are rendered on their own,
+	// not in the HTML layout.
+	templates = NewTemplates()
+	templates.Layout = "layout"
+	templates.MJML = &MJMLCommand{Path: os.Args[0], Args: []string{"-test.run=^TestMJMLHelperProcess$"}}
+	if err := templates.AddPartial("layout", `<body>{{template "content" .}}</body>`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := templates.ParseFS(fstest.MapFS{"plain.mjml": {Data: []byte(`<mj-text>{{.}}</mj-text>`)}}, "*"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = templates.NewMessage("plain", "Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Body != "<p>Tom</p>" {
+		t.Fatalf("expected the MJML body without the HTML layout, got %q", m.Body)
+	}
+}
+
+// TestMJMLHelperProcess isn't a real test: it is the mjml command of
+// TestMJMLTemplate, which runs the test binary with EMAIL_TEST_MJML set. It
+// renders mj-text elements as paragraphs, or fails.
+func TestMJMLHelperProcess(t *testing.T) {
+	switch os.Getenv("EMAIL_TEST_MJML") {
+	case "":
+		return
+	case "fail":
+		fmt.Fprintln(os.Stderr, "invalid markup")
+		os.Exit(1)
+	}
+
+	mjml, _ := io.ReadAll(os.Stdin)
+	os.Stdout.Write(bytes.ReplaceAll(mjml, []byte("mj-text"), []byte("p")))
+	os.Exit(0)
+}
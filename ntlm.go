@@ -0,0 +1,264 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net/smtp"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type ntlmAuth struct {
+	domain, username, password string
+}
+
+// NTLMAuth returns an Auth that implements the NTLM mechanism of
+// on-premises Exchange servers that require integrated Windows
+// authentication, with NTLMv2 responses. The username can have the domain,
+// like `EXAMPLE\alice`.
+//
+// The password isn't sent, only responses to a challenge of the server
+// computed with it, so relays accept it without TLS.
+func NTLMAuth(username, password string) smtp.Auth {
+	domain := ""
+	if i := strings.IndexByte(username, '\\'); i >= 0 {
+		domain, username = username[:i], username[i+1:]
+	}
+
+	return &ntlmAuth{domain: domain, username: username, password: password}
+}
+
+// The NTLM flags of MS-NLMP, section 2.2.2.5.
+const (
+	ntlmNegotiateUnicode     = 0x00000001
+	ntlmRequestTarget        = 0x00000004
+	ntlmNegotiateNTLM        = 0x00000200
+	ntlmNegotiateAlwaysSign  = 0x00008000
+	ntlmNegotiateExtendedSec = 0x00080000
+	ntlmNegotiateTargetInfo  = 0x00800000
+	ntlmNegotiate128         = 0x20000000
+	ntlmNegotiate56          = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSec | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+func (a *ntlmAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// The NEGOTIATE message, without the domain and workstation.
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmFlags)
+
+	return "NTLM", msg, nil
+}
+
+func (a *ntlmAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	challenge, targetInfo, err := parseNTLMChallenge(fromServer)
+	if err != nil {
+		return nil, err
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+
+	timestamp := ntlmTimestamp(targetInfo)
+	if timestamp == nil {
+		timestamp = ntlmFiletime(time.Now())
+	}
+
+	return a.authenticate(challenge, targetInfo, clientChallenge, timestamp), nil
+}
+
+// parseNTLMChallenge returns the server challenge and the target info of a
+// CHALLENGE message.
+func parseNTLMChallenge(msg []byte) ([]byte, []byte, error) {
+	if len(msg) < 32 || !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, nil, errors.New("smtp: invalid NTLM challenge")
+	}
+
+	challenge := msg[24:32]
+
+	var targetInfo []byte
+	if len(msg) >= 48 {
+		size := int(binary.LittleEndian.Uint16(msg[40:]))
+		offset := int(binary.LittleEndian.Uint32(msg[44:]))
+		if offset+size > len(msg) {
+			return nil, nil, errors.New("smtp: invalid NTLM challenge")
+		}
+		targetInfo = msg[offset : offset+size]
+	}
+
+	return challenge, targetInfo, nil
+}
+
+// ntlmTimestamp returns the MsvAvTimestamp of the target info, or nil.
+func ntlmTimestamp(targetInfo []byte) []byte {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		size := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == 0 || len(targetInfo) < 4+size {
+			break
+		}
+
+		if id == 7 && size == 8 {
+			return targetInfo[4:12]
+		}
+
+		targetInfo = targetInfo[4+size:]
+	}
+
+	return nil
+}
+
+// ntlmFiletime returns t as a Windows FILETIME: the number of 100ns
+// intervals since 1601.
+func ntlmFiletime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t.UnixNano()/100+116444736000000000))
+	return b
+}
+
+// authenticate returns the AUTHENTICATE message with the NTLMv2 responses
+// of MS-NLMP, section 3.3.2. If the server sent its time in the target
+// info, the LMv2 response is zeros instead, as section 3.1.5.1.2 says.
+func (a *ntlmAuth) authenticate(challenge, targetInfo, clientChallenge, timestamp []byte) []byte {
+	key := a.ntowfv2()
+
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntResponse := append(hmacMD5(key, challenge, temp), temp...)
+	lmResponse := append(hmacMD5(key, challenge, clientChallenge), clientChallenge...)
+	if ntlmTimestamp(targetInfo) != nil {
+		lmResponse = make([]byte, 24)
+	}
+
+	fields := [][]byte{
+		lmResponse,
+		ntResponse,
+		utf16LE(a.domain),
+		utf16LE(a.username),
+		nil, // workstation
+		nil, // session key
+	}
+
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+
+	offset := len(msg)
+	for i, field := range fields {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], ntlmFlags)
+
+	for _, field := range fields {
+		msg = append(msg, field...)
+	}
+
+	return msg
+}
+
+// ntowfv2 returns the NTLMv2 hash of the password.
+func (a *ntlmAuth) ntowfv2() []byte {
+	return hmacMD5(md4(utf16LE(a.password)), utf16LE(strings.ToUpper(a.username)+a.domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+
+	return b
+}
+
+var (
+	md4Order = [3][16]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15},
+		{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15},
+	}
+	md4Shift = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+)
+
+// md4 returns the MD4 hash of RFC 1320, which NTLM uses and the standard
+// library doesn't have.
+func md4(data []byte) []byte {
+	msg := append([]byte(nil), data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	h := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	for ; len(msg) > 0; msg = msg[64:] {
+		var x [16]uint32
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+
+		a, b, c, d := h[0], h[1], h[2], h[3]
+		for round := 0; round < 3; round++ {
+			for i, k := range md4Order[round] {
+				var t uint32
+				switch round {
+				case 0:
+					t = a + (b&c | ^b&d) + x[k]
+				case 1:
+					t = a + (b&c | b&d | c&d) + x[k] + 0x5a827999
+				case 2:
+					t = a + (b ^ c ^ d) + x[k] + 0x6ed9eba1
+				}
+				a, b, c, d = d, bits.RotateLeft32(t, md4Shift[round][i%4]), b, c
+			}
+		}
+
+		h[0] += a
+		h[1] += b
+		h[2] += c
+		h[3] += d
+	}
+
+	sum := make([]byte, 16)
+	for i, v := range h {
+		binary.LittleEndian.PutUint32(sum[4*i:], v)
+	}
+
+	return sum
+}
@@ -0,0 +1,108 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestMD4(t *testing.T) {
+	tests := map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+
+	for data, expected := range tests {
+		if sum := hex.EncodeToString(md4([]byte(data))); sum != expected {
+			t.Errorf("md4(%q) = %s, expected %s", data, sum, expected)
+		}
+	}
+}
+
+// ntlmTestChallenge is the CHALLENGE message of MS-NLMP, section 4.2.4.
+func ntlmTestChallenge() []byte {
+	targetInfo, _ := hex.DecodeString("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	copy(msg[24:], []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef})
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+
+	return append(msg, targetInfo...)
+}
+
+func TestNTLMv2(t *testing.T) {
+	a := NTLMAuth(`Domain\User`, "Password").(*ntlmAuth)
+
+	if key := hex.EncodeToString(a.ntowfv2()); key != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatalf("unexpected NTOWFv2 %s", key)
+	}
+
+	challenge, targetInfo, err := parseNTLMChallenge(ntlmTestChallenge())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := a.authenticate(challenge, targetInfo, bytes.Repeat([]byte{0xaa}, 8), make([]byte, 8))
+
+	field := func(i int) []byte {
+		size := binary.LittleEndian.Uint16(msg[12+8*i:])
+		offset := binary.LittleEndian.Uint32(msg[16+8*i:])
+		return msg[offset : offset+uint32(size)]
+	}
+
+	if lm := hex.EncodeToString(field(0)); lm != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Errorf("unexpected LMv2 response %s", lm)
+	}
+
+	if proof := hex.EncodeToString(field(1)[:16]); proof != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Errorf("unexpected NTProofStr %s", proof)
+	}
+
+	if domain := field(2); !bytes.Equal(domain, utf16LE("Domain")) {
+		t.Errorf("unexpected domain %q", domain)
+	}
+
+	if user := field(3); !bytes.Equal(user, utf16LE("User")) {
+		t.Errorf("unexpected user %q", user)
+	}
+
+	// With the time of the server, MsvAvTimestamp, the LMv2 response is
+	// zeros.
+	withTime := append(append([]byte{7, 0, 8, 0}, ntlmFiletime(time.Now())...), targetInfo...)
+	msg = a.authenticate(challenge, withTime, bytes.Repeat([]byte{0xaa}, 8), ntlmTimestamp(withTime))
+	if lm := field(0); !bytes.Equal(lm, make([]byte, 24)) {
+		t.Errorf("expected a zero LMv2 response, got %x", lm)
+	}
+}
+
+func TestNTLMAuth(t *testing.T) {
+	server := newTestServer(t)
+	server.challenges["NTLM"] = []string{base64.StdEncoding.EncodeToString(ntlmTestChallenge())}
+
+	s := &SMTPSender{Addr: server.Addr(), Auth: NTLMAuth(`Domain\User`, "Password")}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := server.Commands()
+	if !bytes.HasPrefix([]byte(commands[1]), []byte("AUTH NTLM TlRMTVNTUAAB")) {
+		t.Fatalf("expected a NEGOTIATE message, got %q", commands[1])
+	}
+
+	msg, err := base64.StdEncoding.DecodeString(commands[2])
+	if err != nil || !bytes.HasPrefix(msg, append(ntlmSignature, 3)) {
+		t.Fatalf("expected an AUTHENTICATE message, got %q", commands[2])
+	}
+
+	if _, _, err := parseNTLMChallenge([]byte("NTLMSSP\x00")); err == nil {
+		t.Fatal("expected an error for a short challenge")
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// CharsetReader, if set, decodes the text of parsed messages in charsets
+// other than UTF-8, US-ASCII and ISO-8859-1, both in bodies and in RFC 2047
+// words. golang.org/x/net/html/charset.NewReaderLabel is one for most of
+// them. Without it, Parse fails on those charsets.
+var CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+// Parse reads a raw RFC 5322 message, like one received or saved in an .eml
+// file, so that it can be replied to, forwarded or archived. The headers
+// are decoded from RFC 2047 words and the text parts from their transfer
+// encoding and charset: the first one is the body, or the HTML one if
+// there are several, and the rest are alternatives. The other parts,
+// wherever they are in the multipart tree, are attachments, with their
+// Content-ID if they are embedded in the body.
+//
+// Header fields the package writes itself, like Date or the Content-*
+// ones, set the matching fields of the message. The rest are kept in
+// Headers, except DKIM-Signature, which is no longer valid once the
+// message is written again.
+func Parse(r io.Reader) (*Message, error) {
+	br := bufio.NewReader(r)
+
+	header, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+	if err := m.setHeaders(header); err != nil {
+		return nil, err
+	}
+
+	p := &parser{m: m}
+	if err := p.part(header, br, false); err != nil {
+		return nil, err
+	}
+
+	// The HTML part is the body of messages with a plain text fallback.
+	if m.BodyContentType != "text/html" {
+		for i, alternative := range m.Alternatives {
+			if alternative.ContentType == "text/html" {
+				body := &Alternative{ContentType: m.BodyContentType, Body: m.Body, Charset: m.Charset}
+				m.BodyContentType, m.Body = alternative.ContentType, alternative.Body
+				m.Alternatives[i] = body
+				break
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// readHeader reads the header of a message or part up to the blank line
+// that ends it, keeping the fields in order and unfolding their values.
+func readHeader(r *bufio.Reader) (Headers, error) {
+	var h Headers
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				return h, nil
+			}
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return h, nil
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(h) == 0 {
+				return nil, fmt.Errorf("malformed header line %q", line)
+			}
+			h[len(h)-1].Value += line
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed header line %q", line)
+		}
+
+		h.Add(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:]))
+	}
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if CharsetReader == nil {
+		return nil, errors.New("can't decode charset " + charset)
+	}
+
+	return CharsetReader(charset, input)
+}
+
+// setHeaders sets the fields of the message from the header fields of the
+// top level entity.
+func (m *Message) setHeaders(header Headers) error {
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+
+	var err error
+	address := func(key string) Address {
+		value := header.Get(key)
+		if value == "" || err != nil {
+			return Address{}
+		}
+
+		a, e := parser.Parse(value)
+		if e != nil {
+			err = fmt.Errorf("invalid %s header %q: %v", key, value, e)
+			return Address{}
+		}
+
+		return Address{Name: a.Name, Email: a.Address}
+	}
+
+	list := func(key string) []Address {
+		value := header.Get(key)
+		if value == "" || err != nil {
+			return nil
+		}
+
+		list, e := parser.ParseList(value)
+		if e != nil {
+			err = fmt.Errorf("invalid %s header %q: %v", key, value, e)
+			return nil
+		}
+
+		addresses := make([]Address, len(list))
+		for i, a := range list {
+			addresses[i] = Address{Name: a.Name, Email: a.Address}
+		}
+
+		return addresses
+	}
+
+	m.From = address("From")
+	m.Sender = address("Sender")
+	m.To = list("To")
+	m.Cc = list("Cc")
+	m.Bcc = list("Bcc")
+	m.ReplyTo = list("Reply-To")
+
+	for _, a := range list("Disposition-Notification-To") {
+		m.ReadReceiptTo = append(m.ReadReceiptTo, a.String())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.ReturnPath = header.Get("Return-Path")
+	m.MessageID = header.Get("Message-Id")
+	m.InReplyTo = header.Get("In-Reply-To")
+	m.References = strings.Fields(header.Get("References"))
+
+	m.Subject = header.Get("Subject")
+	if subject, err := wordDecoder.DecodeHeader(m.Subject); err == nil {
+		m.Subject = subject
+	}
+
+	if date, err := mail.ParseDate(header.Get("Date")); err == nil {
+		m.Date = date
+	}
+
+	if priority := header.Get("X-Priority"); priority != "" {
+		switch priority[0] {
+		case '1', '2':
+			m.Priority = PriorityHigh
+		case '4', '5':
+			m.Priority = PriorityLow
+		}
+	}
+
+	if value := header.Get("List-Unsubscribe"); value != "" {
+		for _, uri := range strings.Split(value, ",") {
+			m.ListUnsubscribe = append(m.ListUnsubscribe, strings.Trim(strings.TrimSpace(uri), "<>"))
+		}
+		m.ListUnsubscribeOneClick = strings.EqualFold(header.Get("List-Unsubscribe-Post"), "List-Unsubscribe=One-Click")
+	}
+
+	for _, field := range header {
+		if reservedHeaders[field.Key] || strings.HasPrefix(field.Key, "Content-") || field.Key == "Dkim-Signature" {
+			continue
+		}
+
+		m.Headers = append(m.Headers, field)
+	}
+
+	return nil
+}
+
+// parser walks the MIME tree of a message, setting the body, alternatives
+// and attachments of m from its leaves.
+type parser struct {
+	m       *Message
+	hasBody bool
+}
+
+// part parses a MIME entity with the given header and body. related is set
+// for the parts of a multipart/related entity after the first one, which
+// are embedded in it.
+func (p *parser) part(header Headers, body io.Reader, related bool) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 defaults to plain ASCII text.
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for i := 0; ; i++ {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := p.part(partHeaders(part), part, mediaType == "multipart/related" && i > 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+
+	// Some clients encode the filename as an RFC 2047 word, although it
+	// isn't allowed in parameters.
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	if strings.HasPrefix(mediaType, "text/") && !related && disposition != "attachment" && filename == "" {
+		return p.text(mediaType, params["charset"], data)
+	}
+
+	attachment := &Attachment{
+		Filename:    filename,
+		Data:        data,
+		ContentType: header.Get("Content-Type"),
+		ContentID:   strings.Trim(header.Get("Content-Id"), "<>"),
+	}
+	attachment.Inline = disposition == "inline" && attachment.ContentID == ""
+
+	if description, err := wordDecoder.DecodeHeader(header.Get("Content-Description")); err == nil {
+		attachment.Description = description
+	}
+
+	for _, field := range header {
+		if !reservedPartHeaders[field.Key] {
+			attachment.Headers = append(attachment.Headers, field)
+		}
+	}
+
+	p.m.Attachments = append(p.m.Attachments, attachment)
+
+	return nil
+}
+
+// text sets a text leaf as the body, or adds it as an alternative if the
+// body is already set.
+func (p *parser) text(mediaType, charset string, data []byte) error {
+	text, err := decodeCharset(charset, data)
+	if err != nil {
+		return err
+	}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+
+	// The text is sent again in its charset only if it can be encoded in it.
+	if _, ok := charsets[strings.ToLower(charset)]; !ok {
+		charset = ""
+	}
+
+	if !p.hasBody {
+		p.hasBody = true
+		p.m.Body, p.m.BodyContentType, p.m.Charset = text, mediaType, charset
+		return nil
+	}
+
+	alternative := &Alternative{ContentType: mediaType, Body: text}
+	if !strings.EqualFold(charset, p.m.Charset) {
+		alternative.Charset = charset
+	}
+	p.m.Alternatives = append(p.m.Alternatives, alternative)
+
+	return nil
+}
+
+// partHeaders returns the header of a part, sorted by key as the order
+// isn't kept by mime/multipart.
+func partHeaders(part *multipart.Part) Headers {
+	keys := make([]string, 0, len(part.Header))
+	for key := range part.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var h Headers
+	for _, key := range keys {
+		for _, value := range part.Header[key] {
+			h.Add(key, value)
+		}
+	}
+
+	return h
+}
+
+// decodeTransfer returns a reader of body decoded from its
+// Content-Transfer-Encoding.
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch TransferEncoding(strings.ToLower(strings.TrimSpace(encoding))) {
+	case QuotedPrintable:
+		return quotedprintable.NewReader(body)
+	case Base64:
+		return base64.NewDecoder(base64.StdEncoding, body)
+	default:
+		return body
+	}
+}
+
+// decodeCharset decodes text in charset to UTF-8, with CharsetReader for
+// the charsets other than UTF-8, US-ASCII and ISO-8859-1.
+func decodeCharset(charset string, data []byte) (string, error) {
+	switch {
+	case isUTF8(charset) || strings.EqualFold(charset, "us-ascii"):
+		return string(data), nil
+	case strings.EqualFold(charset, "iso-8859-1"):
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	}
+
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	decoded, err := ioutil.ReadAll(r)
+	return string(decoded), err
+}
@@ -0,0 +1,109 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const inbound = `Received: from mx.example.com by mx.example.org
+From: =?iso-8859-1?q?Jos=E9?= <jose@example.com>
+To: Alice <alice@example.org>, bob@example.org
+Subject: =?utf-8?b?Q2Fmw6kgcmVwb3J0?=
+Date: Mon, 2 Jan 2006 15:04:05 -0700
+Message-ID: <inbound@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=windows-1252
+Content-Transfer-Encoding: quoted-printable
+
+=93Hi=94 there
+--inner
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
+
+PHA+SGkgdGhlcmU8L3A+
+--inner--
+--outer
+Content-Type: application/pdf
+Content-Disposition: attachment; filename*=utf-8''informe%20a%C3%B1o.pdf
+Content-Transfer-Encoding: base64
+
+JVBERg==
+--outer
+Content-Type: text/plain; name="=?utf-8?q?notas_caf=C3=A9.txt?="
+Content-Disposition: attachment
+
+notes
+--outer
+Content-Type: message/rfc822
+
+From: carol@example.com
+Subject: original
+
+hello
+--outer--
+`
+
+func TestParse(t *testing.T) {
+	CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		data, _ := ioutil.ReadAll(input)
+		text := strings.NewReplacer("\x93", "“", "\x94", "”").Replace(string(data))
+		return strings.NewReader(text), nil
+	}
+	defer func() { CharsetReader = nil }()
+
+	m, err := Parse(strings.NewReader(inbound))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.From != (Address{Name: "José", Email: "jose@example.com"}) || len(m.To) != 2 || m.To[0].Name != "Alice" {
+		t.Fatalf("unexpected addresses: %+v %+v", m.From, m.To)
+	}
+
+	if m.Subject != "Café report" || m.MessageID != "<inbound@example.com>" || m.Date.Year() != 2006 {
+		t.Fatalf("unexpected headers: %q %q %v", m.Subject, m.MessageID, m.Date)
+	}
+
+	if m.Headers.Get("Received") == "" {
+		t.Fatal("expected the Received header to be kept")
+	}
+
+	if m.BodyContentType != "text/html" || m.Body != "<p>Hi there</p>" {
+		t.Fatalf("expected the HTML body: %q %q", m.BodyContentType, m.Body)
+	}
+
+	if len(m.Alternatives) != 1 || m.Alternatives[0].Body != "“Hi” there" || m.Alternatives[0].Charset != "" {
+		t.Fatalf("expected the decoded text alternative: %+v", m.Alternatives[0])
+	}
+
+	if len(m.Attachments) != 3 {
+		t.Fatalf("expected 3 attachments, got %d", len(m.Attachments))
+	}
+
+	if a := m.Attachment("informe año.pdf"); a == nil || !bytes.Equal(a.Data, []byte("%PDF")) {
+		t.Fatalf("expected the RFC 2231 filename: %+v", m.Attachments[0])
+	}
+
+	if a := m.Attachment("notas café.txt"); a == nil || string(a.Data) != "notes" {
+		t.Fatalf("expected the RFC 2047 filename: %+v", m.Attachments[1])
+	}
+
+	if a := m.Attachments[2]; a.ContentType != "message/rfc822" || !strings.Contains(string(a.Data), "Subject: original") {
+		t.Fatalf("expected the forwarded message: %+v", a)
+	}
+}
+
+func TestParseCharsetError(t *testing.T) {
+	if _, err := Parse(strings.NewReader(inbound)); err == nil {
+		t.Fatal("expected an error for windows-1252 without CharsetReader")
+	}
+}
@@ -0,0 +1,850 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OpenPGP (RFC 4880) packet tags and algorithm IDs.
+const (
+	pgpTagSignature = 2
+	pgpTagPublicKey = 6
+
+	pgpAlgorithmRSA   = 1
+	pgpAlgorithmECDSA = 19
+	pgpAlgorithmEdDSA = 22
+
+	pgpHashSHA256 = 8
+)
+
+var (
+	pgpOIDP256    = []byte{0x2A, 0x86, 0x48, 0xCE, 0x3D, 0x03, 0x01, 0x07}
+	pgpOIDEd25519 = []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0xDA, 0x47, 0x0F, 0x01}
+)
+
+// PGPSigner signs messages with PGP/MIME (RFC 3156), wrapping them in a
+// multipart/signed entity with a detached OpenPGP signature.
+//
+// The signature is made either by Key, an RSA, ECDSA P-256 or Ed25519 key
+// created at KeyCreated, which is needed to compute its fingerprint, or
+// by SignFunc, which allows using an external OpenPGP implementation:
+//
+//	signer := &email.PGPSigner{SignFunc: func(w io.Writer, message io.Reader) error {
+//		return openpgp.ArmoredDetachSign(w, entity, message, nil)
+//	}}
+type PGPSigner struct {
+	Key        crypto.Signer
+	KeyCreated time.Time
+
+	// SignFunc writes an ASCII armored detached signature of message to w
+	// using SHA-256.
+	SignFunc func(w io.Writer, message io.Reader) error
+}
+
+// Sign implements Signer.
+func (s *PGPSigner) Sign(entity []byte) ([]byte, error) {
+	signature := bytes.NewBuffer(nil)
+
+	switch {
+	case s.SignFunc != nil:
+		if err := s.SignFunc(signature, bytes.NewReader(entity)); err != nil {
+			return nil, err
+		}
+
+	case s.Key != nil:
+		packet, err := s.signaturePacket(entity, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		signature.WriteString(pgpArmor("PGP SIGNATURE", packet))
+
+	default:
+		return nil, errors.New("pgp: the signer has no key")
+	}
+
+	boundary := randomBoundary()
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256;\r\n boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+	buf.WriteString("Content-Description: OpenPGP digital signature\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+	buf.Write(toCRLF(signature.Bytes()))
+	buf.WriteString("\r\n--" + boundary + "--")
+
+	return buf.Bytes(), nil
+}
+
+// signaturePacket returns a version 4 binary signature packet of data.
+func (s *PGPSigner) signaturePacket(data []byte, now time.Time) ([]byte, error) {
+	publicKey, err := pgpPublicKeyPacket(s.Key.Public(), s.KeyCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := pgpFingerprint(publicKey)
+
+	created := make([]byte, 4)
+	binary.BigEndian.PutUint32(created, uint32(now.Unix()))
+
+	var hashed []byte
+	hashed = append(hashed, pgpSubpacket(2, created)...)
+	hashed = append(hashed, pgpSubpacket(33, append([]byte{4}, fingerprint...))...)
+
+	header := []byte{4, 0x00, publicKey[5], pgpHashSHA256, byte(len(hashed) >> 8), byte(len(hashed))}
+	header = append(header, hashed...)
+
+	trailer := []byte{4, 0xFF, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(header)))
+
+	h := sha256.New()
+	h.Write(data)
+	h.Write(header)
+	h.Write(trailer)
+	digest := h.Sum(nil)
+
+	var mpis []byte
+	switch s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		signature, err := s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		mpis = pgpMPI(signature)
+
+	case *ecdsa.PublicKey:
+		signature, err := s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &rs); err != nil {
+			return nil, err
+		}
+		mpis = append(pgpMPI(rs.R.Bytes()), pgpMPI(rs.S.Bytes())...)
+
+	case ed25519.PublicKey:
+		signature, err := s.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		mpis = append(pgpMPI(signature[:32]), pgpMPI(signature[32:])...)
+	}
+
+	unhashed := pgpSubpacket(16, fingerprint[12:])
+
+	body := append([]byte(nil), header...)
+	body = append(body, byte(len(unhashed)>>8), byte(len(unhashed)))
+	body = append(body, unhashed...)
+	body = append(body, digest[0], digest[1])
+	body = append(body, mpis...)
+
+	return pgpPacket(pgpTagSignature, body), nil
+}
+
+// pgpPublicKeyPacket returns the body of a version 4 public key packet.
+func pgpPublicKeyPacket(key crypto.PublicKey, created time.Time) ([]byte, error) {
+	body := []byte{4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(body[1:], uint32(created.Unix()))
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		body = append(body, pgpAlgorithmRSA)
+		body = append(body, pgpMPI(key.N.Bytes())...)
+		body = append(body, pgpMPI(big.NewInt(int64(key.E)).Bytes())...)
+
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("pgp: unsupported ECDSA curve")
+		}
+
+		point := make([]byte, 65)
+		point[0] = 4
+		key.X.FillBytes(point[1:33])
+		key.Y.FillBytes(point[33:])
+
+		body = append(body, pgpAlgorithmECDSA, byte(len(pgpOIDP256)))
+		body = append(body, pgpOIDP256...)
+		body = append(body, pgpMPI(point)...)
+
+	case ed25519.PublicKey:
+		body = append(body, pgpAlgorithmEdDSA, byte(len(pgpOIDEd25519)))
+		body = append(body, pgpOIDEd25519...)
+		body = append(body, pgpMPI(append([]byte{0x40}, key...))...)
+
+	default:
+		return nil, errors.New("pgp: unsupported key type")
+	}
+
+	return body, nil
+}
+
+// pgpFingerprint returns the version 4 fingerprint of a public key packet
+// body. Its last 8 bytes are the key ID.
+func pgpFingerprint(publicKey []byte) []byte {
+	h := sha1.New()
+	h.Write([]byte{0x99, byte(len(publicKey) >> 8), byte(len(publicKey))})
+	h.Write(publicKey)
+
+	return h.Sum(nil)
+}
+
+// pgpPacket returns a packet in the new format.
+func pgpPacket(tag byte, body []byte) []byte {
+	packet := []byte{0xC0 | tag}
+
+	switch n := len(body); {
+	case n < 192:
+		packet = append(packet, byte(n))
+	case n < 8384:
+		n -= 192
+		packet = append(packet, byte(n>>8)+192, byte(n))
+	default:
+		packet = append(packet, 0xFF, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(packet, body...)
+}
+
+func pgpSubpacket(t byte, data []byte) []byte {
+	return append([]byte{byte(len(data) + 1), t}, data...)
+}
+
+// pgpMPI encodes a big-endian unsigned integer as a multiprecision integer.
+func pgpMPI(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+
+	bits := len(b) * 8
+	if len(b) > 0 {
+		for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+			bits--
+		}
+	}
+
+	return append([]byte{byte(bits >> 8), byte(bits)}, b...)
+}
+
+// pgpArmor returns data in ASCII armor with the given block type.
+func pgpArmor(blockType string, data []byte) string {
+	crc := uint32(0xB704CE)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+
+	checksum := base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)})
+
+	return "-----BEGIN " + blockType + "-----\r\n\r\n" +
+		base64Lines(data) + "\r\n=" + checksum + "\r\n" +
+		"-----END " + blockType + "-----\r\n"
+}
+
+// OpenPGP packet tags and algorithm IDs used for encryption.
+const (
+	pgpTagPublicKeyEncryptedSessionKey = 1
+	pgpTagLiteralData                  = 11
+	pgpTagPublicSubkey                 = 14
+	pgpTagSymmetricallyEncryptedMDC    = 18
+	pgpTagModificationDetectionCode    = 19
+
+	pgpAlgorithmRSAEncryptOnly = 2
+	pgpAlgorithmECDH           = 18
+
+	pgpCipherAES128 = 7
+	pgpCipherAES256 = 9
+)
+
+var pgpOIDCurve25519 = []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+
+// PGPPublicKey is the encryption key of an OpenPGP certificate. RSA and
+// Curve25519 ECDH keys, the GnuPG default, are supported.
+type PGPPublicKey struct {
+	Fingerprint []byte
+
+	algorithm byte
+	rsa       *rsa.PublicKey
+	ecdh      *ecdh.PublicKey
+	kdfHash   byte
+	kdfCipher byte
+}
+
+// KeyID returns the key ID of the key, the last 8 bytes of its fingerprint.
+func (k *PGPPublicKey) KeyID() []byte {
+	return k.Fingerprint[len(k.Fingerprint)-8:]
+}
+
+// ReadPGPPublicKey reads an OpenPGP certificate, ASCII armored or binary,
+// and returns its encryption key: the first subkey that can encrypt or,
+// failing that, the primary key.
+func ReadPGPPublicKey(r io.Reader) (*PGPPublicKey, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		if data, err = pgpDearmor(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var primary, subkey *PGPPublicKey
+	var last *PGPPublicKey
+
+	for len(data) > 0 {
+		tag, body, rest, err := pgpReadPacket(data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+
+		switch tag {
+		case pgpTagPublicKey, pgpTagPublicSubkey:
+			key, err := parsePGPPublicKey(body)
+			if err != nil {
+				// Keys with unsupported algorithms can't be used but
+				// don't invalidate the others.
+				last = nil
+				continue
+			}
+
+			last = key
+			if tag == pgpTagPublicKey {
+				primary = key
+			} else if subkey == nil {
+				subkey = key
+			}
+
+		case pgpTagSignature:
+			// Drop keys whose binding signature says they can't encrypt.
+			if flags, ok := pgpKeyFlags(body); ok && last != nil && flags&0x0C == 0 {
+				if last == subkey {
+					subkey = nil
+				}
+				if last == primary {
+					primary = nil
+				}
+			}
+		}
+	}
+
+	switch {
+	case subkey != nil:
+		return subkey, nil
+	case primary != nil:
+		return primary, nil
+	default:
+		return nil, errors.New("pgp: no supported encryption key found")
+	}
+}
+
+func parsePGPPublicKey(body []byte) (*PGPPublicKey, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return nil, errors.New("pgp: unsupported public key version")
+	}
+
+	key := &PGPPublicKey{Fingerprint: pgpFingerprint(body), algorithm: body[5]}
+	material := body[6:]
+
+	switch key.algorithm {
+	case pgpAlgorithmRSA, pgpAlgorithmRSAEncryptOnly:
+		n, material, err := pgpReadMPI(material)
+		if err != nil {
+			return nil, err
+		}
+
+		e, _, err := pgpReadMPI(material)
+		if err != nil {
+			return nil, err
+		}
+
+		key.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	case pgpAlgorithmECDH:
+		if len(material) < 1 || len(material) < 1+int(material[0]) || !bytes.Equal(material[1:1+int(material[0])], pgpOIDCurve25519) {
+			return nil, errors.New("pgp: unsupported ECDH curve")
+		}
+		material = material[1+int(material[0]):]
+
+		point, material, err := pgpReadMPI(material)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(point) != 33 || point[0] != 0x40 {
+			return nil, errors.New("pgp: invalid Curve25519 point")
+		}
+
+		if key.ecdh, err = ecdh.X25519().NewPublicKey(point[1:]); err != nil {
+			return nil, err
+		}
+
+		if len(material) < 4 || material[0] != 3 {
+			return nil, errors.New("pgp: invalid ECDH KDF parameters")
+		}
+		key.kdfHash, key.kdfCipher = material[2], material[3]
+
+		if key.kdfHash != pgpHashSHA256 || (key.kdfCipher != pgpCipherAES128 && key.kdfCipher != pgpCipherAES256) {
+			return nil, errors.New("pgp: unsupported ECDH KDF parameters")
+		}
+
+	default:
+		return nil, errors.New("pgp: unsupported public key algorithm")
+	}
+
+	return key, nil
+}
+
+// pgpKeyFlags returns the key flags subpacket of a version 4 signature.
+func pgpKeyFlags(body []byte) (byte, bool) {
+	if len(body) < 6 || body[0] != 4 {
+		return 0, false
+	}
+
+	length := int(binary.BigEndian.Uint16(body[4:]))
+	if len(body) < 6+length {
+		return 0, false
+	}
+
+	for subpackets := body[6 : 6+length]; len(subpackets) > 0; {
+		n, size := 0, 0
+		switch first := int(subpackets[0]); {
+		case first < 192:
+			n, size = first, 1
+		case first < 255 && len(subpackets) > 1:
+			n, size = (first-192)<<8+int(subpackets[1])+192, 2
+		case len(subpackets) > 4:
+			n, size = int(binary.BigEndian.Uint32(subpackets[1:])), 5
+		default:
+			return 0, false
+		}
+
+		if n == 0 || len(subpackets) < size+n {
+			return 0, false
+		}
+
+		subpacket := subpackets[size : size+n]
+		if subpacket[0]&0x7F == 27 && len(subpacket) > 1 {
+			return subpacket[1], true
+		}
+
+		subpackets = subpackets[size+n:]
+	}
+
+	return 0, false
+}
+
+// pgpReadPacket reads a packet in the old or new format.
+func pgpReadPacket(data []byte) (tag byte, body, rest []byte, err error) {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return 0, nil, nil, errors.New("pgp: invalid packet")
+	}
+
+	var length, offset int
+
+	if data[0]&0x40 != 0 {
+		tag = data[0] & 0x3F
+		switch first := int(data[1]); {
+		case first < 192:
+			length, offset = first, 2
+		case first < 224 && len(data) > 2:
+			length, offset = (first-192)<<8+int(data[2])+192, 3
+		case first == 255 && len(data) > 5:
+			length, offset = int(binary.BigEndian.Uint32(data[2:])), 6
+		default:
+			return 0, nil, nil, errors.New("pgp: unsupported packet length")
+		}
+	} else {
+		tag = (data[0] >> 2) & 0x0F
+		switch data[0] & 3 {
+		case 0:
+			length, offset = int(data[1]), 2
+		case 1:
+			if len(data) < 3 {
+				return 0, nil, nil, errors.New("pgp: invalid packet")
+			}
+			length, offset = int(binary.BigEndian.Uint16(data[1:])), 3
+		case 2:
+			if len(data) < 5 {
+				return 0, nil, nil, errors.New("pgp: invalid packet")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[1:])), 5
+		default:
+			return 0, nil, nil, errors.New("pgp: unsupported packet length")
+		}
+	}
+
+	if length < 0 || len(data) < offset+length {
+		return 0, nil, nil, errors.New("pgp: truncated packet")
+	}
+
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+func pgpReadMPI(data []byte) (mpi, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("pgp: truncated MPI")
+	}
+
+	n := (int(binary.BigEndian.Uint16(data)) + 7) / 8
+	if len(data) < 2+n {
+		return nil, nil, errors.New("pgp: truncated MPI")
+	}
+
+	return data[2 : 2+n], data[2+n:], nil
+}
+
+// pgpDearmor returns the data of an ASCII armored block.
+func pgpDearmor(armored []byte) ([]byte, error) {
+	lines := strings.Split(strings.Replace(string(armored), "\r\n", "\n", -1), "\n")
+
+	var encoded strings.Builder
+	inBody := false
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN"):
+			continue
+		case strings.HasPrefix(line, "-----END"), strings.HasPrefix(line, "="):
+			return base64.StdEncoding.DecodeString(encoded.String())
+		case !inBody:
+			// Skip the armor headers up to the blank line.
+			inBody = line == ""
+		default:
+			encoded.WriteString(line)
+		}
+	}
+
+	return nil, errors.New("pgp: invalid armor")
+}
+
+// PGPKeyLookup finds the public key of a recipient address.
+type PGPKeyLookup interface {
+	LookupKey(address string) (*PGPPublicKey, error)
+}
+
+// PGPKeyLookupFunc adapts a function to a PGPKeyLookup.
+type PGPKeyLookupFunc func(address string) (*PGPPublicKey, error)
+
+func (f PGPKeyLookupFunc) LookupKey(address string) (*PGPPublicKey, error) {
+	return f(address)
+}
+
+// PGPKeyring is a local PGPKeyLookup of keys by address.
+type PGPKeyring map[string]*PGPPublicKey
+
+func (k PGPKeyring) LookupKey(address string) (*PGPPublicKey, error) {
+	if key, ok := k[strings.ToLower(address)]; ok {
+		return key, nil
+	}
+
+	return nil, errors.New("pgp: no key for " + address)
+}
+
+// WKD looks up keys with the OpenPGP Web Key Directory, which domains use
+// to publish the keys of their users over HTTPS.
+type WKD struct {
+	// Client is used for the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+func (w *WKD) LookupKey(address string) (*PGPPublicKey, error) {
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return nil, errors.New("pgp: invalid address " + address)
+	}
+
+	local, domain := address[:at], strings.ToLower(address[at+1:])
+
+	hash := sha1.Sum([]byte(strings.ToLower(local)))
+	hu := zbase32(hash[:]) + "?l=" + url.QueryEscape(local)
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// Try the advanced method first and fall back to the direct one.
+	var lastErr error
+	for _, u := range []string{
+		"https://openpgpkey." + domain + "/.well-known/openpgpkey/" + domain + "/hu/" + hu,
+		"https://" + domain + "/.well-known/openpgpkey/hu/" + hu,
+	} {
+		resp, err := client.Get(u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		key, err := func() (*PGPPublicKey, error) {
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("pgp: WKD lookup of %s: %s", address, resp.Status)
+			}
+
+			return ReadPGPPublicKey(io.LimitReader(resp.Body, 1<<20))
+		}()
+		if err == nil {
+			return key, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// zbase32 encodes data with the z-base-32 alphabet used by WKD.
+func zbase32(data []byte) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+	var out strings.Builder
+	var buffer, bits uint
+
+	for _, b := range data {
+		buffer = buffer<<8 | uint(b)
+		bits += 8
+
+		for bits >= 5 {
+			out.WriteByte(alphabet[(buffer>>(bits-5))&31])
+			bits -= 5
+		}
+	}
+
+	if bits > 0 {
+		out.WriteByte(alphabet[(buffer<<(5-bits))&31])
+	}
+
+	return out.String()
+}
+
+// PGPEncrypter encrypts messages with PGP/MIME so that only the
+// recipients can read them. Remember to include the sender to be able to
+// read sent mail.
+type PGPEncrypter struct {
+	Recipients []string
+	Keys       PGPKeyLookup
+}
+
+// Encrypt implements Encrypter.
+func (e *PGPEncrypter) Encrypt(entity []byte) ([]byte, error) {
+	if len(e.Recipients) == 0 {
+		return nil, errors.New("pgp: no recipients")
+	}
+
+	var keys []*PGPPublicKey
+	for _, recipient := range e.Recipients {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := e.Keys.LookupKey(address.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	message, err := pgpEncrypt(entity, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := randomBoundary()
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";\r\n boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	buf.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	buf.WriteString("Version: 1\r\n")
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	buf.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	buf.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	buf.WriteString(pgpArmor("PGP MESSAGE", message))
+	buf.WriteString("\r\n--" + boundary + "--")
+
+	return buf.Bytes(), nil
+}
+
+// pgpEncrypt returns an OpenPGP message with data encrypted with AES-256
+// and integrity protected, with a session key packet for each key.
+func pgpEncrypt(data []byte, keys []*PGPPublicKey) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+
+	var checksum uint16
+	for _, b := range sessionKey {
+		checksum += uint16(b)
+	}
+
+	payload := append([]byte{pgpCipherAES256}, sessionKey...)
+	payload = append(payload, byte(checksum>>8), byte(checksum))
+
+	var message []byte
+	for _, key := range keys {
+		packet, err := key.encryptSessionKey(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		message = append(message, pgpPacket(pgpTagPublicKeyEncryptedSessionKey, packet)...)
+	}
+
+	literal := pgpPacket(pgpTagLiteralData, append([]byte{'b', 0, 0, 0, 0, 0}, data...))
+
+	prefix := make([]byte, aes.BlockSize+2)
+	if _, err := rand.Read(prefix[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	copy(prefix[aes.BlockSize:], prefix[aes.BlockSize-2:aes.BlockSize])
+
+	plaintext := append(prefix, literal...)
+	plaintext = append(plaintext, 0xC0|pgpTagModificationDetectionCode, sha1.Size)
+	mdc := sha1.Sum(plaintext)
+	plaintext = append(plaintext, mdc[:]...)
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, 1+len(plaintext))
+	encrypted[0] = 1
+	cipher.NewCFBEncrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(encrypted[1:], plaintext)
+
+	return append(message, pgpPacket(pgpTagSymmetricallyEncryptedMDC, encrypted)...), nil
+}
+
+// encryptSessionKey returns the body of a public key encrypted session key
+// packet for the key.
+func (k *PGPPublicKey) encryptSessionKey(payload []byte) ([]byte, error) {
+	packet := append([]byte{3}, k.KeyID()...)
+	packet = append(packet, k.algorithm)
+
+	if k.rsa != nil {
+		encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, k.rsa, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(packet, pgpMPI(encrypted)...), nil
+	}
+
+	// ECDH as in RFC 6637: derive a key encryption key from an ephemeral
+	// shared secret and wrap the PKCS #5 padded payload with it.
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(k.ecdh)
+	if err != nil {
+		return nil, err
+	}
+
+	param := append([]byte{byte(len(pgpOIDCurve25519))}, pgpOIDCurve25519...)
+	param = append(param, pgpAlgorithmECDH, 3, 1, k.kdfHash, k.kdfCipher)
+	param = append(param, "Anonymous Sender    "...)
+	param = append(param, k.Fingerprint...)
+
+	h := sha256.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(shared)
+	h.Write(param)
+
+	kekLength := 16
+	if k.kdfCipher == pgpCipherAES256 {
+		kekLength = 32
+	}
+	kek := h.Sum(nil)[:kekLength]
+
+	padding := 8 - len(payload)%8
+	padded := append(append([]byte(nil), payload...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	wrapped, err := aesKeyWrap(kek, padded)
+	if err != nil {
+		return nil, err
+	}
+
+	packet = append(packet, pgpMPI(append([]byte{0x40}, ephemeral.PublicKey().Bytes()...))...)
+	packet = append(packet, byte(len(wrapped)))
+
+	return append(packet, wrapped...), nil
+}
+
+// aesKeyWrap wraps key with kek as in RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	r := make([][]byte, n)
+	for i := range r {
+		r[i] = append([]byte(nil), key[i*8:i*8+8]...)
+	}
+
+	a := []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	b := make([]byte, 16)
+
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b, a)
+			copy(b[8:], r[i])
+			block.Encrypt(b, b)
+
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i], b[8:])
+		}
+	}
+
+	return append(a, bytes.Join(r, nil)...), nil
+}
@@ -0,0 +1,221 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splitSigned returns the signed content and the signature part of a
+// multipart/signed message.
+func splitSigned(t *testing.T, data string) (signed, signature string) {
+	i := strings.Index(data, "boundary=")
+	if i == -1 {
+		t.Fatalf("expected a multipart message:\n%s", data)
+	}
+	boundary := data[i+len("boundary=") : i+strings.Index(data[i:], "\r\n")]
+
+	parts := strings.Split(data, "--"+boundary)
+	if len(parts) != 4 {
+		t.Fatalf("expected two parts:\n%s", data)
+	}
+
+	signed = strings.TrimSuffix(strings.TrimPrefix(parts[1], "\r\n"), "\r\n")
+	signature = parts[2][strings.Index(parts[2], "\r\n\r\n")+4:]
+
+	return signed, signature
+}
+
+// dearmor returns the data of an ASCII armored block.
+func dearmor(t *testing.T, armored string) []byte {
+	lines := strings.Split(armored, "\r\n")
+
+	var encoded string
+	for _, line := range lines[2:] {
+		if strings.HasPrefix(line, "=") {
+			break
+		}
+		encoded += line
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestPGPSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Signer = &PGPSigner{Key: key, KeyCreated: time.Now().Add(-time.Hour)}
+
+	data := string(m.Bytes())
+
+	if !strings.Contains(data, "Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256;") {
+		t.Fatalf("expected a PGP/MIME signed message:\n%s", data)
+	}
+
+	signed, armored := splitSigned(t, data)
+	if !strings.HasPrefix(armored, "-----BEGIN PGP SIGNATURE-----\r\n") {
+		t.Fatalf("expected an armored signature:\n%s", armored)
+	}
+
+	packet := dearmor(t, armored)
+
+	// New format signature packet with a two byte length.
+	if packet[0] != 0xC0|pgpTagSignature || packet[1] < 192 {
+		t.Fatalf("unexpected packet header % x", packet[:3])
+	}
+	body := packet[3:]
+
+	hashedLength := int(binary.BigEndian.Uint16(body[4:]))
+	header := body[:6+hashedLength]
+
+	trailer := []byte{4, 0xFF, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(header)))
+
+	h := sha256.New()
+	h.Write([]byte(signed))
+	h.Write(header)
+	h.Write(trailer)
+	digest := h.Sum(nil)
+
+	rest := body[len(header):]
+	rest = rest[2+int(binary.BigEndian.Uint16(rest)):]
+	if rest[0] != digest[0] || rest[1] != digest[1] {
+		t.Fatal("unexpected digest prefix")
+	}
+
+	signature := rest[4:]
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, signature); err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestPGPEncrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := pgpPublicKeyPacket(&key.PublicKey, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	armored := pgpArmor("PGP PUBLIC KEY BLOCK", pgpPacket(pgpTagPublicKey, body))
+
+	// Serve the key over WKD for the hash of "to".
+	var requested string
+	keys := &WKD{Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = r.URL.String()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(armored)),
+		}, nil
+	})}}
+
+	m := NewMessage("Hi", "this is the secret body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Name: "To", Email: "to@example.com"}}
+	m.Encrypter = &PGPEncrypter{Recipients: m.Tolist(), Keys: keys}
+
+	data := string(m.Bytes())
+
+	if requested != "https://openpgpkey.example.com/.well-known/openpgpkey/example.com/hu/"+zbase32(sha1Sum("to"))+"?l=to" {
+		t.Fatalf("unexpected WKD request %s", requested)
+	}
+
+	if strings.Contains(data, "secret") {
+		t.Fatalf("expected the body to be encrypted:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";") {
+		t.Fatalf("expected a PGP/MIME encrypted message:\n%s", data)
+	}
+
+	message := dearmor(t, data[strings.Index(data, "-----BEGIN PGP MESSAGE-----"):])
+
+	tag, pkesk, rest, err := pgpReadPacket(message)
+	if err != nil || tag != pgpTagPublicKeyEncryptedSessionKey {
+		t.Fatalf("expected a session key packet, got %d, %v", tag, err)
+	}
+
+	encryptedKey, _, err := pgpReadMPI(pkesk[10:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := rsa.DecryptPKCS1v15(rand.Reader, key, encryptedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload[0] != pgpCipherAES256 || len(payload) != 35 {
+		t.Fatalf("unexpected session key payload % x", payload)
+	}
+
+	tag, encrypted, _, err := pgpReadPacket(rest)
+	if err != nil || tag != pgpTagSymmetricallyEncryptedMDC {
+		t.Fatalf("expected an encrypted data packet, got %d, %v", tag, err)
+	}
+
+	block, err := aes.NewCipher(payload[1:33])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := make([]byte, len(encrypted)-1)
+	cipher.NewCFBDecrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(plaintext, encrypted[1:])
+
+	mdc := sha1.Sum(plaintext[:len(plaintext)-20])
+	if !bytes.Equal(mdc[:], plaintext[len(plaintext)-20:]) {
+		t.Fatal("invalid modification detection code")
+	}
+
+	tag, literal, _, err := pgpReadPacket(plaintext[aes.BlockSize+2:])
+	if err != nil || tag != pgpTagLiteralData {
+		t.Fatalf("expected a literal data packet, got %d, %v", tag, err)
+	}
+
+	if !bytes.Contains(literal, []byte("this is the secret body")) {
+		t.Fatalf("unexpected decrypted content:\n%s", literal)
+	}
+}
+
+func sha1Sum(s string) []byte {
+	sum := sha1.Sum([]byte(s))
+	return sum[:]
+}
+
+func TestZBase32(t *testing.T) {
+	// Example from the Web Key Directory draft.
+	if hu := zbase32(sha1Sum("joe.doe")); hu != "iy9q119eutrkn8s1mk4r39qejnbu3n5q" {
+		t.Fatalf("unexpected z-base-32 hash %s", hu)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// PinError is returned when the server presents a certificate chain that
+// doesn't match the TLSPins of the SMTPSender, as happens if a proxy or a
+// compromised CA intercepts the connection.
+type PinError struct {
+	ServerName string
+
+	// Keys are the public key pins of the certificates that the server
+	// presented, from the leaf, to be compared with the expected ones.
+	Keys []string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("smtp: the TLS certificate of %s doesn't match the pinned keys, it has %s", e.ServerName, strings.Join(e.Keys, ", "))
+}
+
+// PublicKeyPin returns the pin of the public key of the certificate for
+// TLSPins: the SHA-256 hash of its SubjectPublicKeyInfo, in base64, as
+// HPKP pins and
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// compute it.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// certificatePin returns the SHA-256 hash of the whole certificate, in
+// base64.
+func certificatePin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkPins checks that a certificate that the server presented matches
+// one of the TLSPins.
+func (s *SMTPSender) checkPins(cs tls.ConnectionState, serverName string) error {
+	var keys []string
+	for _, cert := range cs.PeerCertificates {
+		key := PublicKeyPin(cert)
+		for _, pin := range s.TLSPins {
+			if pin == key || pin == certificatePin(cert) {
+				return nil
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return &PinError{ServerName: serverName, Keys: keys}
+}
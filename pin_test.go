@@ -0,0 +1,53 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestTLSPins(t *testing.T) {
+	cert, pool := testTLS(t)
+	server := newTestServer(t)
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	s := &SMTPSender{
+		Addr:      server.Addr(),
+		StartTLS:  StartTLSRequired,
+		TLSConfig: &tls.Config{RootCAs: pool},
+		TLSPins:   []string{"cGlu", PublicKeyPin(cert.Leaf)},
+	}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.TLSPins = []string{certificatePin(cert.Leaf)}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.TLSPins = []string{"cGlu"}
+	var pinErr *PinError
+	if err := s.Send(testMessage()); !errors.As(err, &pinErr) || pinErr.ServerName != "127.0.0.1" || pinErr.Keys[0] != PublicKeyPin(cert.Leaf) {
+		t.Fatalf("expected a PinError, got %v", err)
+	}
+
+	if n := len(server.Messages()); n != 2 {
+		t.Fatalf("expected 2 messages, got %d", n)
+	}
+
+	// A pinned certificate doesn't need to be signed by a trusted CA.
+	logs := bytes.NewBuffer(nil)
+	s.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	s.TLSPins = []string{PublicKeyPin(cert.Leaf)}
+	s.ErrorLog = log.New(logs, "", 0)
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if logs.Len() != 0 {
+		t.Fatalf("unexpected warning: %s", logs)
+	}
+}
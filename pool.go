@@ -0,0 +1,228 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned when sending through a closed Pool.
+var ErrPoolClosed = errors.New("email: pool closed")
+
+// Pool sends messages concurrently over up to a number of sessions that
+// are kept open between messages, so that sending many messages doesn't
+// pay for connecting and authenticating each time. Sends wait for a
+// session when all of them are busy.
+//
+// The options must be set before the first send. A Pool must be closed
+// when it is no longer needed.
+type Pool struct {
+	// MaxIdleTime, if positive, is how long a session can be idle before it
+	// is closed, as many servers silently drop sessions that are idle for
+	// more than a minute. Idle sessions are closed as they expire, not
+	// only when they would be reused.
+	MaxIdleTime time.Duration
+
+	// MaxConnLifetime, if positive, is how long a session can be open
+	// before it is closed and another one is opened, so that the sessions
+	// follow changes of the DNS records of the server.
+	MaxConnLifetime time.Duration
+
+	// MaxMessages, if positive, is the number of messages sent over a
+	// session before it is closed and another one is opened, as some
+	// servers limit them.
+	MaxMessages int
+
+	sender *SMTPSender
+	slots  chan struct{}
+
+	mu     sync.Mutex
+	idle   []*poolSession
+	closed bool
+	done   chan struct{}
+}
+
+type poolSession struct {
+	client    *Client
+	messages  int
+	idleSince time.Time
+}
+
+// expired reports whether the session was idle or open for too long.
+func (p *Pool) expired(session *poolSession, now time.Time) bool {
+	if p.MaxIdleTime > 0 && now.Sub(session.idleSince) > p.MaxIdleTime {
+		return true
+	}
+
+	return p.MaxConnLifetime > 0 && now.Sub(session.client.openedAt()) > p.MaxConnLifetime
+}
+
+// NewPool returns a Pool of up to size sessions opened with the options
+// of s, which must not be modified after that.
+func NewPool(s *SMTPSender, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Pool{sender: s, slots: make(chan struct{}, size)}
+}
+
+// Send sends the message. See SendContext.
+func (p *Pool) Send(m *Message) error {
+	return p.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message over an idle session, or a new one if
+// there is none and the pool isn't full. It waits for a session to be
+// free until ctx is done.
+func (p *Pool) SendContext(ctx context.Context, m *Message) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	session, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = session.client.SendContext(ctx, m)
+	session.messages++
+	p.put(session)
+
+	return err
+}
+
+// pingIdleTime is how long a session can be idle before it is checked
+// with NOOP when it is reused, as the server may have dropped it.
+var pingIdleTime = time.Second
+
+// get returns an idle session, or a new one, closing those that were idle
+// for too long or that don't answer NOOP.
+func (p *Pool) get(ctx context.Context) (*poolSession, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		var expired []*poolSession
+		var session *poolSession
+		for len(p.idle) > 0 && session == nil {
+			last := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+
+			if p.expired(last, time.Now()) {
+				expired = append(expired, last)
+			} else {
+				session = last
+			}
+		}
+		p.mu.Unlock()
+
+		for _, s := range expired {
+			s.client.Close()
+		}
+
+		if session == nil {
+			return &poolSession{client: NewClient(p.sender)}, nil
+		}
+
+		if time.Since(session.idleSince) < pingIdleTime || session.client.healthy(ctx) {
+			return session, nil
+		}
+	}
+}
+
+// put returns the session to the pool, or closes it if it can't be reused.
+func (p *Pool) put(session *poolSession) {
+	if !session.client.open() {
+		return
+	}
+
+	p.mu.Lock()
+	session.idleSince = time.Now()
+	if p.closed || (p.MaxMessages > 0 && session.messages >= p.MaxMessages) || p.expired(session, session.idleSince) {
+		p.mu.Unlock()
+		session.client.Close()
+		return
+	}
+
+	p.idle = append(p.idle, session)
+
+	if p.done == nil && (p.MaxIdleTime > 0 || p.MaxConnLifetime > 0) {
+		p.done = make(chan struct{})
+		go p.closeExpired(p.done)
+	}
+	p.mu.Unlock()
+}
+
+// closeExpired closes the idle sessions as they expire, until done is
+// closed.
+func (p *Pool) closeExpired(done chan struct{}) {
+	interval := p.MaxIdleTime
+	if interval <= 0 || (p.MaxConnLifetime > 0 && p.MaxConnLifetime < interval) {
+		interval = p.MaxConnLifetime
+	}
+
+	if interval > 1 {
+		interval /= 2
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			var expired []*poolSession
+
+			p.mu.Lock()
+			idle := p.idle[:0]
+			for _, session := range p.idle {
+				if p.expired(session, now) {
+					expired = append(expired, session)
+				} else {
+					idle = append(idle, session)
+				}
+			}
+			p.idle = idle
+			p.mu.Unlock()
+
+			for _, session := range expired {
+				session.client.Close()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Close closes the idle sessions, and the busy ones when their sends end.
+// Later sends fail with ErrPoolClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, session := range idle {
+		if err := session.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
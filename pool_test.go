@@ -0,0 +1,140 @@
+package email
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	server := newTestServer(t)
+
+	p := NewPool(&SMTPSender{Addr: server.Addr()}, 2)
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Send(testMessage())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(server.Commands(), "EHLO"); n < 1 || n > 2 {
+		t.Fatalf("expected up to 2 sessions, got %d", n)
+	}
+
+	if n := len(server.Messages()); n != 10 {
+		t.Fatalf("expected 10 messages, got %d", n)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Send(testMessage()); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("expected ErrPoolClosed, got %v", err)
+	}
+}
+
+func TestPoolLimits(t *testing.T) {
+	server := newTestServer(t)
+
+	p := NewPool(&SMTPSender{Addr: server.Addr()}, 1)
+	p.MaxMessages = 2
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := p.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(server.Commands(), "EHLO"); n != 3 {
+		t.Fatalf("expected a session every 2 messages, got %d", n)
+	}
+
+	server = newTestServer(t)
+
+	p = NewPool(&SMTPSender{Addr: server.Addr()}, 1)
+	p.MaxIdleTime = 10 * time.Millisecond
+	defer p.Close()
+
+	if err := p.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := p.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(server.Commands(), "EHLO"); n != 2 {
+		t.Fatalf("expected the idle session to be closed, got %d", n)
+	}
+
+	// Idle sessions are closed as they expire.
+	time.Sleep(50 * time.Millisecond)
+	if n := count(server.Commands(), "QUIT"); n != 2 {
+		t.Fatalf("expected the idle sessions to be closed, got %d", n)
+	}
+
+	server = newTestServer(t)
+
+	p = NewPool(&SMTPSender{Addr: server.Addr()}, 1)
+	p.MaxConnLifetime = 30 * time.Millisecond
+	defer p.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := p.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := count(server.Commands(), "EHLO"); n < 2 {
+		t.Fatalf("expected the session to be replaced, got %d", n)
+	}
+}
+
+func TestPoolPing(t *testing.T) {
+	defer func(d time.Duration) { pingIdleTime = d }(pingIdleTime)
+	pingIdleTime = 0
+
+	server := newTestServer(t)
+
+	p := NewPool(&SMTPSender{Addr: server.Addr()}, 1)
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(server.Commands(), "NOOP"); n != 1 {
+		t.Fatalf("expected the idle session to be checked, got %d NOOP", n)
+	}
+
+	server.Drop()
+
+	if err := p.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := count(server.Commands(), "EHLO"); n != 2 {
+		t.Fatalf("expected a new session, got %d", n)
+	}
+}
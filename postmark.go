@@ -0,0 +1,202 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PostmarkSender sends messages with the email endpoint of the Postmark
+// API, over HTTPS.
+//
+// As with Postmark's SMTP service, the X-PM-Tag, X-PM-Message-Stream and
+// X-PM-Metadata-* headers of the message set its tag, message stream and
+// metadata instead of being sent. The metadata keys are in lower case:
+// Headers canonicalizes the header names, so X-PM-Metadata-Customer-ID
+// would otherwise give Customer-Id rather than the key as it was written.
+//
+// Postmark builds the MIME message itself, so the Signer, Encrypter,
+// DKIM, Charset and transfer encoding settings of the message don't apply.
+type PostmarkSender struct {
+	// ServerToken is the API token of the Postmark server.
+	ServerToken string
+
+	// MessageStream is the stream of the messages without an
+	// X-PM-Message-Stream header, the default transactional stream,
+	// "outbound", if empty.
+	MessageStream string
+
+	// Endpoint is the URL of the API, https://api.postmarkapp.com if
+	// empty.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// PostmarkError is returned by PostmarkSender when Postmark rejects a
+// message, with the error code of the Postmark API, like 406 for an
+// inactive recipient or 300 for an invalid message.
+type PostmarkError struct {
+	ErrorCode int
+	Message   string
+
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+}
+
+func (e *PostmarkError) Error() string {
+	return fmt.Sprintf("email: postmark: error %d: %s", e.ErrorCode, e.Message)
+}
+
+// Unwrap returns the error as an APIError.
+func (e *PostmarkError) Unwrap() error {
+	return &APIError{Provider: "postmark", StatusCode: e.StatusCode, Message: e.Message}
+}
+
+type postmarkMessage struct {
+	From          string               `json:"From"`
+	To            string               `json:"To,omitempty"`
+	Cc            string               `json:"Cc,omitempty"`
+	Bcc           string               `json:"Bcc,omitempty"`
+	ReplyTo       string               `json:"ReplyTo,omitempty"`
+	Subject       string               `json:"Subject"`
+	Tag           string               `json:"Tag,omitempty"`
+	HTMLBody      string               `json:"HtmlBody,omitempty"`
+	TextBody      string               `json:"TextBody,omitempty"`
+	Headers       []postmarkHeader     `json:"Headers,omitempty"`
+	Metadata      map[string]string    `json:"Metadata,omitempty"`
+	Attachments   []postmarkAttachment `json:"Attachments,omitempty"`
+	MessageStream string               `json:"MessageStream,omitempty"`
+}
+
+type postmarkHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// postmarkAttachment has the content encoded as base64, as encoding/json
+// does with byte slices.
+type postmarkAttachment struct {
+	Name        string `json:"Name"`
+	Content     []byte `json:"Content"`
+	ContentType string `json:"ContentType"`
+	ContentID   string `json:"ContentID,omitempty"`
+}
+
+type postmarkResponse struct {
+	ErrorCode int    `json:"ErrorCode"`
+	Message   string `json:"Message"`
+	MessageID string `json:"MessageID"`
+}
+
+// Send sends the message. See SendContext.
+func (s *PostmarkSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If
+// Postmark rejects it, the error is a PostmarkError.
+func (s *PostmarkSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithID(ctx, m)
+	return err
+}
+
+// SendWithID sends the message like SendContext and returns the ID that
+// Postmark assigned to it, which its bounces and webhooks include.
+func (s *PostmarkSender) SendWithID(ctx context.Context, m *Message) (string, error) {
+	payload, err := m.postmark()
+	if err != nil {
+		return "", err
+	}
+
+	if payload.MessageStream == "" {
+		payload.MessageStream = s.MessageStream
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.postmarkapp.com"
+	}
+
+	header := http.Header{
+		"Accept":                  {"application/json"},
+		"X-Postmark-Server-Token": {s.ServerToken},
+	}
+
+	var response postmarkResponse
+	err = postJSON(ctx, s.HTTPClient, "postmark", endpoint+"/email", header, payload, &response)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if json.Unmarshal([]byte(apiErr.Message), &response) == nil && response.ErrorCode != 0 {
+			return "", &PostmarkError{ErrorCode: response.ErrorCode, Message: response.Message, StatusCode: apiErr.StatusCode}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if response.ErrorCode != 0 {
+		return "", &PostmarkError{ErrorCode: response.ErrorCode, Message: response.Message, StatusCode: http.StatusOK}
+	}
+
+	return response.MessageID, nil
+}
+
+// postmark returns the payload of the email endpoint for the message.
+func (m *Message) postmark() (*postmarkMessage, error) {
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &postmarkMessage{
+		From:    m.From.String(),
+		To:      joinAddresses(m.To),
+		Cc:      joinAddresses(m.Cc),
+		Bcc:     joinAddresses(m.Bcc),
+		ReplyTo: joinAddresses(m.ReplyTo),
+		Subject: m.Subject,
+	}
+
+	for _, part := range m.apiParts() {
+		switch mediaType(part.ContentType) {
+		case "text/plain":
+			payload.TextBody = part.Body
+		case "text/html":
+			payload.HTMLBody = part.Body
+		}
+	}
+
+	for _, field := range m.apiHeaders() {
+		switch key := field.Key; {
+		case key == "X-Pm-Tag":
+			payload.Tag = field.Value
+		case key == "X-Pm-Message-Stream":
+			payload.MessageStream = field.Value
+		case strings.HasPrefix(key, "X-Pm-Metadata-"):
+			if payload.Metadata == nil {
+				payload.Metadata = make(map[string]string)
+			}
+			payload.Metadata[strings.ToLower(strings.TrimPrefix(key, "X-Pm-Metadata-"))] = field.Value
+		default:
+			payload.Headers = append(payload.Headers, postmarkHeader{Name: field.Key, Value: field.Value})
+		}
+	}
+
+	for _, a := range m.apiAttachments() {
+		attachment := postmarkAttachment{Name: a.Filename, Content: a.Data, ContentType: a.contentType()}
+		if a.ContentID != "" {
+			attachment.ContentID = "cid:" + a.ContentID
+		}
+		payload.Attachments = append(payload.Attachments, attachment)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,91 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPostmarkSender(t *testing.T) {
+	var payload postmarkMessage
+	var token string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Postmark-Server-Token")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, `{"To":"bob@example.com","ErrorCode":0,"Message":"OK","MessageID":"b7bc2f4a"}`)
+	}))
+	defer server.Close()
+
+	m := NewHTMLMessage("Hi", "<p>Hi</p>")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}, {Email: "carol@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.InlineBytes("logo.png", []byte("png"))
+	m.Attachments[0].ContentID = "logo"
+	m.Headers.Add("X-PM-Tag", "welcome")
+	m.Headers.Add("X-PM-Metadata-Customer-ID", "42")
+	m.Headers.Add("X-Campaign", "q3")
+	m.MessageID = "<1@example.com>"
+
+	s := &PostmarkSender{ServerToken: "token", MessageStream: "outbound", Endpoint: server.URL}
+	id, err := s.SendWithID(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "b7bc2f4a" || token != "token" {
+		t.Fatalf("unexpected request: %s %s", id, token)
+	}
+
+	expected := postmarkMessage{
+		From:          "Alice <alice@example.com>",
+		To:            "bob@example.com, carol@example.com",
+		Subject:       "Hi",
+		Tag:           "welcome",
+		HTMLBody:      "<p>Hi</p>",
+		TextBody:      "Hi",
+		Headers:       []postmarkHeader{{"Message-Id", "<1@example.com>"}, {"X-Campaign", "q3"}},
+		Metadata:      map[string]string{"customer-id": "42"},
+		Attachments:   []postmarkAttachment{{Name: "logo.png", Content: []byte("png"), ContentType: "image/png", ContentID: "cid:logo"}},
+		MessageStream: "outbound",
+	}
+	if !reflect.DeepEqual(payload, expected) {
+		t.Fatalf("unexpected payload:\n%+v\n%+v", payload, expected)
+	}
+}
+
+func TestPostmarkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, `{"ErrorCode":406,"Message":"You tried to send to a recipient that has been marked as inactive."}`)
+	}))
+	defer server.Close()
+
+	s := &PostmarkSender{Endpoint: server.URL}
+	err := s.Send(testMessage())
+
+	var postmarkErr *PostmarkError
+	if !errors.As(err, &postmarkErr) || postmarkErr.ErrorCode != 406 || postmarkErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a PostmarkError, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Provider != "postmark" {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+
+	m := testMessage()
+	m.To = nil
+	if err := s.Send(m); !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("expected ErrNoRecipients, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by a RateLimitedSender with NoWait when the
+// message would exceed the rate.
+var ErrRateLimited = errors.New("email: rate limit exceeded")
+
+// RateLimitedSender sends messages with another sender at up to Limit
+// messages every Interval, like the 14 messages per second of Amazon SES,
+// so that the provider doesn't throttle them. It is safe for concurrent
+// use, and the options must not be modified after the first send.
+type RateLimitedSender struct {
+	Sender Sender
+
+	// Limit is the number of messages sent every Interval, which is 1
+	// second if zero.
+	Limit    int
+	Interval time.Duration
+
+	// Burst is the number of messages that can be sent at once after a
+	// pause, Limit if zero.
+	Burst int
+
+	// NoWait fails with ErrRateLimited instead of waiting when the message
+	// would exceed the rate.
+	NoWait bool
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Send sends the message. See SendContext.
+func (r *RateLimitedSender) Send(m *Message) error {
+	return r.SendContext(context.Background(), m)
+}
+
+// SendContext waits until the message can be sent without exceeding the
+// rate, or until ctx is done, and sends it.
+func (r *RateLimitedSender) SendContext(ctx context.Context, m *Message) error {
+	wait, ok := r.reserve(time.Now())
+	if !ok {
+		return ErrRateLimited
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			r.cancel()
+			return ctx.Err()
+		}
+	}
+
+	return r.Sender.SendContext(ctx, m)
+}
+
+// reserve takes a token from the bucket and returns how long to wait for
+// it, or false with NoWait if there is none.
+func (r *RateLimitedSender) reserve(now time.Time) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	limit := r.Limit
+	if limit <= 0 {
+		limit = 1
+	}
+
+	burst := float64(r.Burst)
+	if burst <= 0 {
+		burst = float64(limit)
+	}
+
+	rate := float64(limit) / float64(interval)
+
+	if r.last.IsZero() {
+		r.tokens = burst
+	} else if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens += float64(elapsed) * rate
+		if r.tokens > burst {
+			r.tokens = burst
+		}
+	}
+	r.last = now
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0, true
+	}
+
+	if r.NoWait {
+		return 0, false
+	}
+
+	r.tokens--
+	return time.Duration(-r.tokens / rate), true
+}
+
+// cancel returns a token taken by a send that didn't happen.
+func (r *RateLimitedSender) cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens++
+}
@@ -0,0 +1,57 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedSender(t *testing.T) {
+	sent := 0
+	r := &RateLimitedSender{
+		Sender: SenderFunc(func(ctx context.Context, m *Message) error {
+			sent++
+			return nil
+		}),
+		Limit:    10,
+		Interval: time.Second,
+		Burst:    2,
+	}
+
+	now := time.Now()
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait, ok := r.reserve(now); !ok || wait.Round(time.Millisecond) != expected {
+			t.Fatalf("expected to wait %v for message %d, got %v", expected, i, wait)
+		}
+	}
+
+	// After a pause, the bucket is full again but not beyond the burst.
+	now = now.Add(time.Hour)
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		if wait, _ := r.reserve(now); wait.Round(time.Millisecond) != expected {
+			t.Fatalf("expected to wait %v for message %d, got %v", expected, i, wait)
+		}
+	}
+
+	r = &RateLimitedSender{Sender: r.Sender, Limit: 1, Interval: time.Hour}
+	if err := r.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := r.SendContext(ctx, testMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	r.NoWait = true
+	if err := r.Send(testMessage()); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	if sent != 1 {
+		t.Fatalf("expected 1 message to be sent, got %d", sent)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// RetrySender sends messages with another sender, trying again after a
+// delay that doubles each time when they fail with a transient error. See
+// Transient.
+//
+// A message may be delivered twice if the connection breaks after the
+// server accepted it but before it answered.
+type RetrySender struct {
+	// Sender sends each attempt, like an SMTPSender or a Pool.
+	Sender Sender
+
+	// MaxAttempts is the number of attempts, 3 if zero.
+	MaxAttempts int
+
+	// MinDelay is the delay before the second attempt, 1 second if zero.
+	// Each delay is twice the previous one, up to MaxDelay, 1 minute if
+	// zero, and then shortened by a random amount of up to half so that
+	// the retries of many messages don't happen at once.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+const (
+	defaultMaxAttempts = 3
+	defaultMinDelay    = time.Second
+	defaultMaxDelay    = time.Minute
+)
+
+// Attempt is a failed attempt to send a message.
+type Attempt struct {
+	Time time.Time
+	Err  error
+}
+
+// RetryError is returned by RetrySender when a message isn't sent, with
+// every attempt.
+type RetryError struct {
+	Attempts []Attempt
+}
+
+func (e *RetryError) Error() string {
+	messages := make([]string, len(e.Attempts))
+	for i, a := range e.Attempts {
+		messages[i] = a.Err.Error()
+	}
+
+	return fmt.Sprintf("email: %d attempts failed: %s", len(e.Attempts), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// Send sends the message. See SendContext.
+func (r *RetrySender) Send(m *Message) error {
+	return r.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, trying again while it fails with a
+// transient error, until MaxAttempts or ctx is done. If it isn't sent, the
+// error is a RetryError.
+func (r *RetrySender) SendContext(ctx context.Context, m *Message) error {
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	e := &RetryError{}
+	for {
+		err := r.Sender.SendContext(ctx, m)
+		if err == nil {
+			return nil
+		}
+
+		e.Attempts = append(e.Attempts, Attempt{Time: time.Now(), Err: err})
+		if len(e.Attempts) >= maxAttempts || !Transient(err) {
+			return e
+		}
+
+		timer := time.NewTimer(r.delay(len(e.Attempts)))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			e.Attempts = append(e.Attempts, Attempt{Time: time.Now(), Err: ctx.Err()})
+			return e
+		}
+	}
+}
+
+// delay returns how long to wait after the given number of attempts.
+func (r *RetrySender) delay(attempts int) time.Duration {
+	minDelay, maxDelay := r.MinDelay, r.MaxDelay
+	if minDelay <= 0 {
+		minDelay = defaultMinDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	delay := minDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay - time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// Transient reports whether a send that failed with err may succeed if it
+// is tried again later: the server answered with a 4xx code, an HTTP API
+// with a 429 or 5xx status, or the connection failed or timed out. Errors
+// with 5xx SMTP codes, invalid messages and canceled sends are permanent.
+func Transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code >= 400 && protocolErr.Code < 500
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
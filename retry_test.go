@@ -0,0 +1,106 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestRetrySender(t *testing.T) {
+	errs := []error{
+		&textproto.Error{Code: 451, Msg: "try again later"},
+		io.EOF,
+		nil,
+	}
+
+	attempts := 0
+	r := &RetrySender{
+		Sender: SenderFunc(func(ctx context.Context, m *Message) error {
+			attempts++
+			return errs[attempts-1]
+		}),
+		MinDelay: time.Millisecond,
+	}
+
+	if err := r.Send(testMessage()); err != nil || attempts != 3 {
+		t.Fatalf("expected the third attempt to succeed, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	errs = []error{io.EOF, &textproto.Error{Code: 550, Msg: "no such user"}}
+
+	var retryErr *RetryError
+	err := r.Send(testMessage())
+	if !errors.As(err, &retryErr) || len(retryErr.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %v", err)
+	}
+
+	var protocolErr *textproto.Error
+	if !errors.As(err, &protocolErr) || protocolErr.Code != 550 {
+		t.Fatalf("expected the last error, got %v", err)
+	}
+
+	attempts = 0
+	errs = []error{io.EOF, io.EOF, io.EOF, io.EOF}
+	if err := r.Send(testMessage()); !errors.As(err, &retryErr) || attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d: %v", attempts, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := &RetrySender{MinDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	limits := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, limit := range limits {
+		if d := r.delay(i + 1); d > limit || d < limit/2 {
+			t.Fatalf("expected a delay between %v and %v after %d attempts, got %v", limit/2, limit, i+1, d)
+		}
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	r := &RetrySender{
+		Sender: SenderFunc(func(ctx context.Context, m *Message) error {
+			return io.EOF
+		}),
+		MinDelay: time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := r.SendContext(ctx, testMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{&textproto.Error{Code: 421, Msg: "closing"}, true},
+		{&textproto.Error{Code: 554, Msg: "rejected"}, false},
+		{&TimeoutError{Op: "command", Err: &timeoutErr{}}, true},
+		{io.EOF, true},
+		{ErrMessageTooLarge, false},
+		{context.Canceled, false},
+		{&APIError{Provider: "sendgrid", StatusCode: 429}, true},
+		{&APIError{Provider: "sendgrid", StatusCode: 400}, false},
+	}
+
+	for _, test := range tests {
+		if got := Transient(test.err); got != test.transient {
+			t.Errorf("Transient(%v) = %v", test.err, got)
+		}
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
@@ -0,0 +1,52 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import "context"
+
+// Sender sends messages. It is implemented by SMTPSender, Client and Pool,
+// by the senders of HTTP APIs, like SendGridSender, and by the senders
+// that wrap others, like RetrySender, so that application code can send
+// through any of them, or through a fake in tests.
+//
+// Every sender also has a Send(m) method, which sends with
+// context.Background; the interface has SendContext so that they all
+// take a context.
+type Sender interface {
+	// SendContext sends the message, giving up if ctx is done first.
+	SendContext(ctx context.Context, m *Message) error
+}
+
+// SenderFunc is a function that sends messages, as a Sender.
+type SenderFunc func(ctx context.Context, m *Message) error
+
+// SendContext calls f(ctx, m).
+func (f SenderFunc) SendContext(ctx context.Context, m *Message) error {
+	return f(ctx, m)
+}
+
+// Send calls f with context.Background.
+func (f SenderFunc) Send(m *Message) error {
+	return f(context.Background(), m)
+}
+
+var (
+	_ Sender = (*SMTPSender)(nil)
+	_ Sender = (*Client)(nil)
+	_ Sender = (*Pool)(nil)
+	_ Sender = (*RetrySender)(nil)
+	_ Sender = (*RateLimitedSender)(nil)
+	_ Sender = (*SendGridSender)(nil)
+	_ Sender = (*SESSender)(nil)
+	_ Sender = (*MailgunSender)(nil)
+	_ Sender = (*PostmarkSender)(nil)
+	_ Sender = (*SparkPostSender)(nil)
+	_ Sender = (*GraphSender)(nil)
+	_ Sender = (*GmailSender)(nil)
+	_ Sender = (*SendmailSender)(nil)
+	_ Sender = (*FailoverSender)(nil)
+	_ Sender = (*BalancedSender)(nil)
+	_ Sender = (*DryRunSender)(nil)
+	_ Sender = (*FileSender)(nil)
+	_ Sender = (*MemorySender)(nil)
+)
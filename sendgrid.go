@@ -0,0 +1,167 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// SendGridSender sends messages with the mail/send endpoint of the
+// SendGrid v3 API, over HTTPS, for networks that block the SMTP ports.
+//
+// SendGrid builds the MIME message itself, so the Signer, Encrypter,
+// DKIM, Charset and transfer encoding settings of the message don't apply.
+type SendGridSender struct {
+	APIKey string
+
+	// Endpoint is the URL of the API, https://api.sendgrid.com/v3/mail/send
+	// if empty. Accounts in the EU use
+	// https://api.eu.sendgrid.com/v3/mail/send.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+const sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	ReplyToList      []sendGridAddress         `json:"reply_to_list,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// sendGridAttachment has the content encoded as base64, as encoding/json
+// does with byte slices.
+type sendGridAttachment struct {
+	Content     []byte `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// Send sends the message. See SendContext.
+func (s *SendGridSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If
+// SendGrid rejects it, the error is an APIError.
+func (s *SendGridSender) SendContext(ctx context.Context, m *Message) error {
+	payload, err := m.sendGrid()
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = sendGridEndpoint
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + s.APIKey}}
+
+	return postJSON(ctx, s.HTTPClient, "sendgrid", endpoint, header, payload, nil)
+}
+
+// sendGrid returns the payload of the mail/send endpoint for the message.
+func (m *Message) sendGrid() (*sendGridMessage, error) {
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// SendGrid requires a To address.
+	if len(m.To) == 0 {
+		return nil, errors.New("email: missing To address")
+	}
+
+	payload := &sendGridMessage{
+		Personalizations: []sendGridPersonalization{{
+			To:  sendGridAddresses(m.To),
+			Cc:  sendGridAddresses(m.Cc),
+			Bcc: sendGridAddresses(m.Bcc),
+		}},
+		From:    sendGridAddress{Email: m.From.Email, Name: m.From.Name},
+		Subject: m.Subject,
+	}
+
+	switch len(m.ReplyTo) {
+	case 0:
+	case 1:
+		payload.ReplyTo = &sendGridAddress{Email: m.ReplyTo[0].Email, Name: m.ReplyTo[0].Name}
+	default:
+		payload.ReplyToList = sendGridAddresses(m.ReplyTo)
+	}
+
+	// The parts are in the order SendGrid requires: text/plain first, then
+	// text/html.
+	for _, part := range m.apiParts() {
+		// SendGrid rejects empty parts.
+		if part.Body == "" {
+			continue
+		}
+		payload.Content = append(payload.Content, sendGridContent{Type: mediaType(part.ContentType), Value: part.Body})
+	}
+
+	for _, a := range m.apiAttachments() {
+		disposition := "attachment"
+		if a.Inline || a.ContentID != "" {
+			disposition = "inline"
+		}
+
+		payload.Attachments = append(payload.Attachments, sendGridAttachment{
+			Content:     a.Data,
+			Type:        mediaType(a.contentType()),
+			Filename:    a.Filename,
+			Disposition: disposition,
+			ContentID:   a.ContentID,
+		})
+	}
+
+	for _, field := range m.apiHeaders() {
+		if payload.Headers == nil {
+			payload.Headers = make(map[string]string)
+		}
+
+		if value, ok := payload.Headers[field.Key]; ok {
+			payload.Headers[field.Key] = value + ", " + field.Value
+		} else {
+			payload.Headers[field.Key] = field.Value
+		}
+	}
+
+	return payload, nil
+}
+
+func sendGridAddresses(addresses []Address) []sendGridAddress {
+	var list []sendGridAddress
+	for _, a := range addresses {
+		list = append(list, sendGridAddress{Email: a.Email, Name: a.Name})
+	}
+
+	return list
+}
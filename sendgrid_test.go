@@ -0,0 +1,83 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSendGridSender(t *testing.T) {
+	var payload map[string]interface{}
+	var auth string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	m := NewHTMLMessage("Hi", "<p>Hi</p>")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}}
+	m.Bcc = []Address{{Email: "audit@example.com"}}
+	m.ReplyTo = []Address{{Email: "support@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.AttachBytes("data.bin", []byte{0, 1, 2, 0xff})
+	m.Headers.Add("X-Campaign", "q3")
+	m.InReplyTo = "<1@example.com>"
+	m.MessageID = "<2@example.com>"
+
+	s := &SendGridSender{APIKey: "key", Endpoint: server.URL}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth != "Bearer key" {
+		t.Fatalf("expected the API key, got %q", auth)
+	}
+
+	expected := `{"attachments":[{"content":"AAEC/w==","disposition":"attachment","filename":"data.bin","type":"application/octet-stream"}],` +
+		`"content":[{"type":"text/plain","value":"Hi"},{"type":"text/html","value":"<p>Hi</p>"}],` +
+		`"from":{"email":"alice@example.com","name":"Alice"},` +
+		`"headers":{"In-Reply-To":"<1@example.com>","Message-Id":"<2@example.com>","X-Campaign":"q3"},` +
+		`"personalizations":[{"bcc":[{"email":"audit@example.com"}],"to":[{"email":"bob@example.com"}]}],` +
+		`"reply_to":{"email":"support@example.com"},"subject":"Hi"}`
+	var expectedPayload map[string]interface{}
+	if err := json.Unmarshal([]byte(expected), &expectedPayload); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(payload, expectedPayload) {
+		t.Fatalf("unexpected payload:\n%v\n%v", payload, expectedPayload)
+	}
+}
+
+func TestSendGridError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"errors":[{"message":"The from address does not match a verified Sender Identity.","field":"from"}]}`)
+	}))
+	defer server.Close()
+
+	s := &SendGridSender{APIKey: "key", Endpoint: server.URL}
+
+	var apiErr *APIError
+	if err := s.Send(testMessage()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Provider != "sendgrid" {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+
+	m := testMessage()
+	m.To = nil
+	m.Bcc = []Address{{Email: "bcc@example.com"}}
+	if err := s.Send(m); err == nil {
+		t.Fatal("expected an error without a To address")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// SendmailSender sends messages by piping them into a local MTA with a
+// sendmail compatible command, like that of Postfix or msmtp, which
+// queues and relays them.
+type SendmailSender struct {
+	// Path is the command, /usr/sbin/sendmail if empty.
+	Path string
+
+	// Args are the options of the command, -i if nil, so that a line with
+	// a single dot doesn't end the message. They are followed by -f with
+	// the envelope sender and by the recipients, so that the Bcc ones,
+	// which aren't in the headers, get it too.
+	Args []string
+}
+
+// Send sends the message. See SendContext.
+func (s *SendmailSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext runs the command with the message as its input, killing it
+// if ctx is done first. If it fails, the error has what it wrote to its
+// standard error.
+func (s *SendmailSender) SendContext(ctx context.Context, m *Message) error {
+	from, err := m.envelopeFrom()
+	if err != nil {
+		return err
+	}
+
+	to := m.Tolist()
+	if len(to) == 0 {
+		return ErrNoRecipients
+	}
+
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	raw := bytes.NewBuffer(nil)
+	if _, err := m.WriteTo(raw); err != nil {
+		return err
+	}
+
+	path := s.Path
+	if path == "" {
+		path = "/usr/sbin/sendmail"
+	}
+
+	args := s.Args
+	if args == nil {
+		args = []string{"-i"}
+	}
+	args = append(append(args[:len(args):len(args)], "-f", from, "--"), to...)
+
+	cmd := exec.CommandContext(ctx, path, args...)
+
+	// The local MTA expects lines that end with LF.
+	cmd.Stdin = bytes.NewReader(toLF(raw.Bytes()))
+
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("email: %s: %v: %s", path, err, msg)
+		}
+
+		return fmt.Errorf("email: %s: %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newFakeSendmail returns a command that writes its arguments and input
+// into dir, and fails if the input has the word fail.
+func newFakeSendmail(t *testing.T) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/input\n" +
+		"if grep -q fail " + dir + "/input; then echo 'fatal: no such user' >&2; exit 67; fi\n"
+
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, dir
+}
+
+func TestSendmailSender(t *testing.T) {
+	path, dir := newFakeSendmail(t)
+
+	m := testMessage()
+	m.Bcc = []Address{{Email: "bcc@example.com"}}
+
+	s := &SendmailSender{Path: path}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "-i -f from@example.com -- to@example.com bcc@example.com\n" {
+		t.Fatalf("unexpected arguments: %s", args)
+	}
+
+	input, _ := os.ReadFile(filepath.Join(dir, "input"))
+	if !strings.HasPrefix(string(input), "From: from@example.com\nTo: to@example.com\n") || strings.Contains(string(input), "\r") {
+		t.Fatalf("unexpected input:\n%s", input)
+	}
+}
+
+func TestSendmailError(t *testing.T) {
+	path, _ := newFakeSendmail(t)
+
+	m := testMessage()
+	m.Subject = "fail"
+
+	s := &SendmailSender{Path: path, Args: []string{"-oi"}}
+	err := s.Send(m)
+	if err == nil || !strings.Contains(err.Error(), "exit status 67: fatal: no such user") {
+		t.Fatalf("expected the error of the command, got %v", err)
+	}
+}
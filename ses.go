@@ -0,0 +1,155 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// SESSender sends messages with the SendEmail action of the Amazon SES v2
+// API, as raw messages, so that every feature of the message, like
+// attachments, DKIM or S/MIME, is kept. It signs the requests with the
+// credentials, instead of authenticating with SMTP credentials.
+type SESSender struct {
+	// Region is the AWS region of SES, like "us-east-1".
+	Region      string
+	Credentials AWSCredentials
+
+	// ConfigurationSet, if set, is the name of the configuration set of the
+	// messages, which sets how their events are published.
+	ConfigurationSet string
+
+	// Tags are the message tags of the messages, which the events of the
+	// configuration set include.
+	Tags map[string]string
+
+	// Endpoint is the URL of the API, https://email.REGION.amazonaws.com
+	// if empty.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+type sesRequest struct {
+	FromEmailAddress     string         `json:"FromEmailAddress,omitempty"`
+	Destination          sesDestination `json:"Destination"`
+	Content              sesContent     `json:"Content"`
+	ConfigurationSetName string         `json:"ConfigurationSetName,omitempty"`
+	EmailTags            []sesTag       `json:"EmailTags,omitempty"`
+}
+
+type sesDestination struct {
+	ToAddresses  []string `json:"ToAddresses,omitempty"`
+	CcAddresses  []string `json:"CcAddresses,omitempty"`
+	BccAddresses []string `json:"BccAddresses,omitempty"`
+}
+
+type sesContent struct {
+	Raw struct {
+		// Data is encoded as base64, as encoding/json does with byte
+		// slices.
+		Data []byte `json:"Data"`
+	} `json:"Raw"`
+}
+
+type sesTag struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// Send sends the message. See SendContext.
+func (s *SESSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If SES
+// rejects it, the error is an APIError.
+func (s *SESSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithID(ctx, m)
+	return err
+}
+
+// SendWithID sends the message like SendContext and returns the ID that
+// SES assigned to it, which its events and notifications include.
+func (s *SESSender) SendWithID(ctx context.Context, m *Message) (string, error) {
+	if s.Region == "" {
+		return "", errors.New("email: missing SES region")
+	}
+
+	m, err := m.apiMessage(true)
+	if err != nil {
+		return "", err
+	}
+
+	raw := bytes.NewBuffer(nil)
+	if _, err := m.WriteTo(raw); err != nil {
+		return "", err
+	}
+
+	// FromEmailAddress is the address of the From header, the one that SES
+	// checks to be verified; the bounce address is set by the MAIL FROM
+	// domain of the identity, not by the Return-Path.
+	request := &sesRequest{
+		FromEmailAddress: m.From.Email,
+		Destination: sesDestination{
+			ToAddresses:  addressEmails(m.To),
+			CcAddresses:  addressEmails(m.Cc),
+			BccAddresses: addressEmails(m.Bcc),
+		},
+		ConfigurationSetName: s.ConfigurationSet,
+	}
+	request.Content.Raw.Data = raw.Bytes()
+
+	names := make([]string, 0, len(s.Tags))
+	for name := range s.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		request.EmailTags = append(request.EmailTags, sesTag{Name: name, Value: s.Tags[name]})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://email." + s.Region + ".amazonaws.com"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signAWS(req, body, s.Credentials, "ses", s.Region, time.Now())
+
+	var response struct {
+		MessageID string `json:"MessageId"`
+	}
+	if err := doAPI(s.HTTPClient, req, "ses", &response); err != nil {
+		return "", err
+	}
+
+	return response.MessageID, nil
+}
+
+// addressEmails returns the email addresses, without the names.
+func addressEmails(addresses []Address) []string {
+	var emails []string
+	for _, a := range addresses {
+		emails = append(emails, a.Email)
+	}
+
+	return emails
+}
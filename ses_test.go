@@ -0,0 +1,89 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSESSender(t *testing.T) {
+	var request sesRequest
+	var auth, path string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("Authorization"), r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, `{"MessageId":"0100-abc"}`)
+	}))
+	defer server.Close()
+
+	m := testMessage()
+	m.Bcc = []Address{{Email: "audit@example.com"}}
+	m.ReturnPath = "bounces@example.com"
+
+	s := &SESSender{
+		Region:           "eu-west-1",
+		Credentials:      AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		ConfigurationSet: "transactional",
+		Tags:             map[string]string{"campaign": "q3", "app": "billing"},
+		Endpoint:         server.URL,
+	}
+
+	id, err := s.SendWithID(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "0100-abc" {
+		t.Fatalf("expected the message ID, got %q", id)
+	}
+
+	if path != "/v2/email/outbound-emails" || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/ses/aws4_request") {
+		t.Fatalf("expected a signed request, got %s %s", path, auth)
+	}
+
+	if request.FromEmailAddress != m.From.Email {
+		t.Fatalf("expected the From address, got %q", request.FromEmailAddress)
+	}
+
+	if !reflect.DeepEqual(request.Destination.BccAddresses, []string{"audit@example.com"}) || request.ConfigurationSetName != "transactional" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	if !reflect.DeepEqual(request.EmailTags, []sesTag{{"app", "billing"}, {"campaign", "q3"}}) {
+		t.Fatalf("unexpected tags %+v", request.EmailTags)
+	}
+
+	if raw := string(request.Content.Raw.Data); !strings.Contains(raw, "Subject: Hi\r\n") || strings.Contains(raw, "audit@") {
+		t.Fatalf("expected the raw message without Bcc:\n%s", raw)
+	}
+
+	m.To, m.Bcc = nil, nil
+	if _, err := s.SendWithID(context.Background(), m); !errors.Is(err, ErrNoRecipients) {
+		t.Fatalf("expected ErrNoRecipients, got %v", err)
+	}
+}
+
+func TestSESError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"message":"Email address is not verified."}`)
+	}))
+	defer server.Close()
+
+	s := &SESSender{Region: "us-east-1", Endpoint: server.URL}
+
+	var apiErr *APIError
+	if err := s.Send(testMessage()); !errors.As(err, &apiErr) || apiErr.Provider != "ses" || !strings.Contains(apiErr.Message, "not verified") {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+}
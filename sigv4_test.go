@@ -0,0 +1,34 @@
+package email
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestSignAWS checks the get-vanilla and post-x-www-form-urlencoded cases
+// of the AWS Signature Version 4 test suite.
+func TestSignAWS(t *testing.T) {
+	credentials := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signAWS(req, nil, credentials, "service", "us-east-1", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("unexpected signature:\n%s\n%s", auth, expected)
+	}
+
+	body := []byte("Param1=value1")
+	req, _ = http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signAWS(req, body, credentials, "service", "us-east-1", now)
+
+	expected = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Fatalf("unexpected signature:\n%s\n%s", auth, expected)
+	}
+}
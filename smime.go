@@ -0,0 +1,396 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEnvelopedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAES256CBC              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// contentInfo, signedData and signerInfo are the PKCS #7 (RFC 5652)
+// structures of a detached signature.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	IssuerAndSerial    issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttributes   asn1.RawValue `asn1:"optional"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// envelopedData and its parts are the PKCS #7 structures of a message
+// encrypted with a random key sent to each recipient under their RSA key.
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	IssuerAndSerial        issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SMIMESigner signs messages with S/MIME, wrapping them in a
+// multipart/signed entity with a detached PKCS #7 signature.
+type SMIMESigner struct {
+	Certificate *x509.Certificate
+
+	// Intermediates are sent along with the certificate so recipients can
+	// build the chain to a trusted root.
+	Intermediates []*x509.Certificate
+
+	// Key is the private key of the certificate. RSA and ECDSA keys are
+	// supported.
+	Key crypto.Signer
+}
+
+// NewSMIMESigner returns a signer for the PEM encoded certificate, followed
+// by any intermediates, and private key.
+func NewSMIMESigner(certPEM, keyPEM []byte) (*SMIMESigner, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("smime: no certificate found")
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMIMESigner{Certificate: certs[0], Intermediates: certs[1:], Key: key}, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1, PKCS #8 or EC private key.
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("smime: no private key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("smime: unsupported private key type")
+	}
+
+	return signer, nil
+}
+
+// Sign implements Signer.
+func (s *SMIMESigner) Sign(entity []byte) ([]byte, error) {
+	signature, err := s.signature(entity, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := randomBoundary()
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;\r\n boundary=" + boundary + "\r\n\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(entity)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	buf.WriteString(base64Lines(signature))
+	buf.WriteString("\r\n--" + boundary + "--")
+
+	return buf.Bytes(), nil
+}
+
+// signature returns the DER encoded PKCS #7 detached signature of content.
+func (s *SMIMESigner) signature(content []byte, now time.Time) ([]byte, error) {
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, errors.New("smime: unsupported private key type")
+	}
+
+	digest := sha256.Sum256(content)
+
+	var signed []attribute
+	for _, a := range []struct {
+		t     asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttributeContentType, oidData},
+		{oidAttributeMessageDigest, digest[:]},
+		{oidAttributeSigningTime, now.UTC()},
+	} {
+		attr, err := newAttribute(a.t, a.value)
+		if err != nil {
+			return nil, err
+		}
+
+		signed = append(signed, attr)
+	}
+
+	attributes, err := marshalAttributes(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded as a SET, although they
+	// are sent with an implicit [0] tag.
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(set)
+	signature, err := s.Key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []byte
+	for _, cert := range append([]*x509.Certificate{s.Certificate}, s.Intermediates...) {
+		certificates = append(certificates, cert.Raw...)
+	}
+
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerial: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: s.Certificate.RawIssuer},
+				SerialNumber: s.Certificate.SerialNumber,
+			},
+			DigestAlgorithm:    sha256Algorithm,
+			SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// marshalAttributes returns the content of a DER SET OF Attribute with the
+// given single valued attributes, sorted as DER requires.
+func marshalAttributes(attributes []attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attributes))
+
+	for i, a := range attributes {
+		var err error
+		if encoded[i], err = asn1.Marshal(a); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
+
+// newAttribute returns an attribute with a single value.
+func newAttribute(t asn1.ObjectIdentifier, value interface{}) (attribute, error) {
+	v, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+
+	return attribute{Type: t, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: v}}, nil
+}
+
+// SMIMEEncrypter encrypts messages with S/MIME so that only the owners of
+// the recipient certificates can read them. Only RSA certificates are
+// supported. Remember to include the sender's own certificate to be able
+// to read sent mail.
+type SMIMEEncrypter struct {
+	Recipients []*x509.Certificate
+}
+
+// Encrypt implements Encrypter.
+func (e *SMIMEEncrypter) Encrypt(entity []byte) ([]byte, error) {
+	envelope, err := e.envelope(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	buf.WriteString(base64Lines(envelope))
+
+	return buf.Bytes(), nil
+}
+
+// envelope returns the DER encoded PKCS #7 enveloped data of content,
+// encrypted with AES-256-CBC.
+func (e *SMIMEEncrypter) envelope(content []byte) ([]byte, error) {
+	if len(e.Recipients) == 0 {
+		return nil, errors.New("smime: no recipient certificates")
+	}
+
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	encrypted := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	var recipients []keyTransRecipientInfo
+	for _, cert := range e.Recipients {
+		public, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("smime: unsupported recipient key type for " + cert.Subject.CommonName)
+		}
+
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, public, key)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients = append(recipients, keyTransRecipientInfo{
+			IssuerAndSerial: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	ivParameter, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed, err := asn1.Marshal(envelopedData{
+		RecipientInfos: recipients,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParameter}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ed},
+	})
+}
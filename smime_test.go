@@ -0,0 +1,179 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, name string) (certPEM, keyPEM []byte, cert *x509.Certificate, key *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: name},
+		EmailAddresses: []string{name},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM, cert, key
+}
+
+func TestSMIMESign(t *testing.T) {
+	certPEM, keyPEM, cert, _ := testCertificate(t, "from@example.com")
+
+	signer, err := NewSMIMESigner(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AttachBytes("data.bin", []byte{1, 2, 3})
+	m.Signer = signer
+
+	data := string(m.Bytes())
+
+	i := strings.Index(data, "boundary=")
+	if i == -1 || !strings.Contains(data, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"") {
+		t.Fatalf("expected a multipart/signed message:\n%s", data)
+	}
+	boundary := data[i+len("boundary=") : i+strings.Index(data[i:], "\r\n")]
+
+	parts := strings.Split(data, "--"+boundary)
+	if len(parts) != 4 {
+		t.Fatalf("expected two parts:\n%s", data)
+	}
+
+	signed := strings.TrimSuffix(strings.TrimPrefix(parts[1], "\r\n"), "\r\n")
+	encoded := parts[2][strings.Index(parts[2], "\r\n\r\n")+4:]
+
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(strings.TrimSpace(encoded), "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("unexpected content type %v", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.SignerInfos[0]
+	if si.IssuerAndSerial.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatal("unexpected signer serial number")
+	}
+
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttributes.Bytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256(set)
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, hash[:], si.Signature); err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(signed))
+	if !bytes.Contains(si.SignedAttributes.Bytes, digest[:]) {
+		t.Fatal("the signed digest doesn't match the sent content")
+	}
+}
+
+func TestSMIMEEncrypt(t *testing.T) {
+	_, _, cert, key := testCertificate(t, "to@example.com")
+
+	m := NewMessage("Hi", "this is the secret body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Encrypter = &SMIMEEncrypter{Recipients: []*x509.Certificate{cert}}
+
+	data := string(m.Bytes())
+
+	if strings.Contains(data, "secret") {
+		t.Fatalf("expected the body to be encrypted:\n%s", data)
+	}
+
+	if !strings.Contains(data, "Content-Type: application/pkcs7-mime; smime-type=enveloped-data;") {
+		t.Fatalf("expected an enveloped-data message:\n%s", data)
+	}
+
+	encoded := data[strings.Index(data, "\r\n\r\n")+4:]
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(encoded, "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+
+	contentKey, err := rsa.DecryptPKCS1v15(rand.Reader, key, ed.RecipientInfos[0].EncryptedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := ed.EncryptedContentInfo.EncryptedContent.Bytes
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, content)
+	content = content[:len(content)-int(content[len(content)-1])]
+
+	if !bytes.HasPrefix(content, []byte("Content-Type: text/plain; charset=utf-8\r\n")) || !bytes.Contains(content, []byte("this is the secret body")) {
+		t.Fatalf("unexpected decrypted content:\n%s", content)
+	}
+}
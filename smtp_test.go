@@ -0,0 +1,697 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a minimal SMTP server that records the commands and
+// messages it receives.
+type testServer struct {
+	listener net.Listener
+
+	// tlsConfig, if set, makes the server offer STARTTLS.
+	tlsConfig *tls.Config
+
+	// replies overrides the reply to the commands that begin with a key,
+	// like "RCPT TO:<bad".
+	replies map[string]string
+
+	// challenges are the 334 challenges, in base64, that the server sends
+	// for each AUTH mechanism before accepting it.
+	challenges map[string][]string
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	commands []string
+	messages []string
+	tls      bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serveTest(t, l)
+}
+
+// newImplicitTLSTestServer returns a server that expects TLS from the start
+// of the connection.
+func newImplicitTLSTestServer(t *testing.T, config *tls.Config) *testServer {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := serveTest(t, l)
+	s.tls = true
+
+	return s
+}
+
+func serveTest(t *testing.T, l net.Listener) *testServer {
+	s := &testServer{listener: l, replies: make(map[string]string), challenges: make(map[string][]string)}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *testServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	// The message sent in BDAT chunks.
+	var bdat strings.Builder
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		if custom, ok := s.reply(line); ok {
+			reply(custom)
+			continue
+		}
+
+		switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+		case "EHLO":
+			extensions := []string{"250-localhost", "250-8BITMIME", "250-AUTH PLAIN"}
+			if s.tlsConfig != nil {
+				if _, ok := conn.(*tls.Conn); !ok {
+					extensions = append(extensions, "250-STARTTLS")
+				}
+			}
+			reply(strings.Join(extensions, "\r\n") + "\r\n250 SMTPUTF8")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.tls = true
+			s.mu.Unlock()
+			conn, r = tlsConn, bufio.NewReader(tlsConn)
+		case "AUTH":
+			fields := strings.Fields(line)
+			for _, challenge := range s.challenge(fields[1]) {
+				reply("334 " + challenge)
+				response, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				s.mu.Lock()
+				s.commands = append(s.commands, strings.TrimRight(response, "\r\n"))
+				s.mu.Unlock()
+			}
+			reply("235 authenticated")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "BDAT":
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[1])
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			bdat.Write(chunk)
+			if len(fields) > 2 && fields[2] == "LAST" {
+				s.mu.Lock()
+				s.messages = append(s.messages, bdat.String())
+				s.mu.Unlock()
+				bdat.Reset()
+			}
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *testServer) reply(line string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for prefix, reply := range s.replies {
+		if strings.HasPrefix(line, prefix) {
+			return reply, true
+		}
+	}
+
+	return "", false
+}
+
+func (s *testServer) challenge(mechanism string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.challenges[mechanism]
+}
+
+func (s *testServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// Drop closes the open connections, as servers do with idle sessions.
+func (s *testServer) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) TLS() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tls
+}
+
+func (s *testServer) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.messages...)
+}
+
+// testTLS returns a certificate for 127.0.0.1 and a pool that trusts it.
+func testTLS(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func testMessage() *Message {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	return m
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := newTestServer(t)
+
+	s := &SMTPSender{Addr: server.Addr()}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := server.Messages(); len(messages) != 1 || !strings.Contains(messages[0], "Subject: Hi\r\n") {
+		t.Fatalf("expected the message: %q", messages)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, pool := testTLS(t)
+
+	server := newTestServer(t)
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	s := &SMTPSender{Addr: server.Addr(), StartTLS: StartTLSRequired, TLSConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !server.TLS() || len(server.Messages()) != 1 {
+		t.Fatal("expected the message to be sent over TLS")
+	}
+
+	// The certificate isn't trusted by the system.
+	s.TLSConfig = nil
+	if err := s.Send(testMessage()); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected a STARTTLS error, got %v", err)
+	}
+}
+
+func TestStartTLSRequired(t *testing.T) {
+	server := newTestServer(t)
+
+	s := &SMTPSender{Addr: server.Addr(), StartTLS: StartTLSRequired}
+	if err := s.Send(testMessage()); !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Fatalf("expected ErrStartTLSUnsupported, got %v", err)
+	}
+
+	if len(server.Messages()) != 0 {
+		t.Fatal("expected no message to be sent")
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	cert, pool := testTLS(t)
+	server := newImplicitTLSTestServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	s := &SMTPSender{Addr: server.Addr(), ImplicitTLS: true, StartTLS: StartTLSRequired, TLSConfig: &tls.Config{RootCAs: pool}}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.Messages()) != 1 {
+		t.Fatal("expected the message to be sent")
+	}
+
+	// The certificate isn't trusted by the system.
+	s = &SMTPSender{Addr: server.Addr(), ImplicitTLS: true}
+	if err := s.Send(testMessage()); err == nil {
+		t.Fatal("expected an error for an untrusted certificate")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert, pool := testTLS(t)
+	server := newImplicitTLSTestServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	s := &SMTPSender{Addr: server.Addr(), ImplicitTLS: true, TLSConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.TLSConfig = &tls.Config{RootCAs: pool}
+	if err := s.Send(testMessage()); err == nil {
+		t.Fatal("expected an error without a client certificate")
+	}
+
+	if len(server.Messages()) != 1 {
+		t.Fatal("expected only the message with a client certificate to be sent")
+	}
+}
+
+func TestInsecureSkipVerify(t *testing.T) {
+	cert, _ := testTLS(t)
+	server := newTestServer(t)
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	logs := bytes.NewBuffer(nil)
+	s := &SMTPSender{Addr: server.Addr(), TLSConfig: &tls.Config{InsecureSkipVerify: true}, ErrorLog: log.New(logs, "", 0)}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs.String(), "isn't verified") {
+		t.Fatalf("expected a warning, got %q", logs.String())
+	}
+}
+
+// newSilentServer returns the address of a server that accepts
+// connections but never answers.
+func newSilentServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestTimeouts(t *testing.T) {
+	addr := newSilentServer(t)
+
+	s := &SMTPSender{Addr: addr, CommandTimeout: 50 * time.Millisecond}
+
+	var timeout *TimeoutError
+	if err := s.Send(testMessage()); !errors.As(err, &timeout) || timeout.Op != "command" {
+		t.Fatalf("expected a command timeout, got %v", err)
+	}
+
+	s = &SMTPSender{Addr: addr, Timeout: 50 * time.Millisecond}
+	if err := s.Send(testMessage()); !errors.As(err, &timeout) || timeout.Op != "send" || !timeout.Timeout() {
+		t.Fatalf("expected a send timeout, got %v", err)
+	}
+}
+
+func TestSendContext(t *testing.T) {
+	s := &SMTPSender{Addr: newSilentServer(t)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := s.SendContext(ctx, testMessage()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("expected the send to be aborted when the context was canceled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.SendContext(ctx, testMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	server := newTestServer(t)
+	s = &SMTPSender{Addr: server.Addr()}
+	if err := s.SendContext(ctx, testMessage()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if len(server.Commands()) != 0 {
+		t.Fatal("expected no connection with a done context")
+	}
+}
+
+func TestSendAll(t *testing.T) {
+	server := newTestServer(t)
+	server.replies["RCPT TO:<bad@"] = "550 no such user"
+
+	bad := testMessage()
+	bad.To = []Address{{Email: "bad@example.com"}}
+
+	invalid := testMessage()
+	invalid.From = Address{}
+
+	s := &SMTPSender{Addr: server.Addr()}
+	errs := s.SendAll([]*Message{testMessage(), bad, invalid, testMessage()})
+
+	if len(errs) != 4 || errs[0] != nil || errs[1] == nil || errs[2] == nil || errs[3] != nil {
+		t.Fatalf("expected the second and third messages to fail, got %v", errs)
+	}
+
+	commands := server.Commands()
+	if n := count(commands, "EHLO"); n != 1 {
+		t.Fatalf("expected one session, got %d", n)
+	}
+
+	if n := len(server.Messages()); n != 2 {
+		t.Fatalf("expected 2 messages, got %d", n)
+	}
+
+	if commands[len(commands)-1] != "QUIT" {
+		t.Fatalf("expected the session to end, got %q", commands)
+	}
+}
+
+func TestLocalName(t *testing.T) {
+	server := newTestServer(t)
+
+	s := &SMTPSender{Addr: server.Addr(), LocalName: "mail.example.com"}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if commands := server.Commands(); commands[0] != "EHLO mail.example.com" {
+		t.Fatalf("expected the local name, got %q", commands)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	server := newTestServer(t)
+
+	var dialed []string
+	s := &SMTPSender{
+		Addr: "smtp.example.com:25",
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, network, server.Addr())
+		},
+	}
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dialed) != 1 || dialed[0] != "smtp.example.com:25" || len(server.Messages()) != 1 {
+		t.Fatalf("expected the message to be sent through the dialer, dialed %q", dialed)
+	}
+
+	// A dialer that never connects times out.
+	s = &SMTPSender{
+		Addr:        "smtp.example.com:25",
+		DialTimeout: 10 * time.Millisecond,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	var timeout *TimeoutError
+	if err := s.Send(testMessage()); !errors.As(err, &timeout) || timeout.Op != "dial" {
+		t.Fatalf("expected a dial timeout, got %v", err)
+	}
+}
+
+func TestSMTPUTF8Parameter(t *testing.T) {
+	server := newTestServer(t)
+	s := &SMTPSender{Addr: server.Addr()}
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testMessage()
+	m.To = []Address{{Email: "用户@example.com"}}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var mails []string
+	for _, command := range server.Commands() {
+		if strings.HasPrefix(command, "MAIL FROM:") {
+			mails = append(mails, command)
+		}
+	}
+
+	if len(mails) != 2 || mails[0] != "MAIL FROM:<from@example.com> BODY=8BITMIME" || mails[1] != "MAIL FROM:<from@example.com> BODY=8BITMIME SMTPUTF8" {
+		t.Fatalf("expected SMTPUTF8 only for the UTF-8 recipient, got %q", mails)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	server := newTestServer(t)
+	server.replies["EHLO"] = "250-localhost\r\n250-8BITMIME\r\n250 PIPELINING"
+	server.replies["RCPT TO:<bad@"] = "550 no such user"
+
+	m := testMessage()
+	m.Cc = []Address{{Email: "cc@example.com"}}
+
+	c := NewClient(&SMTPSender{Addr: server.Addr()})
+	defer c.Close()
+
+	if err := c.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := server.Messages(); len(messages) != 1 || !strings.Contains(messages[0], "Subject: Hi\r\n") {
+		t.Fatalf("expected the message: %q", messages)
+	}
+
+	// The server accepts DATA after rejecting a recipient, so the session
+	// is closed to abandon the message.
+	m.Cc = []Address{{Email: "bad@example.com"}}
+	if err := c.Send(m); err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Fatalf("expected the recipient to be rejected, got %v", err)
+	}
+
+	if err := c.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(server.Messages()); n != 2 {
+		t.Fatalf("expected the message with a rejected recipient not to be sent, got %d messages", n)
+	}
+
+	if n := count(server.Commands(), "EHLO"); n != 2 {
+		t.Fatalf("expected a new session after the rejection, got %d", n)
+	}
+}
+
+func TestChunking(t *testing.T) {
+	server := newTestServer(t)
+	server.replies["EHLO"] = "250-localhost\r\n250-8BITMIME\r\n250-PIPELINING\r\n250 CHUNKING"
+
+	// Larger than a chunk, with lines that would be dot-stuffed after
+	// DATA.
+	m := testMessage()
+	m.AttachBytes("data.bin", bytes.Repeat([]byte("."), 2*bdatChunkSize))
+	m.Body = ".\r\n..\r\n"
+
+	s := &SMTPSender{Addr: server.Addr()}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := server.Commands()
+	if n := count(commands, "BDAT"); n < 3 {
+		t.Fatalf("expected the message in chunks, got %q", commands)
+	}
+
+	if count(commands, "DATA") != 0 || commands[len(commands)-2] != fmt.Sprintf("BDAT %d LAST", len(server.Messages()[0])%bdatChunkSize) {
+		t.Fatalf("expected the last chunk, got %q", commands)
+	}
+
+	if messages := server.Messages(); len(messages) != 1 || !strings.Contains(messages[0], "\r\n.\r\n..\r\n") || len(messages[0]) < 2*bdatChunkSize {
+		t.Fatal("expected the message without dot-stuffing")
+	}
+}
+
+type failingSigner struct{}
+
+func (failingSigner) Sign(entity []byte) ([]byte, error) {
+	return nil, errors.New("signing failed")
+}
+
+func TestSendWriteError(t *testing.T) {
+	for _, ehlo := range []string{"250 8BITMIME", "250-8BITMIME\r\n250 CHUNKING"} {
+		server := newTestServer(t)
+		server.replies["EHLO"] = "250-localhost\r\n" + ehlo
+
+		signed := testMessage()
+		signed.Signer = failingSigner{}
+
+		unencodable := testMessage()
+		unencodable.Charset = "ISO-8859-1"
+		unencodable.Body = "100 €"
+
+		s := &SMTPSender{Addr: server.Addr()}
+		c := NewClient(s)
+		defer c.Close()
+
+		for _, m := range []*Message{signed, unencodable} {
+			if err := s.Send(m); err == nil {
+				t.Fatalf("%s: expected an error", ehlo)
+			}
+			if err := c.Send(m); err == nil {
+				t.Fatalf("%s: expected an error", ehlo)
+			}
+		}
+
+		if messages := server.Messages(); len(messages) != 0 {
+			t.Fatalf("%s: expected no message to be delivered, got %q", ehlo, messages)
+		}
+
+		// The client opens a new session for the next message.
+		if err := c.Send(testMessage()); err != nil {
+			t.Fatal(err)
+		}
+		if len(server.Messages()) != 1 {
+			t.Fatalf("%s: expected the message to be sent", ehlo)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// SMTPError is returned when the server rejects a command, with the stage
+// of the session where it did, so that callers can tell a full mailbox
+// from failed credentials. It unwraps to the *textproto.Error of net/smtp.
+type SMTPError struct {
+	// Stage is the command that was rejected: "CONNECT" for the greeting
+	// of the server, "EHLO", "STARTTLS", "AUTH", "MAIL", "RCPT", "DATA",
+	// which includes the reply to the message, "BDAT", or "NOOP".
+	Stage string
+
+	// Code is the reply code, like 550.
+	Code int
+
+	// EnhancedCode is the enhanced status code of RFC 3463 that begins the
+	// reply, like "5.1.1", or empty if the server doesn't send them.
+	EnhancedCode string
+
+	// Message is the text of the reply, without the codes. The lines of
+	// multiline replies are separated by "\n".
+	Message string
+
+	// Recipient is the address of the rejected RCPT command.
+	Recipient string
+
+	err *textproto.Error
+}
+
+func (e *SMTPError) Error() string {
+	code := fmt.Sprint(e.Code)
+	if e.EnhancedCode != "" {
+		code += " " + e.EnhancedCode
+	}
+
+	return fmt.Sprintf("smtp: %s: %s %s", e.Stage, code, e.Message)
+}
+
+// Unwrap returns the error of net/smtp.
+func (e *SMTPError) Unwrap() error {
+	return e.err
+}
+
+// Reason classifies the rejection by its enhanced status code, so that
+// bounces can be handled without parsing the message:
+//
+//	var smtpErr *email.SMTPError
+//	if errors.As(err, &smtpErr) && smtpErr.Reason() == email.ReasonBadMailbox {
+//		unsubscribe(smtpErr.Recipient)
+//	}
+func (e *SMTPError) Reason() Reason {
+	return reason(e.EnhancedCode)
+}
+
+// Temporary reports whether the reply is a 4xx one, after which the
+// command may succeed if it is tried again later.
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// Reason is the kind of a rejection, from the subject and detail of its
+// enhanced status code.
+type Reason int
+
+const (
+	// ReasonUnknown is for replies without an enhanced status code, or
+	// with one that isn't classified.
+	ReasonUnknown Reason = iota
+
+	// ReasonBadMailbox is for addresses that don't exist or can't receive
+	// mail, like 5.1.1 or 5.2.1.
+	ReasonBadMailbox
+
+	// ReasonQuota is for mailboxes or systems that are full, like 4.2.2.
+	ReasonQuota
+
+	// ReasonPolicy is for messages that the security policy of the server
+	// rejects, like 5.7.1 for spam or 5.7.26 for failed DMARC checks.
+	ReasonPolicy
+
+	// ReasonContent is for messages whose content or size the server
+	// can't accept, like 5.3.4 or 5.6.0.
+	ReasonContent
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonBadMailbox:
+		return "bad mailbox"
+	case ReasonQuota:
+		return "quota"
+	case ReasonPolicy:
+		return "policy"
+	case ReasonContent:
+		return "content"
+	}
+
+	return "unknown"
+}
+
+// reason classifies an enhanced status code of RFC 3463.
+func reason(code string) Reason {
+	if code == "" {
+		return ReasonUnknown
+	}
+
+	// The class doesn't matter: a 4.2.2 mailbox is full too.
+	_, detail, _ := strings.Cut(code, ".")
+	subject, _, _ := strings.Cut(detail, ".")
+
+	switch subject {
+	case "1":
+		switch detail {
+		// Bad destination mailbox or system, invalid syntax, ambiguous,
+		// moved, or a domain that doesn't accept mail.
+		case "1.1", "1.2", "1.3", "1.4", "1.6", "1.10":
+			return ReasonBadMailbox
+		}
+	case "2":
+		switch detail {
+		case "2.1":
+			// The mailbox is disabled.
+			return ReasonBadMailbox
+		case "2.2":
+			return ReasonQuota
+		case "2.3":
+			// The message is larger than the mailbox accepts.
+			return ReasonContent
+		}
+	case "3":
+		switch detail {
+		case "3.1":
+			// The mail system is full.
+			return ReasonQuota
+		case "3.4":
+			return ReasonContent
+		}
+	case "6":
+		return ReasonContent
+	case "7":
+		return ReasonPolicy
+	}
+
+	return ReasonUnknown
+}
+
+// RecipientsError is returned when the server rejects some of the
+// recipients of a message, with the reply to each of them. Unless
+// SMTPSender.SendToAccepted is set, the message isn't sent to any of them.
+type RecipientsError struct {
+	// Accepted are the recipients that the server accepted, and Rejected
+	// the replies to those it didn't, in the order of the message.
+	Accepted []string
+	Rejected []*SMTPError
+
+	// Sent reports whether the message was sent to the Accepted
+	// recipients, with SendToAccepted.
+	Sent bool
+}
+
+func newRecipientsError(to []string, rejected []*SMTPError, sent bool) *RecipientsError {
+	e := &RecipientsError{Rejected: rejected, Sent: sent}
+	for _, addr := range to {
+		accepted := true
+		for _, r := range rejected {
+			accepted = accepted && r.Recipient != addr
+		}
+
+		if accepted {
+			e.Accepted = append(e.Accepted, addr)
+		}
+	}
+
+	return e
+}
+
+func (e *RecipientsError) Error() string {
+	messages := make([]string, len(e.Rejected))
+	for i, r := range e.Rejected {
+		messages[i] = fmt.Sprintf("<%s>: %d %s", r.Recipient, r.Code, r.Message)
+	}
+
+	msg := fmt.Sprintf("smtp: %d of %d recipients rejected: %s", len(e.Rejected), len(e.Rejected)+len(e.Accepted), strings.Join(messages, "; "))
+	if e.Sent {
+		msg += "; sent to the others"
+	}
+
+	return msg
+}
+
+// Unwrap returns the rejections, unless the message was sent, so that
+// senders like RetrySender don't send it again to the accepted
+// recipients.
+func (e *RecipientsError) Unwrap() []error {
+	if e.Sent {
+		return nil
+	}
+
+	errs := make([]error, len(e.Rejected))
+	for i, r := range e.Rejected {
+		errs[i] = r
+	}
+
+	return errs
+}
+
+// smtpError returns err as an SMTPError of the stage if it is a reply of
+// the server, or else err.
+func smtpError(stage string, err error) error {
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return err
+	}
+
+	var protocolErr *textproto.Error
+	if !errors.As(err, &protocolErr) {
+		return err
+	}
+
+	e := &SMTPError{Stage: stage, Code: protocolErr.Code, err: protocolErr}
+
+	lines := strings.Split(protocolErr.Msg, "\n")
+	for i, line := range lines {
+		code, text, _ := strings.Cut(line, " ")
+		if !isEnhancedCode(code) {
+			continue
+		}
+
+		if e.EnhancedCode == "" {
+			e.EnhancedCode = code
+		}
+		lines[i] = text
+	}
+	e.Message = strings.Join(lines, "\n")
+
+	return e
+}
+
+// isEnhancedCode reports whether s is an enhanced status code: a class of
+// 2, 4 or 5, and a subject and a detail of 1 to 3 digits.
+func isEnhancedCode(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || (parts[0] != "2" && parts[0] != "4" && parts[0] != "5") {
+		return false
+	}
+
+	for _, part := range parts[1:] {
+		if len(part) < 1 || len(part) > 3 || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+
+	return true
+}
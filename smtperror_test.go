@@ -0,0 +1,154 @@
+package email
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestSMTPError(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		server := newTestServer(t)
+		if pipelining {
+			server.replies["EHLO"] = "250-localhost\r\n250-8BITMIME\r\n250-AUTH PLAIN\r\n250 PIPELINING"
+		}
+		server.replies["RCPT TO:<full@"] = "452 4.2.2 Mailbox full"
+
+		m := testMessage()
+		m.To = []Address{{Email: "full@example.com"}}
+
+		s := &SMTPSender{Addr: server.Addr()}
+		err := s.Send(m)
+
+		var smtpErr *SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Stage != "RCPT" || smtpErr.Code != 452 ||
+			smtpErr.EnhancedCode != "4.2.2" || smtpErr.Message != "Mailbox full" || !smtpErr.Temporary() {
+			t.Fatalf("expected a RCPT error, got %#v", err)
+		}
+
+		if smtpErr.Error() != "smtp: RCPT: 452 4.2.2 Mailbox full" {
+			t.Fatalf("unexpected message: %s", smtpErr)
+		}
+
+		var protocolErr *textproto.Error
+		if !errors.As(err, &protocolErr) || protocolErr.Code != 452 {
+			t.Fatalf("expected a textproto.Error, got %v", err)
+		}
+	}
+}
+
+func TestSMTPErrorStages(t *testing.T) {
+	for _, test := range []struct {
+		prefix, stage string
+	}{
+		{"EHLO", "EHLO"},
+		{"AUTH", "AUTH"},
+		{"MAIL", "MAIL"},
+		{"DATA", "DATA"},
+	} {
+		server := newTestServer(t)
+		server.replies[test.prefix] = "554 5.7.1 Rejected"
+		if test.prefix == "EHLO" {
+			server.replies["HELO"] = "554 5.7.1 Rejected"
+		}
+
+		s := &SMTPSender{Addr: server.Addr(), Auth: smtp.PlainAuth("", "user", "password", "127.0.0.1")}
+		err := s.Send(testMessage())
+
+		var smtpErr *SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Stage != test.stage || smtpErr.Code != 554 {
+			t.Fatalf("%s: expected a %s error, got %v", test.prefix, test.stage, err)
+		}
+	}
+}
+
+func TestEnhancedCode(t *testing.T) {
+	err := smtpError("MAIL", &textproto.Error{Code: 550, Msg: "5.7.1 Message rejected\n5.7.1 See https://example.com/policy"})
+
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.EnhancedCode != "5.7.1" || smtpErr.Message != "Message rejected\nSee https://example.com/policy" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	err = smtpError("MAIL", &textproto.Error{Code: 550, Msg: "5.7 is not a code"})
+	if !errors.As(err, &smtpErr) || smtpErr.EnhancedCode != "" || smtpErr.Message != "5.7 is not a code" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func TestRecipientsError(t *testing.T) {
+	for _, pipelining := range []bool{false, true} {
+		server := newTestServer(t)
+		if pipelining {
+			server.replies["EHLO"] = "250-localhost\r\n250-8BITMIME\r\n250 PIPELINING"
+		}
+		server.replies["RCPT TO:<bad"] = "550 5.1.1 No such user"
+
+		m := testMessage()
+		m.Cc = []Address{{Email: "bad1@example.com"}, {Email: "cc@example.com"}, {Email: "bad2@example.com"}}
+
+		s := &SMTPSender{Addr: server.Addr()}
+		err := s.Send(m)
+
+		var recipientsErr *RecipientsError
+		if !errors.As(err, &recipientsErr) || recipientsErr.Sent || len(recipientsErr.Rejected) != 2 ||
+			recipientsErr.Rejected[1].Recipient != "bad2@example.com" || recipientsErr.Rejected[1].EnhancedCode != "5.1.1" ||
+			strings.Join(recipientsErr.Accepted, " ") != "to@example.com cc@example.com" {
+			t.Fatalf("expected a RecipientsError, got %v", err)
+		}
+
+		var smtpErr *SMTPError
+		if !errors.As(err, &smtpErr) || len(server.Messages()) != 0 {
+			t.Fatalf("expected the message not to be sent, got %v", err)
+		}
+
+		s.SendToAccepted = true
+		err = s.Send(m)
+		if !errors.As(err, &recipientsErr) || !recipientsErr.Sent || errors.As(err, &smtpErr) || Transient(err) {
+			t.Fatalf("expected a RecipientsError for a sent message, got %v", err)
+		}
+
+		if len(server.Messages()) != 1 {
+			t.Fatal("expected the message to be sent to the accepted recipients")
+		}
+
+		m.To, m.Cc = nil, []Address{{Email: "bad1@example.com"}}
+		if err := s.Send(m); !errors.As(err, &recipientsErr) || recipientsErr.Sent || len(server.Messages()) != 1 {
+			t.Fatalf("expected the message not to be sent, got %v", err)
+		}
+	}
+}
+
+func TestReason(t *testing.T) {
+	for code, expected := range map[string]Reason{
+		"":       ReasonUnknown,
+		"5.1.1":  ReasonBadMailbox,
+		"5.1.10": ReasonBadMailbox,
+		"5.2.1":  ReasonBadMailbox,
+		"4.2.2":  ReasonQuota,
+		"5.2.2":  ReasonQuota,
+		"4.3.1":  ReasonQuota,
+		"5.2.3":  ReasonContent,
+		"5.3.4":  ReasonContent,
+		"5.6.0":  ReasonContent,
+		"5.7.1":  ReasonPolicy,
+		"5.7.26": ReasonPolicy,
+		"4.4.1":  ReasonUnknown,
+		"5.1.7":  ReasonUnknown,
+	} {
+		if r := (&SMTPError{EnhancedCode: code}).Reason(); r != expected {
+			t.Errorf("%q: expected %v, got %v", code, expected, r)
+		}
+	}
+
+	server := newTestServer(t)
+	server.replies["RCPT TO:"] = "550 5.1.1 <to@example.com>: Recipient address rejected"
+
+	var smtpErr *SMTPError
+	err := (&SMTPSender{Addr: server.Addr()}).Send(testMessage())
+	if !errors.As(err, &smtpErr) || smtpErr.Reason() != ReasonBadMailbox || smtpErr.Reason().String() != "bad mailbox" {
+		t.Fatalf("expected a bad mailbox, got %v", err)
+	}
+}
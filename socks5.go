@@ -0,0 +1,236 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// socks5Errors are the replies of RFC 1928, section 6.
+var socks5Errors = []string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// dialProxy connects to the server through the SOCKS5 proxy, until ctx
+// is done.
+func (s *SMTPSender) dialProxy(ctx context.Context) (net.Conn, error) {
+	proxy, err := url.Parse(s.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy.Scheme != "socks5" && proxy.Scheme != "socks5h" {
+		return nil, fmt.Errorf("email: unsupported proxy scheme %q", proxy.Scheme)
+	}
+
+	addr := s.Addr
+	if proxy.Scheme == "socks5" {
+		if addr, err = s.resolveAddr(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := s.dial(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	err = socks5Connect(conn, addr, proxy.User)
+
+	// The goroutine must be done with the deadline before it is cleared,
+	// or it could set it on the connection that is returned.
+	close(done)
+	<-stopped
+
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// resolveAddr returns addr with its host resolved to an IP address, an
+// IPv4 one if it has any. With DialContext, the DNS queries are sent
+// through it too, so that they take the same route as the connections.
+func (s *SMTPSender) resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	resolver := net.DefaultResolver
+	if s.DialContext != nil {
+		resolver = &net.Resolver{PreferGo: true, Dial: s.DialContext}
+	}
+
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	ip := ips[0].IP
+	for _, a := range ips {
+		if a.IP.To4() != nil {
+			ip = a.IP
+			break
+		}
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// socks5Connect asks the proxy at the other end of conn to connect to
+// addr, authenticating with the username and password of user, if set, as
+// RFC 1929 describes.
+func socks5Connect(conn net.Conn, addr string, user *url.Userinfo) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("email: invalid port %q", port)
+	}
+
+	methods := []byte{0x00}
+	if user != nil {
+		methods = []byte{0x02}
+	}
+
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[0] != 5 {
+		return errors.New("email: the proxy isn't a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if user == nil {
+			return errors.New("email: the proxy requires a username and password")
+		}
+
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("email: the proxy username or password is too long")
+		}
+
+		request := []byte{1, byte(len(user.Username()))}
+		request = append(request, user.Username()...)
+		request = append(request, byte(len(password)))
+		request = append(request, password...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+
+		if reply[1] != 0 {
+			return errors.New("email: the proxy rejected the username and password")
+		}
+	default:
+		return errors.New("email: the proxy doesn't accept the authentication method")
+	}
+
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("email: host name too long: %s", host)
+		}
+		request = append(request, 3, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 1)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 4)
+		request = append(request, ip...)
+	}
+	request = append(request, byte(portNumber>>8), byte(portNumber))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// The reply ends with the address that the proxy bound, of a length
+	// that depends on its type.
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	if header[1] != 0 {
+		message := "unknown error"
+		if int(header[1]) < len(socks5Errors) {
+			message = socks5Errors[header[1]]
+		}
+		return fmt.Errorf("email: the proxy failed to connect to %s: %s", addr, message)
+	}
+
+	var size int
+	switch header[3] {
+	case 1:
+		size = net.IPv4len - 1
+	case 3:
+		size = int(header[4])
+	case 4:
+		size = net.IPv6len - 1
+	default:
+		return errors.New("email: invalid reply from the proxy")
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
@@ -0,0 +1,213 @@
+package email
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestProxy returns the address of a SOCKS5 proxy that requires the
+// username and password, and the addresses it connected to.
+func newTestProxy(t *testing.T, username, password string) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	targets := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				greeting := make([]byte, 2)
+				if _, err := io.ReadFull(conn, greeting); err != nil {
+					return
+				}
+				io.ReadFull(conn, make([]byte, greeting[1]))
+				conn.Write([]byte{5, 2})
+
+				// The username and password.
+				header := make([]byte, 2)
+				io.ReadFull(conn, header)
+				user := make([]byte, header[1])
+				io.ReadFull(conn, user)
+				io.ReadFull(conn, header[:1])
+				pass := make([]byte, header[0])
+				io.ReadFull(conn, pass)
+				if string(user) != username || string(pass) != password {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				request := make([]byte, 4)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+
+				var host string
+				switch request[3] {
+				case 1, 4:
+					ip := make(net.IP, 4*request[3])
+					io.ReadFull(conn, ip)
+					host = ip.String()
+				case 3:
+					size := make([]byte, 1)
+					io.ReadFull(conn, size)
+					name := make([]byte, size[0])
+					io.ReadFull(conn, name)
+					host = string(name)
+				default:
+					return
+				}
+				port := make([]byte, 2)
+				io.ReadFull(conn, port)
+
+				addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+				targets <- addr
+
+				target, err := net.Dial("tcp", strings.Replace(addr, "localhost", "127.0.0.1", 1))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+
+				conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return l.Addr().String(), targets
+}
+
+func TestProxy(t *testing.T) {
+	server := newTestServer(t)
+	proxy, targets := newTestProxy(t, "alice", "secret")
+
+	_, port, _ := net.SplitHostPort(server.Addr())
+	addr := net.JoinHostPort("localhost", port)
+
+	// socks5h leaves the name to the proxy, and socks5 resolves it.
+	s := &SMTPSender{Addr: addr, Proxy: "socks5h://alice:secret@" + proxy}
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if target := <-targets; target != addr {
+		t.Fatalf("expected the proxy to connect to %s, got %s", addr, target)
+	}
+
+	s.Proxy = "socks5://alice:secret@" + proxy
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if target := <-targets; target != net.JoinHostPort("127.0.0.1", port) {
+		t.Fatalf("expected the proxy to connect to the address of localhost, got %s", target)
+	}
+
+	if len(server.Messages()) != 2 {
+		t.Fatal("expected the messages to be sent through the proxy")
+	}
+
+	s.Proxy = "socks5://alice:wrong@" + proxy
+	if err := s.Send(testMessage()); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("expected the credentials to be rejected, got %v", err)
+	}
+
+	s.Proxy = "http://" + proxy
+	if err := s.Send(testMessage()); err == nil {
+		t.Fatal("expected an error for an unsupported proxy")
+	}
+}
+
+// serveDNS answers the DNS queries of the Go resolver on conn, a stream, with
+// 127.0.0.1 for the A queries and no records for the others.
+func serveDNS(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		size := make([]byte, 2)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		// The question ends with the root label and its type and class.
+		end := 12
+		for query[end] != 0 {
+			end += int(query[end]) + 1
+		}
+		end += 5
+
+		answers := byte(0)
+		if binary.BigEndian.Uint16(query[end-4:]) == 1 {
+			answers = 1
+		}
+
+		response := append([]byte{query[0], query[1], 0x81, 0x80, 0, 1, 0, answers, 0, 0, 0, 0}, query[12:end]...)
+		if answers > 0 {
+			response = append(response, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+		}
+
+		binary.BigEndian.PutUint16(size, uint16(len(response)))
+		if _, err := conn.Write(append(size, response...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestProxyResolver(t *testing.T) {
+	server := newTestServer(t)
+	proxy, targets := newTestProxy(t, "alice", "secret")
+
+	_, port, _ := net.SplitHostPort(server.Addr())
+
+	queries := make(chan string, 10)
+	s := &SMTPSender{
+		Addr:  net.JoinHostPort("mail.test", port),
+		Proxy: "socks5://alice:secret@" + proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if _, port, _ := net.SplitHostPort(addr); port == "53" {
+				queries <- addr
+				client, server := net.Pipe()
+				go serveDNS(server)
+				return client, nil
+			}
+
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	if err := s.Send(testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queries) == 0 {
+		t.Fatal("expected the name to be resolved through DialContext")
+	}
+
+	if target := <-targets; target != net.JoinHostPort("127.0.0.1", port) {
+		t.Fatalf("expected the proxy to connect to the resolved address, got %s", target)
+	}
+}
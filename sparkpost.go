@@ -0,0 +1,219 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+)
+
+// SparkPostSender sends messages as transmissions of the SparkPost API,
+// over HTTPS.
+//
+// By default SparkPost builds the MIME message itself, so the Signer,
+// Encrypter, DKIM, Charset and transfer encoding settings of the message
+// don't apply. With Raw, the message is sent as it is serialized, and
+// SparkPost only substitutes the {{...}} expressions of its text.
+type SparkPostSender struct {
+	APIKey string
+
+	// Raw sends the serialized message as the email_rfc822 content of the
+	// transmissions, so that every feature of the message is kept.
+	Raw bool
+
+	// SubstitutionData, if set, is the data of the {{...}} expressions
+	// of the subject and bodies, for every recipient.
+	SubstitutionData map[string]interface{}
+
+	// Endpoint is the URL of the API, https://api.sparkpost.com/api/v1
+	// if empty. Accounts in the EU use https://api.eu.sparkpost.com/api/v1.
+	Endpoint string
+
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+type sparkPostTransmission struct {
+	Recipients       []sparkPostRecipient   `json:"recipients"`
+	Content          sparkPostContent       `json:"content"`
+	SubstitutionData map[string]interface{} `json:"substitution_data,omitempty"`
+}
+
+type sparkPostRecipient struct {
+	Address          sparkPostAddress `json:"address"`
+	SubstitutionData interface{}      `json:"substitution_data,omitempty"`
+}
+
+type sparkPostAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+
+	// HeaderTo is the To header of the copies of Cc and Bcc recipients.
+	HeaderTo string `json:"header_to,omitempty"`
+}
+
+type sparkPostContent struct {
+	From         *sparkPostAddress     `json:"from,omitempty"`
+	Subject      string                `json:"subject,omitempty"`
+	ReplyTo      string                `json:"reply_to,omitempty"`
+	Text         string                `json:"text,omitempty"`
+	HTML         string                `json:"html,omitempty"`
+	Headers      map[string]string     `json:"headers,omitempty"`
+	Attachments  []sparkPostAttachment `json:"attachments,omitempty"`
+	InlineImages []sparkPostAttachment `json:"inline_images,omitempty"`
+	EmailRFC822  string                `json:"email_rfc822,omitempty"`
+}
+
+// sparkPostAttachment has the data encoded as base64, as encoding/json
+// does with byte slices. The name of inline images is their Content-ID.
+type sparkPostAttachment struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// Send sends the message. See SendContext.
+func (s *SparkPostSender) Send(m *Message) error {
+	return s.SendContext(context.Background(), m)
+}
+
+// SendContext sends the message, giving up if ctx is done first. If
+// SparkPost rejects it, the error is an APIError.
+func (s *SparkPostSender) SendContext(ctx context.Context, m *Message) error {
+	_, err := s.SendWithID(ctx, m)
+	return err
+}
+
+// SendWithID sends the message like SendContext and returns the ID of the
+// transmission, which its events include.
+func (s *SparkPostSender) SendWithID(ctx context.Context, m *Message) (string, error) {
+	return s.send(ctx, m, nil)
+}
+
+// SendTransmission sends the message to the recipients, instead of its To,
+// Cc and Bcc, in a single transmission, with the Data of each recipient as
+// its substitution data. It returns the ID of the transmission.
+//
+// Unless Raw is set, SparkPost sets the To header of each copy to its
+// recipient. With Raw, the headers of the message are sent as they are.
+func (s *SparkPostSender) SendTransmission(ctx context.Context, m *Message, recipients []Recipient) (string, error) {
+	if len(recipients) == 0 {
+		return "", ErrNoRecipients
+	}
+
+	return s.send(ctx, m, recipients)
+}
+
+func (s *SparkPostSender) send(ctx context.Context, m *Message, recipients []Recipient) (string, error) {
+	payload, err := s.transmission(m, recipients)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.sparkpost.com/api/v1"
+	}
+
+	header := http.Header{"Authorization": {s.APIKey}}
+
+	var response struct {
+		Results struct {
+			ID string `json:"id"`
+		} `json:"results"`
+	}
+	if err := postJSON(ctx, s.HTTPClient, "sparkpost", endpoint+"/transmissions", header, payload, &response); err != nil {
+		return "", err
+	}
+
+	return response.Results.ID, nil
+}
+
+// transmission returns the payload of the transmissions endpoint for the
+// message, sent to the recipients if there are any, or else to its To, Cc
+// and Bcc.
+func (s *SparkPostSender) transmission(m *Message, recipients []Recipient) (*sparkPostTransmission, error) {
+	m, err := m.apiMessage(recipients == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &sparkPostTransmission{SubstitutionData: s.SubstitutionData}
+
+	if recipients != nil {
+		for _, r := range recipients {
+			payload.Recipients = append(payload.Recipients, sparkPostRecipient{
+				Address:          sparkPostAddress{Email: r.Address.Email, Name: r.Address.Name},
+				SubstitutionData: r.Data,
+			})
+		}
+	} else {
+		// SparkPost sets the To header of each copy to its recipient,
+		// unless it is given.
+		headerTo := ""
+		if !s.Raw && len(m.To) > 0 {
+			headerTo = joinAddresses(m.To)
+		}
+
+		for _, a := range m.To {
+			payload.Recipients = append(payload.Recipients, sparkPostRecipient{Address: sparkPostAddress{Email: a.Email, Name: a.Name}})
+		}
+		for _, list := range [][]Address{m.Cc, m.Bcc} {
+			for _, a := range list {
+				payload.Recipients = append(payload.Recipients, sparkPostRecipient{Address: sparkPostAddress{Email: a.Email, Name: a.Name, HeaderTo: headerTo}})
+			}
+		}
+	}
+
+	if s.Raw {
+		raw := bytes.NewBuffer(nil)
+		if _, err := m.WriteTo(raw); err != nil {
+			return nil, err
+		}
+		payload.Content.EmailRFC822 = raw.String()
+
+		return payload, nil
+	}
+
+	content := &payload.Content
+	content.From = &sparkPostAddress{Email: m.From.Email, Name: m.From.Name}
+	content.Subject = m.Subject
+	content.ReplyTo = joinAddresses(m.ReplyTo)
+
+	for _, part := range m.apiParts() {
+		switch mediaType(part.ContentType) {
+		case "text/plain":
+			content.Text = part.Body
+		case "text/html":
+			content.HTML = part.Body
+		}
+	}
+
+	headers := m.apiHeaders()
+	if recipients == nil && len(m.Cc) > 0 {
+		headers.Add("Cc", joinAddresses(m.Cc))
+	}
+
+	for _, field := range headers {
+		if content.Headers == nil {
+			content.Headers = make(map[string]string)
+		}
+
+		if value, ok := content.Headers[field.Key]; ok {
+			content.Headers[field.Key] = value + ", " + field.Value
+		} else {
+			content.Headers[field.Key] = field.Value
+		}
+	}
+
+	for _, a := range m.apiAttachments() {
+		if a.ContentID != "" {
+			content.InlineImages = append(content.InlineImages, sparkPostAttachment{Name: a.ContentID, Type: a.contentType(), Data: a.Data})
+		} else {
+			content.Attachments = append(content.Attachments, sparkPostAttachment{Name: a.Filename, Type: a.contentType(), Data: a.Data})
+		}
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,106 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newSparkPostServer(t *testing.T, payload *map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/transmissions" || r.Header.Get("Authorization") != "key" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, payload); err != nil {
+			t.Error(err)
+		}
+		io.WriteString(w, `{"results":{"total_rejected_recipients":0,"total_accepted_recipients":2,"id":"11668787484950529"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestSparkPostSender(t *testing.T) {
+	var payload map[string]interface{}
+	server := newSparkPostServer(t, &payload)
+
+	m := NewHTMLMessage("Hi {{name}}", "<p>Hi</p>")
+	m.From = Address{Name: "Alice", Email: "alice@example.com"}
+	m.To = []Address{{Email: "bob@example.com"}}
+	m.Bcc = []Address{{Email: "carol@example.com"}}
+	m.AddAlternative("text/plain", "Hi")
+	m.AttachBytes("a.txt", []byte("a"))
+	m.Headers.Add("X-Campaign", "q3")
+	m.MessageID = "<1@example.com>"
+
+	s := &SparkPostSender{APIKey: "key", Endpoint: server.URL, SubstitutionData: map[string]interface{}{"name": "Bob"}}
+	id, err := s.SendWithID(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "11668787484950529" {
+		t.Fatalf("unexpected ID: %s", id)
+	}
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"recipients": [
+			{"address": {"email": "bob@example.com"}},
+			{"address": {"email": "carol@example.com", "header_to": "bob@example.com"}}
+		],
+		"content": {
+			"from": {"email": "alice@example.com", "name": "Alice"},
+			"subject": "Hi {{name}}",
+			"text": "Hi",
+			"html": "<p>Hi</p>",
+			"headers": {"Message-Id": "<1@example.com>", "X-Campaign": "q3"},
+			"attachments": [{"name": "a.txt", "type": "text/plain; charset=utf-8", "data": "YQ=="}]
+		},
+		"substitution_data": {"name": "Bob"}
+	}`), &expected)
+
+	if !reflect.DeepEqual(payload, expected) {
+		t.Fatalf("unexpected payload:\n%v\n%v", payload, expected)
+	}
+}
+
+func TestSparkPostRaw(t *testing.T) {
+	var payload map[string]interface{}
+	server := newSparkPostServer(t, &payload)
+
+	s := &SparkPostSender{APIKey: "key", Endpoint: server.URL, Raw: true}
+	id, err := s.SendTransmission(context.Background(), testMessage(), []Recipient{
+		{Address: Address{Email: "bob@example.com"}, Data: map[string]string{"name": "Bob"}},
+		{Address: Address{Email: "carol@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "11668787484950529" {
+		t.Fatalf("unexpected ID: %s", id)
+	}
+
+	recipients := payload["recipients"].([]interface{})
+	if len(recipients) != 2 || !reflect.DeepEqual(recipients[0], map[string]interface{}{
+		"address":           map[string]interface{}{"email": "bob@example.com"},
+		"substitution_data": map[string]interface{}{"name": "Bob"},
+	}) {
+		t.Fatalf("unexpected recipients: %v", recipients)
+	}
+
+	content := payload["content"].(map[string]interface{})
+	raw, _ := content["email_rfc822"].(string)
+	if len(content) != 1 || !strings.Contains(raw, "Subject: Hi\r\n") || !strings.Contains(raw, "this is the body") {
+		t.Fatalf("unexpected content: %v", content)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htemplate "html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	ttemplate "text/template"
+)
+
+// Templates renders messages from named templates. Each template has up to
+// three parts: the subject and the plain text body, rendered with
+// text/template, and the HTML body, rendered with html/template so that the
+// data is escaped for HTML.
+//
+// Partials are shared by all the templates, which include them with
+// {{template "name" .}}. A partial can also be a layout that frames the
+// blocks the templates define, like {{template "content" .}}: set Layout to
+// wrap every message in it, or call it from the template itself. MJML
+// partials, like _footer.mjml, are only seen by the .mjml bodies, and HTML
+// ones only by the .html bodies, so both can have the same name.
+//
+// Templates and partials can be added at any time, also while NewMessage
+// is called concurrently. Adding a partial recompiles the templates that
+// were already rendered on their next use, so that they include it.
+type Templates struct {
+	// Layout is the name of the partial that wraps the bodies of every
+	// message. Templates then only define the blocks it uses. Bodies
+	// without a layout for their type are rendered on their own.
+	Layout string
+
+	// MJML renders the .mjml bodies, which are filled with html/template
+	// first, into HTML.
+	MJML MJMLRenderer
+
+	// mu guards the partials, the sources and the compiled templates.
+	mu sync.Mutex
+
+	// text, html and mjml hold the partials of each type. Each message
+	// part is parsed in a clone of them, so that the blocks it defines
+	// don't replace those of other templates.
+	text *ttemplate.Template
+	html *htemplate.Template
+	mjml *htemplate.Template
+
+	sources  map[string]string
+	compiled map[string]executer
+}
+
+// executer is implemented by both text and HTML templates.
+type executer interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// Template file extensions, which tell the part a file is for: the
+// welcome.subject, welcome.txt and welcome.html files make the "welcome"
+// template. The HTML body can be written in MJML instead, in welcome.mjml.
+// Files whose name starts with partialPrefix, like _footer.html, are
+// partials.
+const (
+	subjectExt    = ".subject"
+	textExt       = ".txt"
+	htmlExt       = ".html"
+	mjmlExt       = ".mjml"
+	partialPrefix = "_"
+)
+
+// DefaultTemplates is the set of templates used by NewMessageFromTemplate.
+var DefaultTemplates = NewTemplates()
+
+// NewTemplates returns an empty set of templates.
+func NewTemplates() *Templates {
+	return &Templates{
+		text:     ttemplate.New(""),
+		html:     htemplate.New(""),
+		mjml:     htemplate.New(""),
+		sources:  make(map[string]string),
+		compiled: make(map[string]executer),
+	}
+}
+
+// Add adds the template name with the given subject, HTML and plain text
+// sources. Any of them can be empty, but not both bodies.
+func (t *Templates) Add(name, subject, html, text string) error {
+	if html == "" && text == "" {
+		return fmt.Errorf("template %q has no body", name)
+	}
+
+	return t.add(name, map[string]string{subjectExt: subject, htmlExt: html, textExt: text})
+}
+
+// AddPartial adds a partial, or layout, with the given HTML and plain text
+// sources, either of which can be empty.
+func (t *Templates) AddPartial(name, html, text string) error {
+	return t.add(partialPrefix+name, map[string]string{htmlExt: html, textExt: text})
+}
+
+func (t *Templates) add(name string, parts map[string]string) error {
+	for ext, src := range parts {
+		if src == "" {
+			continue
+		}
+
+		if err := t.parse(name+ext, src); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseFiles adds the templates in the named files. The extension of each
+// file, .subject, .txt, .html or .mjml, tells the part of the template it
+// has.
+func (t *Templates) ParseFiles(filenames ...string) error {
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		if err := t.parse(filepath.Base(filename), string(src)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseFS is like ParseFiles but reads the files matching the patterns from
+// fsys, as fs.Glob does, which makes it easy to use templates embedded in
+// the binary with embed.FS.
+func (t *Templates) ParseFS(fsys fs.FS, patterns ...string) error {
+	for _, pattern := range patterns {
+		filenames, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+
+		if len(filenames) == 0 {
+			return fmt.Errorf("pattern %q matches no files", pattern)
+		}
+
+		for _, filename := range filenames {
+			src, err := fs.ReadFile(fsys, filename)
+			if err != nil {
+				return err
+			}
+
+			if err := t.parse(path.Base(filename), string(src)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parse adds a partial to the set it is rendered with, as told by the
+// extension of its name, or checks the syntax of a message part and keeps
+// it to be compiled with the partials on first use. Either way, the
+// compiled templates it may change are discarded.
+func (t *Templates) parse(name, src string) error {
+	ext := path.Ext(name)
+	if ext != subjectExt && ext != textExt && ext != htmlExt && ext != mjmlExt {
+		return fmt.Errorf("template %q must have a .subject, .txt, .html or .mjml extension", name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if strings.HasPrefix(name, partialPrefix) {
+		partial := strings.TrimSuffix(strings.TrimPrefix(name, partialPrefix), ext)
+
+		var err error
+		if isMarkup(ext) {
+			_, err = t.markup(ext).New(partial).Parse(src)
+		} else {
+			_, err = t.text.New(partial).Parse(src)
+		}
+		if err != nil {
+			return err
+		}
+
+		t.compiled = make(map[string]executer)
+
+		return nil
+	}
+
+	if _, err := ttemplate.New(name).Parse(src); err != nil {
+		return err
+	}
+
+	t.sources[name] = src
+	delete(t.compiled, name)
+
+	return nil
+}
+
+// compile returns the message part name parsed in a clone of the partials.
+func (t *Templates) compile(name string) (executer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tmpl, ok := t.compiled[name]; ok {
+		return tmpl, nil
+	}
+
+	src, ok := t.sources[name]
+	if !ok {
+		return nil, nil
+	}
+
+	var tmpl executer
+	if ext := path.Ext(name); isMarkup(ext) {
+		clone, err := t.markup(ext).Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		if tmpl, err = clone.New(name).Parse(src); err != nil {
+			return nil, err
+		}
+	} else {
+		clone, err := t.text.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		if tmpl, err = clone.New(name).Parse(src); err != nil {
+			return nil, err
+		}
+	}
+
+	t.compiled[name] = tmpl
+
+	return tmpl, nil
+}
+
+// NewMessage renders the template name with data into a new message: an
+// HTML message with a plain text alternative if the template has both
+// bodies, or a message with the one it has.
+func (t *Templates) NewMessage(name string, data interface{}) (*Message, error) {
+	html, hasHTML, err := t.execute(name+htmlExt, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasHTML {
+		if html, hasHTML, err = t.executeMJML(name+mjmlExt, data); err != nil {
+			return nil, err
+		}
+	}
+
+	text, hasText, err := t.execute(name+textExt, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasHTML && !hasText {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+
+	subject, _, err := t.execute(name+subjectExt, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject can't span lines, which templates easily produce.
+	subject = strings.Join(strings.Fields(subject), " ")
+
+	if !hasHTML {
+		return NewMessage(subject, text), nil
+	}
+
+	m := NewHTMLMessage(subject, html)
+	if hasText {
+		m.AddAlternative("text/plain", text)
+	}
+
+	return m, nil
+}
+
+// execute renders the message part name, wrapped in the Layout if it has
+// one for the type of the part. It reports false if there is no such part.
+func (t *Templates) execute(name string, data interface{}) (string, bool, error) {
+	tmpl, err := t.compile(name)
+	if tmpl == nil || err != nil {
+		return "", false, err
+	}
+
+	entry := name
+	if ext := path.Ext(name); t.Layout != "" && ext != subjectExt && t.hasPartial(t.Layout, ext) {
+		entry = t.Layout
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.ExecuteTemplate(buf, entry, data); err != nil {
+		return "", true, err
+	}
+
+	return buf.String(), true, nil
+}
+
+// executeMJML renders the MJML part name and converts it into HTML.
+func (t *Templates) executeMJML(name string, data interface{}) (string, bool, error) {
+	mjml, ok, err := t.execute(name, data)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+
+	if t.MJML == nil {
+		return "", true, fmt.Errorf("template %q needs an MJML renderer", name)
+	}
+
+	html, err := t.MJML.RenderMJML(mjml)
+
+	return html, true, err
+}
+
+// isMarkup reports whether the template extension ext is for HTML or MJML,
+// which are rendered with html/template.
+func isMarkup(ext string) bool {
+	return ext == htmlExt || ext == mjmlExt
+}
+
+// markup returns the partials of the markup extension ext.
+func (t *Templates) markup(ext string) *htemplate.Template {
+	if ext == mjmlExt {
+		return t.mjml
+	}
+
+	return t.html
+}
+
+func (t *Templates) hasPartial(name, ext string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if isMarkup(ext) {
+		return t.markup(ext).Lookup(name) != nil
+	}
+
+	return t.text.Lookup(name) != nil
+}
+
+// NewMessageFromTemplate renders the template name of DefaultTemplates with
+// data into a new message. See Templates.NewMessage.
+func NewMessageFromTemplate(name string, data interface{}) (*Message, error) {
+	return DefaultTemplates.NewMessage(name, data)
+}
@@ -0,0 +1,147 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplates(t *testing.T) {
+	templates := NewTemplates()
+	err := templates.Add("welcome",
+		"Welcome,\n{{.Name}}!",
+		"<p>Hello {{.Name}}</p>",
+		"Hello {{.Name}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.NewMessage("welcome", map[string]string{"Name": "Tom & <Jerry>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "Welcome, Tom & <Jerry>!" {
+		t.Fatalf("expected a single line subject, got %q", m.Subject)
+	}
+
+	if m.BodyContentType != "text/html" || m.Body != "<p>Hello Tom &amp; &lt;Jerry&gt;</p>" {
+		t.Fatalf("expected an escaped HTML body, got %s %q", m.BodyContentType, m.Body)
+	}
+
+	if len(m.Alternatives) != 1 || m.Alternatives[0].Body != "Hello Tom & <Jerry>" {
+		t.Fatalf("expected an unescaped text alternative, got %+v", m.Alternatives)
+	}
+
+	if _, err := templates.NewMessage("missing", nil); err == nil {
+		t.Fatal("expected an error for a missing template")
+	}
+
+	if err := templates.Add("empty", "Subject", "", ""); err == nil {
+		t.Fatal("expected an error for a template without body")
+	}
+}
+
+func TestTemplateFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mail/reset.subject": {Data: []byte("Reset your password")},
+		"mail/reset.txt":     {Data: []byte("Go to {{.}}")},
+	}
+
+	templates := NewTemplates()
+	if err := templates.ParseFS(fsys, "mail/*"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "invite.html")
+	if err := os.WriteFile(file, []byte(`<a href="{{.}}">Join</a>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := templates.ParseFiles(file); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.NewMessage("reset", "https://example.com/reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "Reset your password" || m.BodyContentType != "text/plain" || m.Body != "Go to https://example.com/reset" {
+		t.Fatalf("unexpected message: %q %s %q", m.Subject, m.BodyContentType, m.Body)
+	}
+
+	m, err = templates.NewMessage("invite", "javascript:alert(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(m.Body, "#ZgotmplZ") || m.Subject != "" {
+		t.Fatalf("expected unsafe URLs to be filtered: %q", m.Body)
+	}
+
+	if err := templates.ParseFiles(filepath.Join(dir, "missing.html")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestTemplateLayouts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"_layout.html":    {Data: []byte(`<body>{{template "content" .}}{{template "footer" .}}</body>`)},
+		"_footer.html":    {Data: []byte(`<p>Sent to {{.}}</p>`)},
+		"_footer.txt":     {Data: []byte("--\nSent to {{.}}")},
+		"welcome.html":    {Data: []byte(`{{define "content"}}<h1>Welcome</h1>{{end}}`)},
+		"welcome.txt":     {Data: []byte("Welcome\n{{template \"footer\" .}}")},
+		"goodbye.html":    {Data: []byte(`{{define "content"}}<h1>Goodbye</h1>{{end}}`)},
+		"goodbye.subject": {Data: []byte("Goodbye")},
+	}
+
+	templates := NewTemplates()
+	templates.Layout = "layout"
+	if err := templates.ParseFS(fsys, "*"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := templates.NewMessage("welcome", "<tom@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Body != "<body><h1>Welcome</h1><p>Sent to &lt;tom@example.com&gt;</p></body>" {
+		t.Fatalf("expected the HTML body in the layout, got %q", m.Body)
+	}
+
+	if m.Alternatives[0].Body != "Welcome\n--\nSent to <tom@example.com>" {
+		t.Fatalf("expected the text body with the partial, got %q", m.Alternatives[0].Body)
+	}
+
+	m, err = templates.NewMessage("goodbye", "tom@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Subject != "Goodbye" || m.Body != "<body><h1>Goodbye</h1><p>Sent to tom@example.com</p></body>" {
+		t.Fatalf("expected each template to fill the layout with its own blocks, got %q %q", m.Subject, m.Body)
+	}
+
+	// Partials added after rendering are used from then on.
+	if err := templates.AddPartial("footer", "<p>Bye {{.}}</p>", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = templates.NewMessage("goodbye", "tom@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Body != "<body><h1>Goodbye</h1><p>Bye tom@example.com</p></body>" {
+		t.Fatalf("expected the new partial, got %q", m.Body)
+	}
+
+	if err := templates.AddPartial("header", "{{.", ""); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Tracker tracks the messages that have it set when they are opened, by
+// adding an invisible image to their HTML body that is fetched from OpenURL
+// with a token that identifies the message and its recipient, and when
+// their links are clicked, by rewriting them to go through ClickURL with a
+// token that also has the link.
+//
+// The token is the Message-ID and the recipient, which is the only address
+// of the message: messages to several recipients can't tell which of them
+// opened it, and should be sent individually to track each one.
+type Tracker struct {
+	// Key signs the tokens so that they can't be forged. It must be kept
+	// secret, and the same for the server that handles them.
+	Key []byte
+
+	// Opens enables open tracking with an image loaded from OpenURL, to
+	// which the token is appended, like https://track.example.com/open/.
+	Opens   bool
+	OpenURL string
+
+	// Clicks enables click tracking with links to ClickURL, to which the
+	// token is appended, like https://track.example.com/click/. Only http
+	// and https links are tracked.
+	Clicks   bool
+	ClickURL string
+}
+
+// TrackingToken is the data that identifies a tracked message.
+type TrackingToken struct {
+	MessageID string `json:"m"`
+	Recipient string `json:"r,omitempty"`
+
+	// URL is the link that was clicked in click tracking tokens.
+	URL string `json:"u,omitempty"`
+}
+
+// ErrInvalidToken is returned for tracking tokens that are malformed or
+// weren't signed with the key of the Tracker.
+var ErrInvalidToken = errors.New("invalid tracking token")
+
+// Token returns the signed token for t, which is safe to use in URLs.
+func (tr *Tracker) Token(t *TrackingToken) string {
+	payload, _ := json.Marshal(t)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(tr.sign(payload))
+}
+
+// ParseToken verifies a token returned by Token and decodes it.
+func (tr *Tracker) ParseToken(token string) (*TrackingToken, error) {
+	i := strings.Index(token, ".")
+	if i == -1 {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(signature, tr.sign(payload)) {
+		return nil, ErrInvalidToken
+	}
+
+	t := &TrackingToken{}
+	if err := json.Unmarshal(payload, t); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// sign returns the HMAC-SHA256 of payload truncated to 128 bits, which
+// keeps the URLs short.
+func (tr *Tracker) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, tr.Key)
+	mac.Write(payload)
+
+	return mac.Sum(nil)[:16]
+}
+
+// trackingPixel is a transparent 1x1 GIF.
+var trackingPixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+// OpenHandler returns a handler that serves the tracking image at OpenURL
+// and calls opened with the token of each valid request. Requests with an
+// invalid token get the image too, but opened isn't called.
+func (tr *Tracker) OpenHandler(opened func(t *TrackingToken, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t, err := tr.ParseToken(path.Base(r.URL.Path)); err == nil {
+			opened(t, r)
+		}
+
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Write(trackingPixel)
+	})
+}
+
+// ClickHandler returns a handler for ClickURL that calls clicked with the
+// token of each valid request and redirects to the link. Requests with an
+// invalid token get a 404 Not Found, so the handler can't be used to
+// redirect to arbitrary sites.
+func (tr *Tracker) ClickHandler(clicked func(t *TrackingToken, r *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := tr.ParseToken(path.Base(r.URL.Path))
+		if err != nil || !isTrackedLink(t.URL) {
+			http.NotFound(w, r)
+			return
+		}
+
+		clicked(t, r)
+		http.Redirect(w, r, t.URL, http.StatusFound)
+	})
+}
+
+// token returns the token of a message.
+func (tr *Tracker) token(m *Message) *TrackingToken {
+	t := &TrackingToken{MessageID: m.MessageID}
+	if to := m.Tolist(); len(to) == 1 {
+		t.Recipient = to[0]
+	}
+
+	return t
+}
+
+// track rewrites the links of an HTML body to be tracked and adds the
+// tracking image of m, at the end of the <body> element if there is one.
+func (tr *Tracker) track(m *Message, body string) string {
+	if tr.Clicks {
+		body = tr.trackLinks(m, body)
+	}
+
+	if !tr.Opens {
+		return body
+	}
+
+	src := html.EscapeString(tr.OpenURL + tr.Token(tr.token(m)))
+	img := `<img src="` + src + `" width="1" height="1" alt="" style="display:none">`
+
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i != -1 {
+		return body[:i] + img + body[i:]
+	}
+
+	return body + img
+}
+
+// The href must follow a space, so that data-href isn't taken for it.
+var linkHref = regexp.MustCompile(`(?is)(<a\s(?:[^>]*?\s)?href\s*=\s*)("[^"]*"|'[^']*')`)
+
+// trackLinks rewrites the http and https links of an HTML body to go
+// through ClickURL. Links in comments are left alone.
+func (tr *Tracker) trackLinks(m *Message, body string) string {
+	var out strings.Builder
+	last := 0
+
+	for _, skip := range append(htmlComment.FindAllStringIndex(body, -1), []int{len(body), len(body)}) {
+		out.WriteString(linkHref.ReplaceAllStringFunc(body[last:skip[0]], func(link string) string {
+			match := linkHref.FindStringSubmatch(link)
+
+			url := strings.TrimSpace(html.UnescapeString(match[2][1 : len(match[2])-1]))
+			if !isTrackedLink(url) {
+				return link
+			}
+
+			t := tr.token(m)
+			t.URL = url
+
+			return match[1] + `"` + html.EscapeString(tr.ClickURL+tr.Token(t)) + `"`
+		}))
+
+		out.WriteString(body[skip[0]:skip[1]])
+		last = skip[1]
+	}
+
+	return out.String()
+}
+
+func isTrackedLink(url string) bool {
+	url = strings.ToLower(url)
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
@@ -0,0 +1,171 @@
+package email
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTrackingToken(t *testing.T) {
+	tracker := &Tracker{Key: []byte("secret")}
+
+	token := tracker.Token(&TrackingToken{MessageID: "<1@example.com>", Recipient: "to@example.com"})
+
+	parsed, err := tracker.ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.MessageID != "<1@example.com>" || parsed.Recipient != "to@example.com" {
+		t.Fatalf("unexpected token: %+v", parsed)
+	}
+
+	other := &Tracker{Key: []byte("other")}
+	for _, invalid := range []string{"", "abc", token + "x", other.Token(parsed)} {
+		if _, err := tracker.ParseToken(invalid); err != ErrInvalidToken {
+			t.Fatalf("expected ErrInvalidToken for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestOpenTracking(t *testing.T) {
+	tracker := &Tracker{Key: []byte("secret"), Opens: true, OpenURL: "https://track.example.com/open/"}
+
+	m := NewHTMLMessage("Hi", "<html><body><p>this is the body</p></body></html>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Name: "Tom", Email: "to@example.com"}}
+	m.MessageID = "<1@example.com>"
+	m.Tracker = tracker
+
+	body := m.render(m.BodyContentType, m.Body)
+
+	src := regexp.MustCompile(`<img src="https://track.example.com/open/([^"]+)" width="1" height="1" alt="" style="display:none"></body>`).FindStringSubmatch(body)
+	if src == nil {
+		t.Fatalf("expected a tracking image at the end of the body:\n%s", body)
+	}
+
+	var opened *TrackingToken
+	handler := tracker.OpenHandler(func(token *TrackingToken, r *http.Request) {
+		opened = token
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/open/"+src[1], nil))
+
+	if opened == nil || opened.MessageID != "<1@example.com>" || opened.Recipient != "to@example.com" {
+		t.Fatalf("expected the open to be reported, got %+v", opened)
+	}
+
+	if w.Header().Get("Content-Type") != "image/gif" || !strings.HasPrefix(w.Body.String(), "GIF89a") {
+		t.Fatalf("expected the tracking image, got %q", w.Body.String())
+	}
+
+	opened = nil
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/open/forged", nil))
+	if opened != nil {
+		t.Fatal("expected forged tokens to be ignored")
+	}
+
+	m.Cc = []Address{{Email: "cc@example.com"}}
+	token, _ := tracker.ParseToken(tracker.Token(tracker.token(m)))
+	if token.Recipient != "" {
+		t.Fatalf("expected no recipient for a message to several, got %q", token.Recipient)
+	}
+
+	tracker.Opens = false
+	if body := m.render(m.BodyContentType, m.Body); body != m.Body {
+		t.Fatalf("expected no tracking image when disabled:\n%s", body)
+	}
+}
+
+func TestClickTracking(t *testing.T) {
+	tracker := &Tracker{Key: []byte("secret"), Clicks: true, ClickURL: "https://track.example.com/click/"}
+
+	m := NewHTMLMessage("Hi", `<p><a class="button" href="https://example.com/?a=1&amp;b=2">Go</a>
+<a href='mailto:help@example.com'>Help</a> <a href="#top">Top</a>
+<a data-href="https://example.com/data" href="https://example.com/docs">Docs</a>
+<!-- <a href="https://example.com/hidden">Hidden</a> --></p>`)
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.MessageID = "<1@example.com>"
+	m.Tracker = tracker
+
+	body := m.render(m.BodyContentType, m.Body)
+
+	for _, expected := range []string{
+		`<a href='mailto:help@example.com'>`,
+		`<a href="#top">`,
+		`<a href="https://example.com/hidden">`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q to be left alone:\n%s", expected, body)
+		}
+	}
+
+	if !regexp.MustCompile(`<a data-href="https://example.com/data" href="https://track.example.com/click/[^"]+">Docs</a>`).MatchString(body) {
+		t.Fatalf("expected the href to be tracked and data-href to be left alone:\n%s", body)
+	}
+
+	link := regexp.MustCompile(`<a class="button" href="https://track.example.com/click/([^"]+)">Go</a>`).FindStringSubmatch(body)
+	if link == nil {
+		t.Fatalf("expected the link to be tracked:\n%s", body)
+	}
+
+	var clicked *TrackingToken
+	handler := tracker.ClickHandler(func(token *TrackingToken, r *http.Request) {
+		clicked = token
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/click/"+link[1], nil))
+
+	if clicked == nil || clicked.MessageID != "<1@example.com>" || clicked.Recipient != "to@example.com" {
+		t.Fatalf("expected the click to be reported, got %+v", clicked)
+	}
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/?a=1&b=2" {
+		t.Fatalf("expected a redirect to the link, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	forged := (&Tracker{Key: []byte("other")}).Token(&TrackingToken{URL: "https://evil.example.com/"})
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/click/"+forged, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected forged tokens not to redirect, got %d", w.Code)
+	}
+}
+
+func TestAPITracking(t *testing.T) {
+	tracker := &Tracker{Key: []byte("secret"), Opens: true, OpenURL: "https://track.example.com/open/"}
+
+	m := NewHTMLMessage("Hi", "<p>this is the body</p>")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.Tracker = tracker
+
+	payload, err := m.postmark()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := regexp.MustCompile(`<img src="https://track.example.com/open/([^"]+)"`).FindStringSubmatch(payload.HTMLBody)
+	if src == nil {
+		t.Fatalf("expected a tracking image:\n%s", payload.HTMLBody)
+	}
+
+	token, err := tracker.ParseToken(src[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payload.Headers) != 1 || payload.Headers[0].Name != "Message-Id" || token.MessageID == "" ||
+		payload.Headers[0].Value != token.MessageID {
+		t.Fatalf("expected the token to carry the Message-ID that is sent: %+v %+v", token, payload.Headers)
+	}
+
+	if m.MessageID != "" {
+		t.Fatalf("expected the MessageID to stay empty, got %q", m.MessageID)
+	}
+}
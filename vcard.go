@@ -0,0 +1,85 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Contact is a contact card attached to a message as a vCard so recipients
+// can save it to their address book.
+type Contact struct {
+	// Name is the full name as displayed. GivenName and FamilyName are
+	// derived from it when empty.
+	Name       string
+	GivenName  string
+	FamilyName string
+
+	Organization string
+	Title        string
+	Emails       []string
+	Phones       []string
+	URL          string
+}
+
+// VCard returns the contact as a vCard 3.0 object, the version most
+// clients import.
+func (c *Contact) VCard() []byte {
+	given, family := c.GivenName, c.FamilyName
+	if given == "" && family == "" {
+		if i := strings.LastIndex(c.Name, " "); i != -1 {
+			given, family = c.Name[:i], c.Name[i+1:]
+		} else {
+			given = c.Name
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	writeContentLine(buf, "BEGIN:VCARD")
+	writeContentLine(buf, "VERSION:3.0")
+	writeContentLine(buf, "FN:"+escapeText(c.Name))
+	writeContentLine(buf, "N:"+escapeText(family)+";"+escapeText(given)+";;;")
+
+	if c.Organization != "" {
+		writeContentLine(buf, "ORG:"+escapeText(c.Organization))
+	}
+
+	if c.Title != "" {
+		writeContentLine(buf, "TITLE:"+escapeText(c.Title))
+	}
+
+	for _, email := range c.Emails {
+		writeContentLine(buf, "EMAIL;TYPE=INTERNET:"+escapeText(email))
+	}
+
+	for _, phone := range c.Phones {
+		writeContentLine(buf, "TEL;TYPE=VOICE:"+escapeText(phone))
+	}
+
+	if c.URL != "" {
+		writeContentLine(buf, "URL:"+c.URL)
+	}
+
+	writeContentLine(buf, "END:VCARD")
+
+	return buf.Bytes()
+}
+
+// AttachContact attaches the contact as a text/vcard file named after it.
+func (m *Message) AttachContact(c *Contact, options ...AttachOption) {
+	filename := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, c.Name)
+
+	if filename == "" {
+		filename = "contact"
+	}
+
+	options = append([]AttachOption{WithContentType("text/vcard; charset=utf-8")}, options...)
+	m.AttachBytes(filename+".vcf", c.VCard(), options...)
+}
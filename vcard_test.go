@@ -0,0 +1,42 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttachContact(t *testing.T) {
+	m := NewMessage("Welcome", "Save our contact")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+
+	m.AttachContact(&Contact{
+		Name:         "Ada Lovelace",
+		Organization: "Example, Inc.",
+		Emails:       []string{"support@example.com"},
+		Phones:       []string{"+1 555 0100"},
+	})
+
+	a := m.Attachment("Ada Lovelace.vcf")
+	if a == nil {
+		t.Fatal("expected a vCard attachment")
+	}
+
+	for _, line := range []string{
+		"BEGIN:VCARD\r\n",
+		"FN:Ada Lovelace\r\n",
+		"N:Lovelace;Ada;;;\r\n",
+		"ORG:Example\\, Inc.\r\n",
+		"EMAIL;TYPE=INTERNET:support@example.com\r\n",
+		"TEL;TYPE=VOICE:+1 555 0100\r\n",
+		"END:VCARD\r\n",
+	} {
+		if !strings.Contains(string(a.Data), line) {
+			t.Fatalf("expected %q in:\n%s", line, a.Data)
+		}
+	}
+
+	if data := string(m.Bytes()); !strings.Contains(data, "Content-Type: text/vcard; charset=utf-8\r\n") {
+		t.Fatalf("expected a text/vcard part:\n%s", data)
+	}
+}
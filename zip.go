@@ -0,0 +1,91 @@
+// Copyright 2012 Santiago Corredoira
+// Distributed under a BSD-like license.
+package email
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"time"
+)
+
+// ZipArchive bundles attachments into a single zip file when the message is
+// serialized, which keeps messages with many files tidy and under the
+// attachment count limits of some servers.
+type ZipArchive struct {
+	// Filename is the name of the archive, "attachments.zip" if empty.
+	Filename string
+
+	// Level is the compression level, from flate.BestSpeed to
+	// flate.BestCompression. Zero, or an invalid level, means
+	// flate.DefaultCompression.
+	Level int
+
+	// Filenames selects the attachments to bundle. If empty, all the
+	// regular attachments are, but not the embedded or inline ones.
+	Filenames []string
+}
+
+// bundle returns the attachments with the selected ones replaced by the
+// archive, or unchanged if none is selected.
+func (z *ZipArchive) bundle(attachments []*Attachment, modified time.Time) []*Attachment {
+	var bundled, rest []*Attachment
+	for _, attachment := range attachments {
+		if z.selects(attachment) {
+			bundled = append(bundled, attachment)
+		} else {
+			rest = append(rest, attachment)
+		}
+	}
+
+	if len(bundled) == 0 {
+		return attachments
+	}
+
+	level := z.Level
+	if level == 0 || level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	archive := zip.NewWriter(buf)
+	archive.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	// Writing to memory with a valid level can't fail.
+	for _, attachment := range bundled {
+		w, _ := archive.CreateHeader(&zip.FileHeader{
+			Name:     attachment.Filename,
+			Method:   zip.Deflate,
+			Modified: modified,
+		})
+		w.Write(attachment.Data)
+	}
+	archive.Close()
+
+	filename := z.Filename
+	if filename == "" {
+		filename = "attachments.zip"
+	}
+
+	return append(rest, &Attachment{
+		Filename:    filename,
+		Data:        buf.Bytes(),
+		ContentType: "application/zip",
+	})
+}
+
+func (z *ZipArchive) selects(attachment *Attachment) bool {
+	if attachment.Inline || attachment.ContentID != "" {
+		return false
+	}
+
+	if len(z.Filenames) == 0 {
+		return true
+	}
+
+	return containsString(z.Filenames, attachment.Filename)
+}
@@ -0,0 +1,56 @@
+package email
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestZipArchive(t *testing.T) {
+	m := NewMessage("Hi", "this is the body")
+	m.From = Address{Email: "from@example.com"}
+	m.To = []Address{{Email: "to@example.com"}}
+	m.AttachBytes("a.txt", []byte(strings.Repeat("a", 1000)))
+	m.AttachBytes("b.txt", []byte("b"))
+	m.AttachBytes("c.txt", []byte("c"))
+	m.Zip = &ZipArchive{Filename: "files.zip", Level: flate.BestCompression, Filenames: []string{"a.txt", "b.txt"}}
+
+	data := string(m.Bytes())
+	if strings.Contains(data, `filename="a.txt"`) || !strings.Contains(data, `filename="c.txt"`) {
+		t.Fatalf("expected only the selected attachments to be bundled:\n%s", data)
+	}
+
+	part := regexp.MustCompile(`Content-Type: application/zip\r\nContent-Transfer-Encoding: base64\r\nContent-Disposition: attachment; filename="files.zip"\r\n\r\n([A-Za-z0-9+/=\r\n]+)`).FindStringSubmatch(data)
+	if part == nil {
+		t.Fatalf("expected the zip attachment:\n%s", data)
+	}
+
+	archive, err := base64.StdEncoding.DecodeString(strings.Replace(part[1], "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.File) != 2 || r.File[0].Name != "a.txt" || r.File[1].Name != "b.txt" {
+		t.Fatalf("unexpected files in the archive: %v", r.File)
+	}
+
+	f, _ := r.File[0].Open()
+	content, _ := ioutil.ReadAll(f)
+	if string(content) != strings.Repeat("a", 1000) || r.File[0].CompressedSize64 >= 1000 {
+		t.Fatalf("expected the compressed content, got %d bytes", r.File[0].CompressedSize64)
+	}
+
+	if len(m.Attachments) != 3 {
+		t.Fatal("expected the message attachments to be left unchanged")
+	}
+}